@@ -0,0 +1,348 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// ServerConfig is one of EXTRA_SERVERS.
+type ServerConfig struct {
+	Label  string
+	URL    string
+	APIKey string
+}
+
+// Config holds the client settings read from the environment / .env file
+type Config struct {
+	ServerURL string
+	APIKey    string
+	Hostname  string
+	DeviceID  string // Stable identity so the server can replace our stale connections
+
+	// Clips larger than this many bytes are sent through the file transfer path. The
+	// default stays under the server's 256KB MAX_CLIP_BYTES even once base64-encoded
+	ClipFileThreshold int
+	// Where accepted file transfers are saved
+	DownloadDir string
+	// Outgoing file transfer rate cap in KB/s, 0 for unlimited
+	TransferRateKBps int
+	// Ask before a received clip replaces different local clipboard content
+	ConfirmOverwrite bool
+	// Ask where to save each accepted file, starting from DownloadDir; off saves straight there
+	PromptSavePath bool
+	// Write the server's current clip to the local clipboard right after connecting
+	SeedOnConnect bool
+	// Show which device each received history entry came from
+	ShowClipOrigin bool
+
+	// Client certificate for servers that use mutual TLS instead of the API key
+	TLSCertFile string
+	TLSKeyFile  string
+	// Extra CA to trust for the server's certificate, e.g. a private CA
+	TLSCAFile string
+	// Offer permessage-deflate; costs some CPU for less bandwidth
+	Compression bool
+	// flate level for compressed writes, 0 for the library default
+	CompressionLevel int
+	// Clips kept in the history pane; may exceed the server's history
+	HistorySize int
+	// History entries are cut to this many characters in the list, 0 for no limit
+	PreviewLength int
+	// Put between entries joined with JoinMarked; Go escapes such as \n are understood
+	JoinSeparator string
+	// Join entries oldest first rather than in the order they were marked
+	JoinChronological bool
+	// JSON file holding the named snippets library
+	SnippetsFile string
+	// Clipboard channels to cycle through; the first is joined on startup
+	Channels []string
+	// Device groups to join, e.g. phones,work; others can target a whole group
+	Groups []string
+	// Device IDs or hostnames whose file offers are accepted without asking, or rejected
+	TransferAllow []string
+	TransferBlock []string
+	// Connect with a single-use token from /auth/token rather than the API key in the URL
+	SessionToken bool
+	// Which way clips flow: both, push (send only), pull (receive only) or off
+	SyncMode SyncMode
+	// Executable run with each received clip on stdin once it's applied. Off by
+	// default: it hands clipboard contents from other devices to a local program.
+	OnClipChange string
+	// Seconds to wait for the server to answer a connect before giving up and backing off
+	DialTimeout int
+	// Seconds a clip sent with SendExpiring lasts before every device drops it
+	ClipTTL int
+	// Failed reconnects in a row before giving up until ctrl+r; 0 retries forever
+	MaxReconnects int
+	// Milliseconds a local clipboard change must stay unchanged before it's sent, so
+	// intermediate states while pasting and editing quickly aren't synced; 0 is off
+	ClipSettleMS int
+	// Also sync the primary selection (middle-click paste); Linux only
+	SyncPrimary bool
+	// Pause sync while the screen is locked; Linux with systemd-logind only
+	PauseOnLock bool
+	// Convert received clips to this platform's line endings (CRLF on Windows, LF elsewhere)
+	NormalizeEOL bool
+	// Send text clips with LF line endings whatever the local clipboard uses
+	SendLF bool
+	// More servers to connect to alongside ServerURL, as label=url entries; an
+	// apiKey in the URL's query overrides APIKey for that server
+	ExtraServers []ServerConfig
+	// Label for the main server's devices and clips; only shown with ExtraServers
+	ServerLabel string
+	// Seconds without a key press before the TUI dims; 0 never dims
+	DimAfter int
+	// Panes left to right, also the tab order; from PANE_ORDER, e.g. log,history,devices
+	PaneOrder []FocusablePane
+	// Log raw WebSocket frames to the debug log; verbose and includes clip contents
+	TraceWS bool
+}
+
+func loadConfig() Config {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "UnknownHost"
+		log.Println("Warning: Could not get hostname:", err)
+	}
+
+	cfg := Config{
+		ServerURL:         os.Getenv("SERVER_WS_URL"),
+		APIKey:            os.Getenv("CLIPBOARD_API_KEY"),
+		Hostname:          hostname,
+		DeviceID:          envString("DEVICE_ID", loadDeviceID()),
+		ClipFileThreshold: envInt("CLIP_FILE_THRESHOLD", 192*1024),
+		DownloadDir:       envString("DOWNLOAD_DIR", defaultDownloadDir()),
+		TransferRateKBps:  envInt("TRANSFER_RATE_KBPS", 0),
+		ConfirmOverwrite:  envBool("CONFIRM_OVERWRITE", false),
+		PromptSavePath:    envBool("PROMPT_SAVE_PATH", true),
+		SeedOnConnect:     envBool("SEED_ON_CONNECT", false),
+		ShowClipOrigin:    envBool("SHOW_CLIP_ORIGIN", true),
+		TLSCertFile:       os.Getenv("TLS_CLIENT_CERT_FILE"),
+		TLSKeyFile:        os.Getenv("TLS_CLIENT_KEY_FILE"),
+		TLSCAFile:         os.Getenv("TLS_CA_FILE"),
+		SnippetsFile:      envString("SNIPPETS_FILE", defaultSnippetsFile()),
+		HistorySize:       envInt("LOCAL_HISTORY_SIZE", maxHistorySize), // Not HISTORY_SIZE, which the server reads from the same .env
+		Compression:       envBool("WS_COMPRESSION", true),
+		CompressionLevel:  envInt("WS_COMPRESSION_LEVEL", 0),
+		Channels:          envList("CLIP_CHANNELS", []string{"default"}),
+		Groups:            envList("DEVICE_GROUPS", nil),
+		TraceWS:           envBool("TRACE_WS", false),
+		DimAfter:          envInt("DIM_AFTER", 300),
+		DialTimeout:       envInt("DIAL_TIMEOUT", 10),
+		MaxReconnects:     envInt("MAX_RECONNECT_ATTEMPTS", 0),
+		ClipTTL:           envInt("CLIP_TTL", 300),
+		SyncPrimary:       envBool("SYNC_PRIMARY", false),
+		PauseOnLock:       envBool("PAUSE_ON_LOCK", false),
+		NormalizeEOL:      envBool("NORMALIZE_LINE_ENDINGS", false),
+		SendLF:            envBool("SEND_LF_LINE_ENDINGS", false),
+		ClipSettleMS:      envInt("CLIP_SETTLE_MS", 0),
+		ServerLabel:       envString("SERVER_LABEL", "main"),
+		OnClipChange:      os.Getenv("ON_CLIP_CHANGE"),
+		PreviewLength:     envInt("PREVIEW_LENGTH", 120),
+		JoinSeparator:     unescapeSeparator(envString("JOIN_SEPARATOR", `\n`)),
+		SessionToken:      envBool("SESSION_TOKEN", false),
+		TransferAllow:     envList("TRANSFER_ALLOW", nil),
+		TransferBlock:     envList("TRANSFER_BLOCK", nil),
+	}
+	switch order := envString("JOIN_ORDER", "marked"); order {
+	case "marked":
+	case "oldest":
+		cfg.JoinChronological = true
+	default:
+		log.Printf("Warning: invalid JOIN_ORDER=%q, using marked", order)
+	}
+	mode, ok := parseSyncMode(envString("SYNC_MODE", "both"))
+	if !ok {
+		log.Printf("Warning: invalid SYNC_MODE=%q, using both", os.Getenv("SYNC_MODE"))
+	}
+	cfg.SyncMode = mode
+	for _, entry := range envList("EXTRA_SERVERS", nil) {
+		s, err := parseServerConfig(entry, cfg.APIKey)
+		if err != nil {
+			log.Printf("Warning: ignoring EXTRA_SERVERS entry %q: %v", entry, err)
+			continue
+		}
+		cfg.ExtraServers = append(cfg.ExtraServers, s)
+	}
+	if cfg.DialTimeout < 1 {
+		log.Printf("Warning: DIAL_TIMEOUT must be at least 1 second, using 10")
+		cfg.DialTimeout = 10
+	}
+	if cfg.ClipSettleMS < 0 {
+		log.Printf("Warning: CLIP_SETTLE_MS can't be negative, turning it off")
+		cfg.ClipSettleMS = 0
+	}
+	order, err := parsePaneOrder(envList("PANE_ORDER", []string{"history", "devices", "log"}))
+	if err != nil {
+		log.Printf("Warning: invalid PANE_ORDER=%q (%v), using history,devices,log", os.Getenv("PANE_ORDER"), err)
+		order = []FocusablePane{HistoryPane, DevicesPane, LogPane}
+	}
+	cfg.PaneOrder = order
+	if cfg.DimAfter < 0 {
+		log.Printf("Warning: DIM_AFTER can't be negative, never dimming")
+		cfg.DimAfter = 0
+	}
+	if cfg.MaxReconnects < 0 {
+		log.Printf("Warning: MAX_RECONNECT_ATTEMPTS can't be negative, retrying forever")
+		cfg.MaxReconnects = 0
+	}
+	if cfg.HistorySize < 1 {
+		log.Printf("Warning: LOCAL_HISTORY_SIZE must be at least 1, using %d", maxHistorySize)
+		cfg.HistorySize = maxHistorySize
+	}
+	return cfg
+}
+
+// parseServerConfig reads a label=url EXTRA_SERVERS entry. The API key comes
+// out of the URL, since connectCmd sets its own.
+func parseServerConfig(entry, defaultKey string) (ServerConfig, error) {
+	label, rawURL, ok := strings.Cut(entry, "=")
+	label = strings.TrimSpace(label)
+	if !ok || label == "" {
+		return ServerConfig{}, fmt.Errorf("want label=url")
+	}
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil || (u.Scheme != "ws" && u.Scheme != "wss") {
+		return ServerConfig{}, fmt.Errorf("want a ws:// or wss:// URL")
+	}
+	key := defaultKey
+	q := u.Query()
+	if k := q.Get("apiKey"); k != "" {
+		key = k
+		q.Del("apiKey")
+		u.RawQuery = q.Encode()
+	}
+	return ServerConfig{Label: label, URL: u.String(), APIKey: key}, nil
+}
+
+// tlsConfig builds the dialer's TLS config, or returns nil to use the defaults
+func (c Config) tlsConfig() (*tls.Config, error) {
+	if c.TLSCertFile == "" && c.TLSCAFile == "" {
+		return nil, nil
+	}
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if c.TLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.TLSCertFile, c.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	if c.TLSCAFile != "" {
+		pem, err := os.ReadFile(c.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", c.TLSCAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	return cfg, nil
+}
+
+// envString reads a string env var, falling back to def if unset
+func envString(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+// envBool reads a boolean env var (1/true/yes...), falling back to def if unset or invalid
+func envBool(name string, def bool) bool {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Printf("Warning: invalid %s=%q, using default %v", name, v, def)
+		return def
+	}
+	return b
+}
+
+// envList reads a comma-separated env var, falling back to def if unset or empty
+func envList(name string, def []string) []string {
+	var list []string
+	for _, v := range strings.Split(os.Getenv(name), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	if len(list) == 0 {
+		return def
+	}
+	return list
+}
+
+// envInt reads an integer env var, falling back to def if unset or invalid
+func envInt(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("Warning: invalid %s=%q, using default %d", name, v, def)
+		return def
+	}
+	return n
+}
+
+// unescapeSeparator reads escapes like \n and \t in a separator, which .env files
+// can't otherwise express; anything that isn't a valid Go string body is used as is.
+func unescapeSeparator(s string) string {
+	if u, err := strconv.Unquote(`"` + s + `"`); err == nil {
+		return u
+	}
+	return s
+}
+
+// loadDeviceID returns the UUID persisted in the config dir, creating it on first run.
+// If it can't be persisted, a fresh ID is used for this session only.
+func loadDeviceID() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		log.Println("Warning: Could not get home dir for device ID:", err)
+		return uuid.NewString()
+	}
+	path := filepath.Join(home, ".config", "sync-clipboard-tui", "device-id")
+	if b, err := os.ReadFile(path); err == nil {
+		if id := strings.TrimSpace(string(b)); id != "" {
+			return id
+		}
+	}
+	id := uuid.NewString()
+	if err := os.WriteFile(path, []byte(id+"\n"), 0600); err != nil {
+		log.Println("Warning: Could not save device ID:", err)
+	}
+	return id
+}
+
+func defaultSnippetsFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "snippets.json"
+	}
+	return filepath.Join(home, ".config", "sync-clipboard-tui", "snippets.json")
+}
+
+func defaultDownloadDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return os.TempDir()
+	}
+	return filepath.Join(home, "Downloads")
+}
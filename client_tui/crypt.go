@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// With ENCRYPT_LOCAL_FILES, files in the config dir that can hold clip contents
+// (debug.log and the snippets file) are encrypted with AES-GCM under a key derived
+// from LOCAL_FILES_PASSPHRASE. Each record is one "enc1:" line, so plaintext
+// written before encryption was turned on can share a file with encrypted lines
+// and still be read. The salt is generated once per config dir.
+
+const (
+	sealedPrefix   = "enc1:"
+	kdfIterations  = 600_000 // PBKDF2-HMAC-SHA256, per OWASP's current advice
+	localSaltBytes = 16
+)
+
+var (
+	// localFiles seals and opens local files; nil when no passphrase is set
+	localFiles cipher.AEAD
+	// sealWrites is ENCRYPT_LOCAL_FILES: whether new writes are encrypted
+	sealWrites bool
+)
+
+// setupLocalEncryption derives the key for localFiles. A passphrase without
+// ENCRYPT_LOCAL_FILES still sets it up, so files encrypted earlier stay readable;
+// only new writes are left in plaintext.
+func setupLocalEncryption(configDir string) error {
+	enabled := envBool("ENCRYPT_LOCAL_FILES", false)
+	passphrase := os.Getenv("LOCAL_FILES_PASSPHRASE")
+	if passphrase == "" {
+		if enabled {
+			return errors.New("ENCRYPT_LOCAL_FILES is on but LOCAL_FILES_PASSPHRASE is not set")
+		}
+		return nil
+	}
+	salt, err := localSalt(filepath.Join(configDir, "local-files.salt"))
+	if err != nil {
+		return err
+	}
+	aead, err := newLocalCipher(passphrase, salt)
+	if err != nil {
+		return err
+	}
+	localFiles = aead
+	sealWrites = enabled
+	return nil
+}
+
+// newLocalCipher derives the AES-256-GCM cipher for a passphrase and salt.
+func newLocalCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(pbkdf2.Key([]byte(passphrase), salt, kdfIterations, 32, sha256.New))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// localSalt reads the config dir's salt, creating it on first use.
+func localSalt(path string) ([]byte, error) {
+	if b, err := os.ReadFile(path); err == nil && len(b) == localSaltBytes {
+		return b, nil
+	}
+	salt := make([]byte, localSaltBytes)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	// O_EXCL so a salt already in use is never replaced, which would lock out its files
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("creating %s: %w", path, err)
+	}
+	defer f.Close()
+	if _, err := f.Write(salt); err != nil {
+		return nil, err
+	}
+	return salt, nil
+}
+
+// sealLine encrypts plaintext into one "enc1:" line, without a newline.
+func sealLine(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, localFiles.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err // Never seal under a zero nonce; GCM can't survive reuse
+	}
+	sealed := localFiles.Seal(nonce, nonce, plaintext, nil)
+	return []byte(sealedPrefix + base64.StdEncoding.EncodeToString(sealed)), nil
+}
+
+// openLine decrypts an "enc1:" line; anything else is returned as is.
+func openLine(line []byte) ([]byte, error) {
+	rest, ok := strings.CutPrefix(string(line), sealedPrefix)
+	if !ok {
+		return line, nil
+	}
+	if localFiles == nil {
+		return nil, errors.New("file is encrypted; set LOCAL_FILES_PASSPHRASE")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(rest)
+	if err != nil || len(sealed) < localFiles.NonceSize() {
+		return nil, errors.New("corrupt encrypted record")
+	}
+	n := localFiles.NonceSize()
+	plaintext, err := localFiles.Open(nil, sealed[:n], sealed[n:], nil)
+	if err != nil {
+		return nil, errors.New("cannot decrypt; wrong LOCAL_FILES_PASSPHRASE?")
+	}
+	return plaintext, nil
+}
+
+// sealedLog is the debug log's writer with ENCRYPT_LOCAL_FILES. The log package
+// makes one Write per record, so each record becomes one line.
+type sealedLog struct {
+	w io.Writer
+}
+
+func (s sealedLog) Write(p []byte) (int, error) {
+	line, err := sealLine([]byte(strings.TrimSuffix(string(p), "\n")))
+	if err != nil {
+		return 0, err
+	}
+	if _, err := s.w.Write(append(line, '\n')); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// decryptLog writes the debug log at path to w, decrypting encrypted lines.
+func decryptLog(path string, w io.Writer) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024) // TRACE_WS lines hold whole frames
+	for n := 1; sc.Scan(); n++ {
+		line, err := openLine(sc.Bytes())
+		if err != nil {
+			return fmt.Errorf("line %d: %w", n, err)
+		}
+		fmt.Fprintf(w, "%s\n", line)
+	}
+	return sc.Err()
+}
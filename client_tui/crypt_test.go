@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Known answer from RFC 7914 section 11 (PBKDF2-HMAC-SHA256, P="passwd", S="salt", c=1).
+func TestPBKDF2KnownAnswer(t *testing.T) {
+	got := hex.EncodeToString(pbkdf2.Key([]byte("passwd"), []byte("salt"), 1, 64, sha256.New))
+	want := "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc" +
+		"49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"
+	if got != want {
+		t.Errorf("PBKDF2 = %s, want %s", got, want)
+	}
+}
+
+func TestSealOpenRoundTrip(t *testing.T) {
+	defer func(old cipher.AEAD) { localFiles = old }(localFiles)
+	aead, err := newLocalCipher("correct horse", []byte("0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	localFiles = aead
+
+	plaintext := []byte("clip contents\twith tabs")
+	line, err := sealLine(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(line, []byte(sealedPrefix)) || bytes.Contains(line, plaintext) {
+		t.Fatalf("sealed line %q doesn't look sealed", line)
+	}
+	again, _ := sealLine(plaintext)
+	if bytes.Equal(line, again) {
+		t.Error("sealing twice gave the same line; nonces must differ")
+	}
+	got, err := openLine(line)
+	if err != nil || !bytes.Equal(got, plaintext) {
+		t.Fatalf("openLine = %q, %v; want %q", got, err, plaintext)
+	}
+
+	// Plaintext lines pass through; a wrong passphrase is an error
+	if got, err := openLine([]byte("old plain line")); err != nil || string(got) != "old plain line" {
+		t.Errorf("plain line = %q, %v", got, err)
+	}
+	if localFiles, err = newLocalCipher("wrong", []byte("0123456789abcdef")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openLine(line); err == nil {
+		t.Error("opened a line with the wrong passphrase")
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffLine is one line of a unified diff: Op is ' ', '-' or '+'
+type diffLine struct {
+	Op   byte
+	Text string
+}
+
+// maxDiffCells caps the LCS table (lines of a times lines of b); beyond it the
+// differing middle is shown as a whole block removed and added.
+const maxDiffCells = 4_000_000
+
+// lineDiff diffs a and b line by line using a longest common subsequence.
+func lineDiff(a, b string) []diffLine {
+	al, bl := strings.Split(a, "\n"), strings.Split(b, "\n")
+
+	// Common prefix and suffix are cheap and keep the table small for typical edits
+	pre := 0
+	for pre < len(al) && pre < len(bl) && al[pre] == bl[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(al)-pre && suf < len(bl)-pre && al[len(al)-1-suf] == bl[len(bl)-1-suf] {
+		suf++
+	}
+	var out []diffLine
+	for _, l := range al[:pre] {
+		out = append(out, diffLine{' ', l})
+	}
+	out = append(out, lcsDiff(al[pre:len(al)-suf], bl[pre:len(bl)-suf])...)
+	for _, l := range al[len(al)-suf:] {
+		out = append(out, diffLine{' ', l})
+	}
+	return out
+}
+
+func lcsDiff(a, b []string) []diffLine {
+	var out []diffLine
+	if len(a)*len(b) > maxDiffCells {
+		for _, l := range a {
+			out = append(out, diffLine{'-', l})
+		}
+		for _, l := range b {
+			out = append(out, diffLine{'+', l})
+		}
+		return out
+	}
+	// lcs[i][j] is the LCS length of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			out = append(out, diffLine{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			out = append(out, diffLine{'-', a[i]})
+			i++
+		default:
+			out = append(out, diffLine{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		out = append(out, diffLine{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		out = append(out, diffLine{'+', b[j]})
+	}
+	return out
+}
+
+// openDiffModal shows a unified diff from the marked entry to the selected one
+// in the content modal. CopyShown copies the diff as plain text.
+func (m *Model) openDiffModal(from, to historyItem) {
+	lines := lineDiff(from.Content, to.Content)
+	var styled, plain strings.Builder
+	added, removed := 0, 0
+	for _, l := range lines {
+		text := string(l.Op) + " " + sanitizeForDisplay(l.Text)
+		switch l.Op {
+		case '+':
+			added++
+			styled.WriteString(diffAddStyle.Render(text))
+		case '-':
+			removed++
+			styled.WriteString(diffDelStyle.Render(text))
+		default:
+			styled.WriteString(text)
+		}
+		styled.WriteByte('\n')
+		plain.WriteString(string(l.Op) + " " + l.Text + "\n")
+	}
+	m.contentHeader = fmt.Sprintf(" Diff | +%d -%d lines | c to copy, esc to close ", added, removed)
+	m.contentView.SetContent(styled.String())
+	m.contentView.GotoTop()
+	m.contentClip = plain.String()
+	m.showContent = true
+}
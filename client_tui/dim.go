@@ -0,0 +1,44 @@
+package main
+
+import (
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/x/ansi"
+)
+
+// The TUI dims after dimAfter without a key press (DIM_AFTER), so it's plain at a
+// glance whether the session is being used. The next key only wakes it.
+
+type DimCheckMsg struct{}
+
+func dimCheck(after time.Duration) tea.Cmd {
+	return tea.Tick(after, func(time.Time) tea.Msg { return DimCheckMsg{} })
+}
+
+// checkDim dims the TUI if there's been no input for dimAfter, or checks again when
+// that will be the case. Only one check is pending at a time; a wake-up starts the next.
+func (m *Model) checkDim() tea.Cmd {
+	idle := time.Since(m.lastInput)
+	if idle < m.dimAfter {
+		return dimCheck(m.dimAfter - idle)
+	}
+	m.dimmed = true
+	return nil
+}
+
+// noteInput records a key press, waking the TUI if it was dimmed. It reports
+// whether it woke it, in which case the key should go no further.
+func (m *Model) noteInput() (woke bool, cmd tea.Cmd) {
+	m.lastInput = time.Now()
+	if !m.dimmed {
+		return false, nil
+	}
+	m.dimmed = false
+	return true, dimCheck(m.dimAfter)
+}
+
+// dimView renders the whole screen in the muted colour, dropping its own styling.
+func dimView(v string) string {
+	return dimStyle.Render(ansi.Strip(v))
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// mdnsService must match the type the server advertises with MDNS_ADVERTISE
+const mdnsService = "_clipd._tcp.local."
+
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// discoverServer looks for a clipd server on the LAN via mDNS and returns its
+// WebSocket URL. The query goes out from an ephemeral port, so responders reply
+// straight to us by unicast.
+func discoverServer(timeout time.Duration) (string, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	query, err := mdnsQuery()
+	if err != nil {
+		return "", err
+	}
+	if _, err := conn.WriteToUDP(query, mdnsGroup); err != nil {
+		return "", fmt.Errorf("sending mDNS query: %w", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 9000)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				return "", fmt.Errorf("no clipd server answered within %s", timeout)
+			}
+			return "", err
+		}
+		if u, ok := parseMDNSResponse(buf[:n]); ok {
+			return u, nil
+		}
+	}
+}
+
+func mdnsQuery() ([]byte, error) {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: uint16(time.Now().UnixNano())})
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	q := dnsmessage.Question{Name: dnsmessage.MustNewName(mdnsService), Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET}
+	if err := b.Question(q); err != nil {
+		return nil, err
+	}
+	return b.Finish()
+}
+
+// parseMDNSResponse builds a ws:// or wss:// URL from a response carrying the
+// service's SRV record and an address for its target.
+func parseMDNSResponse(msg []byte) (string, bool) {
+	var p dnsmessage.Parser
+	hdr, err := p.Start(msg)
+	if err != nil || !hdr.Response {
+		return "", false
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return "", false
+	}
+	var records []dnsmessage.Resource
+	for _, section := range []func() ([]dnsmessage.Resource, error){p.AllAnswers, p.AllAuthorities, p.AllAdditionals} {
+		rs, err := section()
+		if err != nil {
+			return "", false
+		}
+		records = append(records, rs...)
+	}
+
+	var srv *dnsmessage.SRVResource
+	path, scheme := "/ws", "ws"
+	addrs := make(map[string]net.IP)
+	for _, r := range records {
+		switch body := r.Body.(type) {
+		case *dnsmessage.SRVResource:
+			if strings.HasSuffix(r.Header.Name.String(), "."+mdnsService) {
+				srv = body
+			}
+		case *dnsmessage.TXTResource:
+			for _, kv := range body.TXT {
+				switch k, v, _ := strings.Cut(kv, "="); k {
+				case "path":
+					path = v
+				case "tls":
+					if v == "1" {
+						scheme = "wss"
+					}
+				}
+			}
+		case *dnsmessage.AResource:
+			addrs[r.Header.Name.String()] = net.IP(body.A[:])
+		}
+	}
+	if srv == nil {
+		return "", false
+	}
+	host := strings.TrimSuffix(srv.Target.String(), ".")
+	if ip, ok := addrs[srv.Target.String()]; ok && scheme == "ws" {
+		host = ip.String() // Certificates name the host, so only use the IP without TLS
+	} else if !ok && scheme == "ws" {
+		return "", false
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, net.JoinHostPort(host, fmt.Sprint(srv.Port)), path), true
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"github.com/charmbracelet/bubbles/list"
+)
+
+// Favorite entries can be shown on their own in the History pane. The favorites
+// live on Model by content, like join marks, so they survive the list being
+// rebuilt; historyItem.Favorite only mirrors them for display. While only
+// favorites are shown, histAll holds the full history and histList a subset.
+
+// fullHistory puts the full history back into histList for a change made while
+// only favorites are shown, and returns the func that filters it again. Nested
+// calls are no-ops, so history helpers can call each other.
+func (m *Model) fullHistory() (restore func()) {
+	if !m.favoritesOnly || m.histSwapped {
+		return func() {}
+	}
+	m.histSwapped = true
+	m.setHistoryItems(m.histAll)
+	return func() {
+		m.histSwapped = false
+		m.histAll = m.histList.Items()
+		m.setHistoryItems(favoriteItems(m.histAll))
+	}
+}
+
+// historyItems returns every history entry, including any the favorites view hides.
+func (m *Model) historyItems() []list.Item {
+	if m.favoritesOnly && !m.histSwapped {
+		return m.histAll
+	}
+	return m.histList.Items()
+}
+
+func favoriteItems(items []list.Item) []list.Item {
+	var favs []list.Item
+	for _, it := range items {
+		if h, ok := it.(historyItem); ok && h.Favorite {
+			favs = append(favs, it)
+		}
+	}
+	return favs
+}
+
+// setHistoryItems replaces histList's items, keeping the selected entry selected
+// if it's still listed.
+func (m *Model) setHistoryItems(items []list.Item) {
+	selected, hadSelection := m.histList.SelectedItem().(historyItem)
+	m.histList.SetItems(items)
+	if !hadSelection {
+		return
+	}
+	for i, it := range m.histList.VisibleItems() {
+		if h, ok := it.(historyItem); ok && h.Content == selected.Content {
+			m.histList.Select(i)
+			return
+		}
+	}
+}
+
+// toggleFavorite adds the selected history entry to the favorites or takes it out.
+// In the favorites view an entry taken out disappears from the pane.
+func (m *Model) toggleFavorite() {
+	item, ok := m.histList.SelectedItem().(historyItem)
+	if !ok {
+		return
+	}
+	defer m.fullHistory()()
+	item.Favorite = !m.favorites[item.Content]
+	if item.Favorite {
+		m.favorites[item.Content] = true
+	} else {
+		delete(m.favorites, item.Content)
+	}
+	for i, it := range m.histList.Items() {
+		if h, ok := it.(historyItem); ok && h.Content == item.Content {
+			h.Favorite = item.Favorite
+			m.histList.SetItem(i, h)
+			return
+		}
+	}
+}
+
+// toggleFavoritesView switches the History pane between all entries and just the
+// favorites. Clips arriving meanwhile still go into the full history.
+func (m *Model) toggleFavoritesView() {
+	if m.favoritesOnly {
+		m.favoritesOnly = false
+		m.setHistoryItems(m.histAll)
+		m.histAll = nil
+		m.logf("Showing all history.")
+		return
+	}
+	m.histAll = m.histList.Items()
+	m.favoritesOnly = true
+	favs := favoriteItems(m.histAll)
+	m.setHistoryItems(favs)
+	m.logf("Showing %d favorite(s); press %s again for all history.", len(favs), m.keys.FavoritesView.Help().Key)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// deviceGroups are the groups this device joins on connect (DEVICE_GROUPS), set
+// in main. Other devices can then push clips and offer files to a whole group.
+var deviceGroups []string
+
+// groupItem heads a group's devices in the Devices pane. Selecting it targets the
+// whole group: x offers a file, ctrl+g pushes the clipboard.
+type groupItem struct {
+	Name      string
+	Members   int
+	Collapsed bool
+}
+
+func (g groupItem) FilterValue() string { return g.Name }
+func (g groupItem) Title() string {
+	fold := "▾"
+	if g.Collapsed {
+		fold = "▸"
+	}
+	return fmt.Sprintf("%s %s (%d)", fold, g.Name, g.Members)
+}
+func (g groupItem) Description() string { return "Group" }
+
+// groupDevices lays devices out under a header per group, in name order. A device
+// in several groups is listed under each; ungrouped devices follow the groups.
+func (m *Model) groupDevices(devices []ClientInfo) []list.Item {
+	members := make(map[string][]ClientInfo)
+	var names []string
+	var ungrouped []list.Item
+	for _, d := range devices {
+		if len(d.Groups) == 0 {
+			ungrouped = append(ungrouped, deviceItem(d))
+			continue
+		}
+		for _, g := range d.Groups {
+			if _, ok := members[g]; !ok {
+				names = append(names, g)
+			}
+			members[g] = append(members[g], d)
+		}
+	}
+	sort.Strings(names)
+
+	items := make([]list.Item, 0, len(devices)+len(names))
+	for _, g := range names {
+		items = append(items, groupItem{Name: g, Members: len(members[g]), Collapsed: m.collapsedGroups[g]})
+		if m.collapsedGroups[g] {
+			continue
+		}
+		for _, d := range members[g] {
+			items = append(items, deviceItem(d))
+		}
+	}
+	return append(items, ungrouped...)
+}
+
+// pushToGroup sends the local clipboard to the devices in group only. It's an
+// explicit action, so it goes out whether or not sync is on.
+func (m *Model) pushToGroup(group string) tea.Cmd {
+	content := m.lastLocalClip
+	switch {
+	case m.connectedState != Connected:
+		m.logf("Not connected; cannot push to group %s.", group)
+		return nil
+	case content == "":
+		m.logf("Clipboard is empty, nothing to push.")
+		return nil
+	case m.sendsAsFile(content):
+		m.logf("Clip of %d bytes is too large to push; send it to the group as a file with x.", len(content))
+		return nil
+	}
+	data := newClipboardUpdateData(content)
+	data.TargetGroup = group
+	m.clipsSent++
+	m.logf("Pushed clipboard to group %s (%d bytes)", group, len(content))
+	return sendWebsocketMessageCmd(m.wsConn, BaseMessage{Type: "clipboard_update", Data: data})
+}
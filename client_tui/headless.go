@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Headless mode (--headless) runs the client without the TUI, e.g. as a service
+// or at the end of a pipeline. The Model runs as usual with no renderer and no
+// keyboard, so anything that waits for a key, like file offers, just queues.
+// Log lines go to stdout, or with --json, one JSON event per line instead.
+
+var (
+	headless     bool
+	jsonEvents   *json.Encoder // Set by --json; nil prints plain log lines
+	eventContent bool          // --json-content: put clip contents in clip events, off for privacy
+)
+
+// headlessEvent is one line of --json output. Type is "connection", "clip" or
+// "devices"; only the fields for that type are set.
+type headlessEvent struct {
+	Time    time.Time    `json:"time"`
+	Type    string       `json:"type"`
+	State   string       `json:"state,omitempty"`   // connection: Connecting, Connected or Disconnected
+	Error   string       `json:"error,omitempty"`   // connection: why it dropped or failed
+	From    string       `json:"from,omitempty"`    // clip: sending device, when SHOW_CLIP_ORIGIN is on
+	Seq     int64        `json:"seq,omitempty"`     // clip: server sequence number, if any
+	Bytes   int          `json:"bytes,omitempty"`   // clip: content length, absent for a cleared clipboard
+	Content *string      `json:"content,omitempty"` // clip: only with --json-content
+	Devices []ClientInfo `json:"devices,omitempty"` // devices: the full list after the change
+}
+
+// emitEvent writes e to stdout in --json mode and does nothing otherwise.
+func emitEvent(e headlessEvent) {
+	if jsonEvents == nil {
+		return
+	}
+	e.Time = time.Now()
+	if err := jsonEvents.Encode(e); err != nil {
+		log.Printf("Error writing JSON event: %v", err)
+	}
+}
+
+// emitClipEvent reports a received clip, with its content only if asked for.
+func emitClipEvent(item historyItem) {
+	e := headlessEvent{Type: "clip", From: item.Origin, Seq: item.Seq, Bytes: len(item.Content)}
+	if eventContent {
+		e.Content = &item.Content
+	}
+	emitEvent(e)
+}
+
+// headlessLog prints a log pane line to stdout in plain headless mode.
+func headlessLog(line string) {
+	if headless && jsonEvents == nil {
+		fmt.Println(line)
+	}
+}
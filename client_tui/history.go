@@ -0,0 +1,320 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// addHistoryEntry puts an entry into histList, dropping any older copy of the same
+// content and trimming to historyCap. Entries go on top unless they carry a
+// sequence number older than entries already listed, so updates that race each
+// other still end up in server order.
+func (m *Model) addHistoryEntry(item historyItem) {
+	defer m.fullHistory()()
+	for i, it := range m.histList.Items() {
+		if h, ok := it.(historyItem); ok && h.Content == item.Content {
+			item.Local = item.Local || h.Local
+			if item.Origin == "" {
+				item.Origin = h.Origin
+			}
+			if h.Seq > item.Seq {
+				item.Seq = h.Seq
+			}
+			m.histList.RemoveItem(i)
+			break
+		}
+	}
+	if item.Seq > m.lastSeq {
+		m.lastSeq = item.Seq
+	}
+
+	item.Mark = m.joinMarkOf(item.Content)
+	item.Favorite = m.favorites[item.Content]
+	pos := 0
+	if item.Seq > 0 {
+		for i, it := range m.histList.Items() {
+			if h, ok := it.(historyItem); ok && h.Seq > item.Seq {
+				pos = i + 1 // Below the last newer entry
+			}
+		}
+	}
+	m.histList.InsertItem(pos, item)
+	if len(m.histList.Items()) > m.historyCap {
+		m.histList.RemoveItem(len(m.histList.Items()) - 1)
+	}
+}
+
+// numberLocalEntry gives a locally added entry the Seq the server assigned it, which
+// also moves it below any clips from other devices the server accepted first.
+// Entries deleted in the meantime stay deleted.
+func (m *Model) numberLocalEntry(content string, seq int64) {
+	defer m.fullHistory()()
+	for _, it := range m.histList.Items() {
+		if h, ok := it.(historyItem); ok && h.Content == content {
+			h.Seq = seq
+			m.addHistoryEntry(h)
+			return
+		}
+	}
+}
+
+// forgetSeqs drops the sequence numbers from a previous run of the server, which
+// numbers clips from 1 again after a restart. Listed entries keep their order;
+// the server's first page renumbers the ones it still has.
+func (m *Model) forgetSeqs() {
+	defer m.fullHistory()()
+	m.lastSeq, m.lastSentSeq = 0, 0
+	for i, it := range m.histList.Items() {
+		if h, ok := it.(historyItem); ok && h.Seq != 0 {
+			h.Seq = 0
+			m.histList.SetItem(i, h)
+		}
+	}
+}
+
+// conflictWindow is how long after sending a clip a different one arriving
+// unacknowledged counts as a race with it. Servers too old to send clipboard_ack
+// would otherwise have every later clip flagged.
+const conflictWindow = 5 * time.Second
+
+// racedWithSend reports whether a remote clip crossed paths with the last one we
+// sent: it arrived before the server acknowledged ours, or the server accepted it
+// before ours and it was delivered late. Either way applying it loses our copy
+// from the clipboard, and the devices may disagree about which clip is current.
+func (m *Model) racedWithSend(item historyItem) bool {
+	if m.lastSentClip == "" || item.Content == m.lastSentClip {
+		return false
+	}
+	if m.lastSentSeq == 0 {
+		return !m.lastSentAt.IsZero() && time.Since(m.lastSentAt) < conflictWindow
+	}
+	return item.Seq > 0 && item.Seq < m.lastSentSeq
+}
+
+// clipDigest must match the server's: a short hash of the content as sent.
+func clipDigest(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:8])
+}
+
+// ackTimeout is how long a sent clip waits for its clipboard_ack. Some never get
+// one: servers that predate acks, rejected clips and dropped empty ones.
+const ackTimeout = time.Minute
+
+// pendingAck is a sent clip waiting for the server to number it.
+type pendingAck struct {
+	Content string
+	Sent    time.Time
+}
+
+// expectAck records a clip being sent so its clipboard_ack can number the local
+// history entry, and forgets clips whose ack is overdue.
+func (m *Model) expectAck(data ClipboardUpdateData, content string) {
+	now := time.Now()
+	for digest, p := range m.pendingAcks {
+		if now.Sub(p.Sent) > ackTimeout {
+			delete(m.pendingAcks, digest)
+		}
+	}
+	m.pendingAcks[clipDigest(data.Content)] = pendingAck{Content: content, Sent: now}
+}
+
+// removeHistoryEntry drops the entry with the given content, if present, and
+// reports whether it was. Matching on content keeps this correct when the list has
+// shifted under a concurrent delete.
+func (m *Model) removeHistoryEntry(content string) bool {
+	defer m.fullHistory()()
+	for i, it := range m.histList.Items() {
+		if h, ok := it.(historyItem); ok && h.Content == content {
+			m.histList.RemoveItem(i)
+			delete(m.favorites, content)
+			m.refreshJoinMarks()
+			return true
+		}
+	}
+	return false
+}
+
+// mergeServerHistory merges the first page of the server's history into histList.
+// Local entries the server hasn't seen (copied while offline) stay on top since
+// they're newer; older entries the server no longer has stay below its page, up
+// to the local history size, which may be larger than the server's. The selected
+// entry stays selected, so a reconnect doesn't jump the cursor back to the top.
+func (m *Model) mergeServerHistory(history []HistoryEntryData) {
+	defer m.fullHistory()()
+	onServer := make(map[string]bool, len(history))
+	for _, h := range history {
+		onServer[h.Content] = true
+		if h.Seq > m.lastSeq {
+			m.lastSeq = h.Seq
+		}
+	}
+
+	listed := make(map[string]historyItem)
+	var localOnly, older []list.Item
+	for _, it := range m.histList.Items() {
+		h, ok := it.(historyItem)
+		if !ok {
+			continue
+		}
+		listed[h.Content] = h
+		switch {
+		case onServer[h.Content]:
+			// Placed by the server's page below
+		case h.Local:
+			localOnly = append(localOnly, h)
+		default:
+			older = append(older, h)
+		}
+	}
+
+	merged := localOnly
+	seen := make(map[string]bool, len(history))
+	for _, h := range history {
+		if seen[h.Content] {
+			continue
+		}
+		seen[h.Content] = true
+		prev := listed[h.Content]
+		merged = append(merged, historyItem{Content: h.Content, Local: prev.Local, Seq: h.Seq, Origin: prev.Origin, Mark: prev.Mark, Favorite: m.favorites[h.Content]})
+	}
+	m.historyCap = m.historySize
+	if len(merged) > m.historyCap {
+		m.historyCap = len(merged) // Room for the first page plus offline copies
+	}
+	merged = append(merged, older...)
+	if len(merged) > m.historyCap {
+		merged = merged[:m.historyCap]
+	}
+
+	index := m.histList.Index()
+	selected, hadSelection := m.histList.SelectedItem().(historyItem)
+	m.histList.SetItems(merged)
+	if hadSelection {
+		for i, it := range m.histList.VisibleItems() {
+			if h, ok := it.(historyItem); ok && h.Content == selected.Content {
+				index = i
+				break
+			}
+		}
+	}
+	m.histList.Select(min(index, max(len(m.histList.VisibleItems())-1, 0)))
+}
+
+// appendServerHistory adds an older page of server history below what's listed.
+func (m *Model) appendServerHistory(history []HistoryEntryData) {
+	defer m.fullHistory()()
+	listed := make(map[string]bool)
+	for _, it := range m.histList.Items() {
+		if h, ok := it.(historyItem); ok {
+			listed[h.Content] = true
+		}
+	}
+	for _, h := range history {
+		if listed[h.Content] {
+			continue // Pages shift as new clips arrive, so overlap is expected
+		}
+		listed[h.Content] = true
+		m.histList.InsertItem(len(m.histList.Items()), historyItem{Content: h.Content, Seq: h.Seq, Favorite: m.favorites[h.Content]})
+		m.historyCap++
+	}
+}
+
+// loadMoreHistory requests the next page of server history once the selection
+// reaches the end of the list.
+func (m *Model) loadMoreHistory() tea.Cmd {
+	items := m.histList.Items()
+	if m.historyLoading || m.favoritesOnly || m.connectedState != Connected || m.historyLoaded >= m.historyTotal ||
+		m.histList.FilterState() != list.Unfiltered || m.histList.Index() < len(items)-1 {
+		return nil
+	}
+	m.historyLoading = true
+	m.logf("Loading older history (%d of %d)...", m.historyLoaded, m.historyTotal)
+	// Ask for what's below our oldest entry rather than at an offset, which clips
+	// added or deleted since would shift
+	req := HistoryRequestData{Offset: m.historyLoaded, Limit: maxHistorySize}
+	for _, it := range items {
+		if h, ok := it.(historyItem); ok && h.Seq > 0 && (req.Before == 0 || h.Seq < req.Before) {
+			req.Before = h.Seq
+		}
+	}
+	return sendWebsocketMessageCmd(m.wsConn, BaseMessage{Type: "request_history", Data: req})
+}
+
+// truncateRunes returns the first n runes of s, and whether anything was cut.
+// n <= 0 means no limit.
+func truncateRunes(s string, n int) (string, bool) {
+	if n <= 0 || len(s) <= n {
+		return s, false // Fewer bytes than n means fewer runes too
+	}
+	i := 0
+	for pos := range s {
+		if i == n {
+			return s[:pos], true
+		}
+		i++
+	}
+	return s, false
+}
+
+// searchServerHistory asks the server to search its history once a filter is
+// applied, if some of that history hasn't been loaded into the list yet.
+func (m *Model) searchServerHistory() tea.Cmd {
+	if m.histList.FilterState() != list.FilterApplied {
+		m.lastSearch = ""
+		return nil
+	}
+	query := m.histList.FilterValue()
+	if query == m.lastSearch || m.connectedState != Connected || m.historyLoaded >= m.historyTotal {
+		return nil
+	}
+	m.lastSearch = query
+	return sendWebsocketMessageCmd(m.wsConn, BaseMessage{Type: "search_history", Data: SearchHistoryData{Query: query}})
+}
+
+// diffSummary describes in one line how incoming differs from local, e.g.
+// `12 -> 30 bytes, differs at byte 4: "foo…" vs "bar…"`.
+func diffSummary(local, incoming string) string {
+	i := 0
+	for i < len(local) && i < len(incoming) && local[i] == incoming[i] {
+		i++
+	}
+	return fmt.Sprintf("%d -> %d bytes, differs at byte %d: %q vs %q",
+		len(local), len(incoming), i, snippet(local[i:], 16), snippet(incoming[i:], 16))
+}
+
+// sanitizeForDisplay makes clipboard content safe to render: control characters
+// and escape sequences (e.g. copied terminal output) are shown as \x1b-style
+// escapes instead of reaching the terminal. Newlines and tabs are kept. Only for
+// rendering; the raw content is what gets written to the clipboard.
+func sanitizeForDisplay(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	for _, r := range s {
+		switch {
+		case r == '\n' || r == '\t':
+			b.WriteRune(r)
+		case unicode.IsControl(r):
+			fmt.Fprintf(&b, "\\x%02x", r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// snippet shortens s to at most n runes for one-line display.
+func snippet(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n]) + "…"
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func TestExpectAckForgetsOverdueClips(t *testing.T) {
+	m := &Model{pendingAcks: make(map[string]pendingAck)}
+	stale := clipDigest("never acked")
+	m.pendingAcks[stale] = pendingAck{Content: "never acked", Sent: time.Now().Add(-2 * ackTimeout)}
+
+	m.expectAck(newClipboardUpdateData("fresh"), "fresh")
+	if _, ok := m.pendingAcks[stale]; ok {
+		t.Error("clip past ackTimeout still pending")
+	}
+	if p, ok := m.pendingAcks[clipDigest("fresh")]; !ok || p.Content != "fresh" {
+		t.Errorf("sent clip not pending: %+v", m.pendingAcks)
+	}
+}
+
+func TestForgetSeqsAfterRestart(t *testing.T) {
+	m := &Model{historyCap: 10, historySize: 10}
+	m.histList = list.New(nil, list.NewDefaultDelegate(), 0, 0)
+	m.addHistoryEntry(historyItem{Content: "old", Seq: 500})
+	m.forgetSeqs()
+	if m.lastSeq != 0 {
+		t.Errorf("lastSeq = %d after a restart, want 0", m.lastSeq)
+	}
+	// The restarted server numbers from 1 again; its clips are still the newest
+	m.addHistoryEntry(historyItem{Content: "new", Seq: 1})
+	if top := m.histList.Items()[0].(historyItem); top.Content != "new" {
+		t.Errorf("top entry is %q, want the new clip above the pre-restart one", top.Content)
+	}
+}
+
+// newTestModel builds a Model as main does, from the environment, with home
+// pointed at a temp dir and adjust applied to the config first.
+func newTestModel(t *testing.T, adjust func(*Config)) Model {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir()) // loadConfig creates a device ID there
+	cfg := loadConfig()
+	adjust(&cfg)
+	return NewModel(cfg)
+}
+
+func TestDeleteKeepsOlderPages(t *testing.T) {
+	model := newTestModel(t, func(cfg *Config) { cfg.HistorySize = 2 })
+	var m tea.Model = model
+	page := func(data ClipboardHistoryData) {
+		m, _ = m.Update(ReceivedServerMsg{Msg: BaseMessage{Type: "clipboard_history", Data: data}})
+	}
+	page(ClipboardHistoryData{History: []HistoryEntryData{{Content: "d", Seq: 4}, {Content: "c", Seq: 3}}, Total: 4})
+	page(ClipboardHistoryData{History: []HistoryEntryData{{Content: "b", Seq: 2}, {Content: "a", Seq: 1}}, Offset: 2, Before: 3, Total: 4})
+	page(ClipboardHistoryData{History: []HistoryEntryData{}, Removed: "d", Total: 3})
+
+	model = m.(Model)
+	var got []string
+	for _, it := range model.histList.Items() {
+		got = append(got, it.(historyItem).Content)
+	}
+	if strings.Join(got, ",") != "c,b,a" {
+		t.Errorf("history after a delete = %v, want the older page kept: c,b,a", got)
+	}
+	if model.historyLoaded != 3 || model.historyTotal != 3 {
+		t.Errorf("loaded %d of %d, want 3 of 3", model.historyLoaded, model.historyTotal)
+	}
+}
+
+func TestGlobalKeysTypeIntoFilter(t *testing.T) {
+	model := newTestModel(t, func(*Config) {})
+	model.focus = HistoryPane
+	var m tea.Model = model
+	m, _ = m.Update(ReceivedServerMsg{Msg: BaseMessage{Type: "clipboard_history", Data: ClipboardHistoryData{
+		History: []HistoryEntryData{{Content: "a", Seq: 1}}, Total: 1,
+	}}})
+	m, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("/")})
+	if state := m.(Model).histList.FilterState(); state != list.Filtering {
+		t.Fatalf("filter state = %v after '/', want filtering", state)
+	}
+	// Pause, show/hide self, refresh devices and history, snippets
+	typed := "pmDHS"
+	for _, r := range typed {
+		m, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}})
+	}
+	model = m.(Model)
+	if got := model.histList.FilterValue(); got != typed {
+		t.Errorf("filter = %q, want %q", got, typed)
+	}
+	if model.showSnippets {
+		t.Error("snippets opened while typing a filter")
+	}
+}
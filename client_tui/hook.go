@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// clipHookTimeout stops a hung ON_CLIP_CHANGE command from piling up behind new clips.
+const clipHookTimeout = 30 * time.Second
+
+// ClipHookDoneMsg reports how an ON_CLIP_CHANGE run went.
+type ClipHookDoneMsg struct {
+	Output string // Combined stdout/stderr, trimmed and shortened for the log
+	Err    error
+}
+
+// runClipHookCmd runs the ON_CLIP_CHANGE executable with a received clip on stdin.
+// It is run directly, not through a shell, so the content can't be interpreted as
+// arguments or shell syntax; what the executable itself does with it is up to the user.
+func runClipHookCmd(path, content string) tea.Cmd {
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), clipHookTimeout)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, path)
+		cmd.Stdin = strings.NewReader(content)
+		var out bytes.Buffer
+		cmd.Stdout, cmd.Stderr = &out, &out
+		err := cmd.Run()
+		if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("timed out after %s", clipHookTimeout)
+		}
+		output, _ := truncateRunes(strings.TrimSpace(out.String()), 200)
+		return ClipHookDoneMsg{Output: output, Err: err}
+	}
+}
+
+// applyReceivedClip writes a received clip to the local clipboard and then, if
+// ON_CLIP_CHANGE is set, hands it to the hook.
+func (m *Model) applyReceivedClip(content string) tea.Cmd {
+	content = localLineEndings(content)
+	if m.clipHook == "" {
+		return writeToClipboardCmd(content)
+	}
+	return tea.Sequence(writeToClipboardCmd(content), runClipHookCmd(m.clipHook, content))
+}
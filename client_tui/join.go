@@ -0,0 +1,89 @@
+package main
+
+import (
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Entries marked in the History pane can be joined into one clip, e.g. to paste
+// several things copied one after another in one go. The marks live on Model as
+// contents in the order they were marked; historyItem.Mark only mirrors them for display.
+
+// joinMarkOf is the 1-based position of content among the marked entries, 0 if unmarked.
+func (m *Model) joinMarkOf(content string) int {
+	for i, c := range m.joinMarks {
+		if c == content {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// toggleJoinMark marks or unmarks the selected history entry.
+func (m *Model) toggleJoinMark() {
+	item, ok := m.histList.SelectedItem().(historyItem)
+	if !ok {
+		return
+	}
+	if n := m.joinMarkOf(item.Content); n > 0 {
+		m.joinMarks = append(m.joinMarks[:n-1], m.joinMarks[n:]...)
+	} else {
+		m.joinMarks = append(m.joinMarks, item.Content)
+	}
+	m.refreshJoinMarks()
+}
+
+// refreshJoinMarks updates the mark numbers shown in the list, dropping marks on
+// entries that are no longer listed.
+func (m *Model) refreshJoinMarks() {
+	defer m.fullHistory()() // Marks on entries the favorites view hides are kept
+	listed := make(map[string]bool)
+	for _, it := range m.histList.Items() {
+		if h, ok := it.(historyItem); ok {
+			listed[h.Content] = true
+		}
+	}
+	marks := m.joinMarks[:0]
+	for _, c := range m.joinMarks {
+		if listed[c] {
+			marks = append(marks, c)
+		}
+	}
+	m.joinMarks = marks
+	for i, it := range m.histList.Items() {
+		if h, ok := it.(historyItem); ok && h.Mark != m.joinMarkOf(h.Content) {
+			h.Mark = m.joinMarkOf(h.Content)
+			m.histList.SetItem(i, h)
+		}
+	}
+}
+
+// joinMarked writes the marked entries, joined by joinSeparator, to the local
+// clipboard, in the order they were marked or, with joinChronological, oldest
+// first. The poller then syncs it like any local copy. The marks are cleared.
+func (m *Model) joinMarked() tea.Cmd {
+	switch {
+	case len(m.joinMarks) < 2:
+		m.logf("Mark at least two history entries with %s to join them.", m.keys.ToggleMark.Help().Key)
+		return nil
+	case !m.clipboardAvailable:
+		m.logf("Local clipboard unavailable, cannot join entries.")
+		return nil
+	}
+	parts := m.joinMarks
+	if m.joinChronological {
+		parts = nil
+		items := m.historyItems()
+		for i := len(items) - 1; i >= 0; i-- { // The list is newest first
+			if h, ok := items[i].(historyItem); ok && h.Mark > 0 {
+				parts = append(parts, h.Content)
+			}
+		}
+	}
+	content := strings.Join(parts, m.joinSeparator)
+	m.logf("Joined %d entries (%d bytes) into the clipboard", len(parts), len(content))
+	m.joinMarks = nil
+	m.refreshJoinMarks()
+	return writeToClipboardCmd(content)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"runtime"
+	"strings"
+)
+
+// Line ending conversion for mixed Windows/Unix setups, both set in main.
+// convertIncoming (NORMALIZE_LINE_ENDINGS) rewrites received clips to this
+// platform's line endings before they reach the clipboard; sendLF
+// (SEND_LF_LINE_ENDINGS) turns CRLF into LF in text clips on the way out.
+var (
+	convertIncoming bool
+	sendLF          bool
+)
+
+// localEOL is the line ending pastes expect on this platform.
+func localEOL() string {
+	if runtime.GOOS == "windows" {
+		return "\r\n"
+	}
+	return "\n"
+}
+
+// normalizeLineEndings rewrites every CRLF or LF line ending in s to eol.
+// Lone CRs are left alone.
+func normalizeLineEndings(s, eol string) string {
+	if !strings.Contains(s, "\n") {
+		return s
+	}
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	if eol != "\n" {
+		s = strings.ReplaceAll(s, "\n", eol)
+	}
+	return s
+}
+
+// localLineEndings is how a received clip lands on the local clipboard.
+func localLineEndings(content string) string {
+	if !convertIncoming {
+		return content
+	}
+	return normalizeLineEndings(content, localEOL())
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// lockPollInterval is how often the screen lock state is checked with PAUSE_ON_LOCK.
+const lockPollInterval = 3 * time.Second
+
+// checkScreenLockCmd reads the lock state, after a delay unless it's the first check.
+func checkScreenLockCmd(delay time.Duration) tea.Cmd {
+	check := func() tea.Msg {
+		locked, err := screenLocked()
+		return ScreenLockMsg{Locked: locked, Err: err}
+	}
+	if delay == 0 {
+		return check
+	}
+	return tea.Tick(delay, func(time.Time) tea.Msg { return check() })
+}
+
+// handleScreenLock pauses sync while the screen is locked and resumes it on unlock.
+// It's a separate pause from syncEnabled, so unlocking never turns on sync the
+// user turned off. If the state can't be read, watching stops.
+func (m *Model) handleScreenLock(msg ScreenLockMsg) tea.Cmd {
+	if msg.Err != nil {
+		m.logf("Cannot detect screen lock, PAUSE_ON_LOCK disabled: %v", msg.Err)
+		m.pausedByLock = false
+		return nil
+	}
+	if msg.Locked != m.pausedByLock {
+		m.pausedByLock = msg.Locked
+		if msg.Locked {
+			m.logf("Screen locked: sync paused.")
+		} else {
+			m.logf("Screen unlocked: sync resumed.")
+		}
+	}
+	return checkScreenLockCmd(lockPollInterval)
+}
@@ -0,0 +1,34 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const lockDetectionSupported = true
+
+// screenLocked asks systemd-logind whether this session is locked. Desktops that
+// lock through logind (GNOME, KDE, most others with light-locker or xss-lock) set
+// LockedHint; a plain X screensaver that doesn't won't be noticed.
+func screenLocked() (bool, error) {
+	session := os.Getenv("XDG_SESSION_ID")
+	if session == "" {
+		session = "auto"
+	}
+	out, err := exec.Command("loginctl", "show-session", session, "-p", "LockedHint", "--value").Output()
+	if err != nil {
+		return false, fmt.Errorf("loginctl: %w", err)
+	}
+	switch v := strings.TrimSpace(string(out)); v {
+	case "yes":
+		return true, nil
+	case "no":
+		return false, nil
+	default:
+		return false, fmt.Errorf("unexpected LockedHint %q", v)
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// Lock state is only read from systemd-logind for now
+const lockDetectionSupported = false
+
+func screenLocked() (bool, error) {
+	return false, errors.New("screen lock detection is only available on Linux")
+}
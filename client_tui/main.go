@@ -1,30 +1,51 @@
 package main
 
 import (
+	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"runtime"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/joho/godotenv"
 )
 
-func setupLogging() (*os.File, error) {
+// configDir returns the client's config dir, creating it if needed.
+func configDir() (string, error) {
 	home, err := os.UserHomeDir()
 	if err != nil {
-		return nil, fmt.Errorf("could not get home dir: %w", err)
+		return "", fmt.Errorf("could not get home dir: %w", err)
+	}
+	dir := filepath.Join(home, ".config", "sync-clipboard-tui")
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return "", fmt.Errorf("could not create config dir: %w", err)
+	}
+	return dir, nil
+}
+
+// setupLogging sends the log package to debug.log, encrypted with ENCRYPT_LOCAL_FILES.
+// The env must already be loaded.
+func setupLogging() (*os.File, error) {
+	dir, err := configDir()
+	if err != nil {
+		return nil, err
 	}
-	configDir := filepath.Join(home, ".config", "sync-clipboard-tui")
-	if err := os.MkdirAll(configDir, 0750); err != nil {
-		return nil, fmt.Errorf("could not create config dir: %w", err)
+	if err := setupLocalEncryption(dir); err != nil {
+		return nil, err
 	}
 
-	logFilePath := filepath.Join(configDir, "debug.log")
+	logFilePath := filepath.Join(dir, "debug.log")
 	f, err := tea.LogToFile(logFilePath, "debug")
 	if err != nil {
 		return nil, fmt.Errorf("failed to open log file: %w", err)
 	}
+	if sealWrites {
+		log.SetOutput(sealedLog{w: f})
+	}
 	log.Printf("--- Session Started ---") 
 	return f, nil
 }
@@ -40,6 +61,35 @@ func loadEnv() {
 }
 
 func main() {
+	discover := flag.Bool("discover", false, "find the server on the LAN via mDNS when SERVER_WS_URL is unset")
+	headlessFlag := flag.Bool("headless", false, "run without the TUI, printing log lines to stdout")
+	jsonFlag := flag.Bool("json", false, "headless, printing JSON events (connection, clip, devices) one per line instead")
+	jsonContent := flag.Bool("json-content", false, "include clip contents in --json clip events")
+	decrypt := flag.Bool("decrypt-log", false, "print debug.log, decrypting lines written with ENCRYPT_LOCAL_FILES, and exit")
+	flag.Parse()
+	headless = *headlessFlag || *jsonFlag
+	if *jsonFlag {
+		jsonEvents = json.NewEncoder(os.Stdout)
+		eventContent = *jsonContent
+	}
+
+	loadEnv() // Before logging, which may be encrypted
+
+	if *decrypt {
+		dir, err := configDir()
+		if err == nil {
+			err = setupLocalEncryption(dir)
+		}
+		if err == nil {
+			err = decryptLog(filepath.Join(dir, "debug.log"), os.Stdout)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	logFile, err := setupLogging()
 	if err != nil {
 		fmt.Println("Error setting up logging:", err)
@@ -47,28 +97,75 @@ func main() {
 	}
 	defer logFile.Close()
 
-	loadEnv() 
-
-	serverURL := os.Getenv("SERVER_WS_URL")
-	apiKey := os.Getenv("CLIPBOARD_API_KEY")
-	if serverURL == "" || apiKey == "" {
+	cfg := loadConfig()
+	if cfg.ServerURL == "" && *discover {
+		fmt.Println("Looking for a clipd server on the LAN...")
+		if u, err := discoverServer(3 * time.Second); err != nil {
+			log.Printf("Discovery failed: %v", err)
+			fmt.Println("Discovery failed:", err)
+		} else {
+			log.Printf("Discovered server at %s", u)
+			cfg.ServerURL = u
+		}
+	}
+	// A client certificate replaces the API key on servers using mutual TLS
+	if cfg.ServerURL == "" || (cfg.APIKey == "" && cfg.TLSCertFile == "") {
 		log.Fatal("Error: SERVER_WS_URL or CLIPBOARD_API_KEY not set in environment or .env file")
 	}
-
-	hostname, err := os.Hostname()
+	tlsCfg, err := cfg.tlsConfig()
 	if err != nil {
-		hostname = "UnknownHost"
-		log.Println("Warning: Could not get hostname:", err)
+		log.Fatalf("Error: TLS setup failed: %v", err)
+	}
+	dialer.TLSClientConfig = tlsCfg
+	dialer.EnableCompression = cfg.Compression
+	dialer.HandshakeTimeout = time.Duration(cfg.DialTimeout) * time.Second
+	compressionLevel = cfg.CompressionLevel
+	traceWS = cfg.TraceWS
+	previewLength = cfg.PreviewLength
+	useSessionToken = cfg.SessionToken
+	deviceGroups = cfg.Groups
+	if cfg.SyncPrimary && !primarySupported {
+		log.Printf("Warning: SYNC_PRIMARY ignored, there is no primary selection on %s", runtime.GOOS)
+	}
+	syncPrimary = cfg.SyncPrimary && primarySupported
+	convertIncoming = cfg.NormalizeEOL
+	sendLF = cfg.SendLF
+	if cfg.PauseOnLock && !lockDetectionSupported {
+		log.Printf("Warning: PAUSE_ON_LOCK ignored, screen lock can't be detected on %s", runtime.GOOS)
+		cfg.PauseOnLock = false
+	}
+	if traceWS {
+		log.Printf("WARNING: TRACE_WS is on; full frames, including clipboard contents, are logged to this file")
+		fmt.Fprintln(os.Stderr, "Warning: TRACE_WS is on; clipboard contents will be written to the debug log.")
 	}
 
-	initialModel := NewModel(serverURL, apiKey, hostname)
+	if cfg.OnClipChange != "" {
+		log.Printf("ON_CLIP_CHANGE is set; %s will be run with every received clip", cfg.OnClipChange)
+		fmt.Fprintf(os.Stderr, "Note: ON_CLIP_CHANGE runs %s with clipboard contents from your other devices.\n", cfg.OnClipChange)
+	}
 
-	p := tea.NewProgram(initialModel, tea.WithAltScreen(), tea.WithMouseCellMotion()) // Enable mouse for viewport scrolling
+	if headless && cfg.ConfirmOverwrite {
+		log.Printf("CONFIRM_OVERWRITE ignored in headless mode; nothing could confirm")
+		cfg.ConfirmOverwrite = false
+	}
+
+	initialModel := NewModel(cfg)
+
+	// Pass a pointer so programRef set below is visible to the running model
+	opts := []tea.ProgramOption{tea.WithAltScreen(), tea.WithMouseAllMotion()} // Enable mouse for viewport scrolling, and motion for hovering the status bar
+	if headless {
+		opts = []tea.ProgramOption{tea.WithoutRenderer(), tea.WithInput(nil)} // Stopped with ctrl+c / SIGTERM
+	}
+	p := tea.NewProgram(&initialModel, opts...)
 	initialModel.programRef = p 
 
-	if _, err := p.Run(); err != nil {
+	finalModel, err := p.Run()
+	if err != nil {
 		log.Fatalf("Error running Bubbletea program: %v", err)
 		fmt.Fprintf(os.Stderr, "Error running program: %v\n", err)
 		os.Exit(1)
 	}
+	if m, ok := finalModel.(Model); ok && m.quitting && jsonEvents == nil {
+		fmt.Println(m.sessionSummary())
+	}
 }
@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"math/rand"
+	"os"
 	"strings"
 	"time"
 
@@ -12,6 +14,7 @@ import (
 	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/list"
 	"github.com/charmbracelet/bubbles/spinner"
+	"github.com/charmbracelet/bubbles/textinput"
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
@@ -20,7 +23,7 @@ import (
 
 type FocusablePane int
 
-const maxHistorySize=20
+const maxHistorySize=20 // Page size for loading server history, and the default local history size
 
 const (
 	HistoryPane FocusablePane = iota
@@ -29,11 +32,38 @@ const (
 	NumPanes // Keep last
 )
 
+// paneNames are the names PANE_ORDER lists the panes by.
+var paneNames = map[string]FocusablePane{"history": HistoryPane, "devices": DevicesPane, "log": LogPane}
+
+// parsePaneOrder reads a PANE_ORDER list, which must name every pane exactly once.
+func parsePaneOrder(names []string) ([]FocusablePane, error) {
+	if len(names) != int(NumPanes) {
+		return nil, fmt.Errorf("want %d panes, got %d", NumPanes, len(names))
+	}
+	order := make([]FocusablePane, 0, NumPanes)
+	seen := make(map[FocusablePane]bool)
+	for _, name := range names {
+		p, ok := paneNames[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown pane %q", name)
+		}
+		if seen[p] {
+			return nil, fmt.Errorf("%q listed twice", name)
+		}
+		seen[p] = true
+		order = append(order, p)
+	}
+	return order, nil
+}
+
 type Model struct {
 	// Config
 	serverURL string
 	apiKey    string
+	credential string // Device credential from the server, for paired devices without an API key; see loadDeviceCredential
 	hostname  string
+	deviceID  string
+	channels  []string // CLIP_CHANNELS, cycled with NextChannel
 
 	// UI Components
 	spinner    spinner.Model
@@ -46,26 +76,162 @@ type Model struct {
 	// State
 	connectedState ConnectionState
 	syncEnabled    bool
+	syncMode       SyncMode // Direction limit; only applies while syncEnabled
+	channel        string // Clipboard channel we send to and receive from
+	pauseStep      int       // Index+1 into syncPausePresets while a timed pause is active, else 0
+	pausedUntil    time.Time // When a timed pause ends
+	pauseGen       int       // Bumped whenever a pause starts or is cancelled, to drop stale ticks
 	lastError      error
 	logMessages    []string
+	connEvents     []string // Connection state changes, for exportLog
 	wsConn         *websocket.Conn
 	wsCtxCancel    context.CancelFunc // Function to cancel WS goroutines context
 	lastSentClip   string
+	lastLocalClip  string // Last content seen by the local clipboard poller
+	clipSettle     time.Duration // CLIP_SETTLE_MS; 0 sends changes as soon as they're polled
+	settleSince    time.Time     // When the change waiting out clipSettle was seen; later changes restart it
+	clipboardAvailable bool // False when the startup probe failed; then we only receive and display
+	clipboardErr       error
+	clipWriteFailures  int // Consecutive failed clipboard writes, after retries
+	lastSeq        int64  // Highest server sequence number seen, shown for debugging
+	epoch          string // Server run lastSeq belongs to, from welcome
+	pendingAcks    map[string]pendingAck // Keyed by clipDigest of sent clips, until clipboard_ack numbers them or ackTimeout passes
+	lastSentAt     time.Time // When lastSentClip went to the main server, for conflict detection
+	lastSentSeq    int64     // The seq the server acked lastSentClip with; 0 until then
+
+	// Server history paging: older pages load as the history list is scrolled to the end
+	historySize    int  // Local history length, independent of the server's
+	historyCap     int  // histList trim size; historySize or more as older pages are loaded
+	historyLoaded  int  // Server entries fetched so far, i.e. the next page's offset
+	historyTotal   int  // Server-side history size
+	historyLoading bool // A page request is in flight
+	lastSearch     string // Last filter sent as search_history, so it's sent once
+	compressionActive bool // permessage-deflate negotiated on the current connection
+	rttSamples     []time.Duration // Recent ping round trips, newest last
+	statusHover    bool            // Mouse is over the status bar, which then shows the RTT in ms
+
+	// Reconnect backoff, shown in the status bar while disconnected
+	reconnectAttempt int       // Attempts since the last successful connect
+	nextRetry        time.Time // Zero unless a retry is scheduled
+	reconnectGen     int       // Bumped to drop stale ReconnectTickMsgs
+	maxReconnects    int       // MAX_RECONNECT_ATTEMPTS; 0 is unlimited
+	gaveUp           bool      // maxReconnects ran out; only RetryNow dials again
+	restartHold      time.Duration // First retry delay after server_restarting, in place of the backoff
 	lastRcvdClip   string
+	dimAfter         time.Duration // DIM_AFTER; 0 never dims, see dim.go
+	lastInput        time.Time     // Last key press
+	dimmed           bool
+	pauseOnLock      bool   // PAUSE_ON_LOCK: watch the screen lock, see lock.go
+	pausedByLock     bool   // Screen is locked; pauses sync on top of syncEnabled
+	appendMode       bool   // Received clips are added to the end of the local clipboard instead of replacing it
+	lastAppendedClip string // What append mode last wrote, so it isn't sent back out as a local change
+	extraServers   []*extraServer // EXTRA_SERVERS connections, see servers.go
+	serverLabel    string         // The main server's label, shown once there are extra servers
+	lastPrimary     string // Primary selection as last polled, when syncPrimary is on
+	lastRcvdPrimary string // Last selection received, so it isn't echoed back
 	focus          FocusablePane
+	paneOrder      []FocusablePane // PANE_ORDER: left to right, and the order tab cycles through
 	programRef     *tea.Program // Reference to program needed for sending messages from cmds
 
 	// File Transfer State
-	incomingFileOffer *FileOfferData
-	offeringClientID  string // ID of client who sent the offer
+	incomingOffers    []pendingOffer // Offers awaiting accept/reject, oldest first
+	dnd               bool           // Do not disturb: offers queue up without a prompt until it's turned off
+	transferPolicy    map[string]bool // Device ID or hostname -> always allow (true) or block (false) its offers
 	devicesMap        map[string]string // Map ID to hostname for lookup
+	devices           []ClientInfo      // Last device list from the server, including self
+	selfID            string            // Our server-assigned ID, from the welcome message
+	showSelf          bool              // Debug: list this device in the Devices pane too
+	clipFileThreshold int               // Clips above this size go out as a file transfer; see sendsAsFile
+	downloadDir       string
+	outgoingOffers    map[string]*outgoingOffer    // Transfer ID -> offer awaiting acks
+	activeOffers      map[string]string            // Peer ID -> transfer ID offered or being sent to it
+	offerQueue        map[string][]*outgoingOffer  // Peer ID -> files waiting behind its active offer
+	sendSessions      map[string]*transferSession  // transferKey -> upload in progress
+	recvTransfers     map[string]*incomingTransfer // Transfer ID -> download in progress
+	pathInput         textinput.Model              // File/directory prompt for outgoing transfers
+	promptSavePath    bool                         // PROMPT_SAVE_PATH: ask where each accepted file goes
+	savingOffer       *pendingOffer                // Offer whose save path is being asked for
+	saveInput         textinput.Model
+	saveErr           error // Why the last path entered in saveInput was refused
+
+	// Command palette: every binding in FullHelp, runnable by name; see palette.go
+	showPalette bool
+	paletteList list.Model
+
+	// Transform menu: rewrites a clip with one of transforms; see transform.go
+	showTransforms bool
+	transformList  list.Model
+	transformSrc   string // The clip being transformed
+	unsyncedClip   string // A transform result copied with CopyUnsynced, which the poller mustn't send
+	clearedExpired bool   // The clipboard was emptied because its clip expired; the poller mustn't send that
+	clipTTL        int    // CLIP_TTL, for SendExpiring
+
+	// Snippets modal: a named clip library kept in snippetsFile
+	showSnippets  bool
+	snippetList   list.Model
+	snippetsFile  string
+	snippetsErr   error // The file couldn't be read, so it's never overwritten
+	snippetInput  textinput.Model // Name prompt for adding/renaming
+	namingSnippet bool
+	renameIndex   int // Snippet being renamed, by its index among all items; -1 when adding
+	promptingPath     bool
+	xferTargetID      string // Device the prompted path will be offered to, "" for every device
+	xferGroup         string // Group it'll be offered to instead, when xferTargetID is ""
+	collapsedGroups   map[string]bool // Device groups folded in the Devices pane
+	sendLimiter       *tokenBucket                 // nil when transfers are unthrottled
+
+	// Latest aggregate stats broadcast by the server (nil until the first one)
+	serverStats *StatsData
+	// What the server reported in server_info; nil until it answers, and for
+	// servers too old to, in which case everything is assumed supported
+	serverInfo *ServerInfoData
+	awaitingServerInfo bool // server_info was sent and not yet answered; an error in reply means a server that predates it
+	// The server's MOTD as last received, and the banner showing it; the banner
+	// is emptied when dismissed, and only comes back if the MOTD changes
+	motdSeen string
+	motd     string
+
+	// Content Modal
+	showContent   bool
+	contentView   viewport.Model
+	contentHeader string
+	contentClip   string // What the content modal shows, for CopyShown
+	diffMark      *historyItem // First entry picked with MarkDiff, until the second is
+
+	// Entries marked with ToggleMark, in marking order, for JoinMarked; see join.go
+	joinMarks         []string
+	joinSeparator     string
+	joinChronological bool
+
+	// Favorite history entries by content, and the favorites-only view; see favorites.go
+	favorites     map[string]bool
+	favoritesOnly bool
+	histAll       []list.Item // The full history while favoritesOnly; histList holds the favorites
+	histSwapped   bool        // histAll is in histList for a change, see fullHistory
+
+	// Confirm-overwrite mode: received clips wait here when they'd clobber different local content
+	confirmOverwrite bool
+	pendingOverwrite *string
+	overwriteSummary string
+
+	seedOnConnect bool // Apply the server's current clip on connect instead of only listing it
+	clipHook      string // ON_CLIP_CHANGE executable, "" when off
+	showClipOrigin bool // Prefix received history entries with the sending device
+
+	// Session stats, summarised on quit
+	sessionStart  time.Time
+	clipsSent     int
+	clipsReceived int
+	filesSent     int
+	filesReceived int
+	quitting      bool // Left via the Quit key, as opposed to an error
 
 	// Dimensions
 	width, height int
 	ready         bool // Flag to indicate if UI is ready (size known)
 }
 
-func NewModel(serverURL, apiKey, hostname string) Model {
+func NewModel(cfg Config) Model {
 	s := spinner.New()
 	s.Spinner = spinner.Dot
 	s.Style = lipgloss.NewStyle().Foreground(special)
@@ -83,34 +249,136 @@ func NewModel(serverURL, apiKey, hostname string) Model {
 	logView := viewport.New(0, 0) // Size set later
 	logView.SetContent("Initializing logs...")
 
+	contentView := viewport.New(0, 0) // Size set later, like logView
+
+	snippets, snippetsErr := loadSnippets(cfg.SnippetsFile)
+	snippetList := list.New(snippets, list.NewDefaultDelegate(), 0, 0)
+	snippetList.Title = "Snippets"
+	snippetList.Styles.Title = listTitleStyle
+	snippetList.SetShowHelp(false) // Footer lists the snippet keys
+
+	paletteList := list.New(nil, list.NewDefaultDelegate(), 0, 0)
+	paletteList.Title = "Commands"
+	paletteList.Styles.Title = listTitleStyle
+	paletteList.SetShowHelp(false) // Footer lists the palette keys
+
+	transformList := list.New(transformItems(), list.NewDefaultDelegate(), 0, 0)
+	transformList.Styles.Title = listTitleStyle
+	transformList.SetShowHelp(false) // Footer lists the menu keys
+	transformList.DisableQuitKeybindings()
+
+	snippetInput := textinput.New()
+	snippetInput.Placeholder = "e.g. email signature"
+	snippetInput.Prompt = "Name: "
+
+	saveInput := textinput.New()
+	saveInput.Prompt = "Save to: "
+
+	pathInput := textinput.New()
+	pathInput.Placeholder = "/path/to/file or directory"
+	pathInput.Prompt = "Send: "
+
 	keys := defaultKeyMap()
 	hlp := help.New()
 	hlp.ShowAll = false // Show only short help
 
 	m := Model{
-		serverURL:      serverURL,
-		apiKey:         apiKey,
-		hostname:       hostname,
+		serverURL:      cfg.ServerURL,
+		apiKey:         cfg.APIKey,
+		credential:     loadDeviceCredential(),
+		hostname:       cfg.Hostname,
+		deviceID:       cfg.DeviceID,
+		channels:       cfg.Channels,
+		channel:        cfg.Channels[0],
 		spinner:        s,
 		deviceList:     deviceList,
 		histList:       histList,
 		logView:        logView,
+		contentView:    contentView,
 		help:           hlp,
 		keys:           keys,
 		connectedState: Disconnected, // Start disconnected
 		syncEnabled:    true,
-		focus:          HistoryPane,
+		syncMode:       cfg.SyncMode,
+		maxReconnects:  cfg.MaxReconnects,
+		extraServers:   newExtraServers(cfg.ExtraServers),
+		serverLabel:    cfg.ServerLabel,
+		focus:          cfg.PaneOrder[0],
+		paneOrder:      cfg.PaneOrder,
 		logMessages:    []string{"Initializing..."},
 		devicesMap:     make(map[string]string),
+		sessionStart:   time.Now(),
+
+		confirmOverwrite: cfg.ConfirmOverwrite,
+		seedOnConnect:    cfg.SeedOnConnect,
+		clipHook:         cfg.OnClipChange,
+		showClipOrigin:   cfg.ShowClipOrigin,
+
+		joinSeparator:     cfg.JoinSeparator,
+		favorites:         make(map[string]bool),
+		pauseOnLock:       cfg.PauseOnLock,
+		dimAfter:          time.Duration(cfg.DimAfter) * time.Second,
+		lastInput:         time.Now(),
+		joinChronological: cfg.JoinChronological,
+
+		clipFileThreshold: cfg.ClipFileThreshold,
+		clipTTL:           cfg.ClipTTL,
+		clipSettle:        time.Duration(cfg.ClipSettleMS) * time.Millisecond,
+		downloadDir:       cfg.DownloadDir,
+		pathInput:         pathInput,
+		saveInput:         saveInput,
+		promptSavePath:    cfg.PromptSavePath,
+		snippetList:       snippetList,
+		paletteList:       paletteList,
+		transformList:     transformList,
+		snippetsFile:      cfg.SnippetsFile,
+		snippetsErr:       snippetsErr,
+		snippetInput:      snippetInput,
+		renameIndex:       -1,
+		historySize:       cfg.HistorySize,
+		historyCap:        cfg.HistorySize,
+		outgoingOffers:    make(map[string]*outgoingOffer),
+		activeOffers:      make(map[string]string),
+		pendingAcks:       make(map[string]pendingAck),
+		transferPolicy:    newTransferPolicy(cfg.TransferAllow, cfg.TransferBlock),
+		offerQueue:        make(map[string][]*outgoingOffer),
+		collapsedGroups:   make(map[string]bool),
+		sendSessions:      make(map[string]*transferSession),
+		recvTransfers:     make(map[string]*incomingTransfer),
+		sendLimiter:       newTokenBucket(cfg.TransferRateKBps * 1024),
+	}
+	if snippetsErr != nil {
+		m.logf("Error loading snippets: %v", snippetsErr)
+	}
+	m.clipboardErr = probeClipboard()
+	m.clipboardAvailable = m.clipboardErr == nil
+	if !m.clipboardAvailable {
+		m.logf("Local clipboard unavailable, receive-only: %v", m.clipboardErr)
 	}
 	return m
 }
 
 func (m Model) Init() tea.Cmd {
-	return tea.Batch(
+	cmds := []tea.Cmd{
 		m.spinner.Tick,                 // Start spinner animation
-		connectCmd(m.serverURL, m.apiKey, m.hostname), // Initiate connection attempt
-	)
+		connectCmd(m.serverURL, m.apiKey, m.credential, m.hostname, m.deviceID, m.channel, m.epoch, m.lastSeq), // Initiate connection attempt
+	}
+	if m.clipboardAvailable {
+		cmds = append(cmds, checkLocalClipboardCmd(m.lastLocalClip)) // Poll the local clipboard even while offline
+	}
+	if syncPrimary {
+		cmds = append(cmds, checkPrimaryCmd(m.lastPrimary))
+	}
+	if m.pauseOnLock {
+		cmds = append(cmds, checkScreenLockCmd(0))
+	}
+	if m.dimAfter > 0 && !headless {
+		cmds = append(cmds, dimCheck(m.dimAfter))
+	}
+	if len(m.extraServers) > 0 {
+		cmds = append(cmds, m.connectExtraServers())
+	}
+	return tea.Batch(cmds...)
 }
 
 
@@ -132,6 +400,12 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.height = msg.Height
 		h, v := docStyle.GetFrameSize()
 		listHeight := m.height - v - 5
+		if !m.clipboardAvailable {
+			listHeight-- // Room for the clipboard banner
+		}
+		if m.motd != "" {
+			listHeight-- // Room for the MOTD banner
+		}
 		paneWidth := (m.width - h - 2) /int(NumPanes) // -2 for borders between panes
 
 		m.histList.SetSize(paneWidth, listHeight)
@@ -139,6 +413,13 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.logView.Width = paneWidth
 		m.logView.Height = listHeight
 
+		// Content modal takes the whole screen minus its border and header
+		m.contentView.Width = m.width - h - 4
+		m.contentView.Height = m.height - v - 3
+		m.snippetList.SetSize(m.width-h-4, m.height-v-5) // Border plus footer
+		m.paletteList.SetSize(m.width-h-4, m.height-v-5)
+		m.transformList.SetSize(m.width-h-4, m.height-v-5)
+
 		// Set help width
 		m.help.Width = m.width - h
 
@@ -146,72 +427,442 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.logView.GotoBottom() // Scroll log to bottom on resize
 
 	case tea.KeyMsg:
+		// Panic wipe works from anywhere, including modals and prompts
+		if key.Matches(msg, m.keys.PanicWipe) {
+			return m, m.panicWipe()
+		}
+		if woke, cmd := m.noteInput(); woke {
+			return m, cmd
+		}
+
+		// The content modal swallows all keys except close/quit while open
+		if m.showContent {
+			switch {
+			case key.Matches(msg, m.keys.CloseModal):
+				m.showContent = false
+			case key.Matches(msg, m.keys.CopyShown):
+				if !m.clipboardAvailable {
+					m.logf("Local clipboard unavailable, cannot copy.")
+					break
+				}
+				m.logf("Copying shown clip (%d bytes) to clipboard.", len(m.contentClip))
+				cmds = append(cmds, writeToClipboardCmd(m.contentClip))
+			case key.Matches(msg, m.keys.Quit):
+				m.showContent = false
+				return m.Update(msg)
+			default:
+				m.contentView, cmd = m.contentView.Update(msg)
+				cmds = append(cmds, cmd)
+			}
+			return m, tea.Batch(cmds...)
+		}
+
+		// The snippets modal handles its own keys; quit still works unless typing a name
+		if m.showSnippets {
+			if key.Matches(msg, m.keys.Quit) && !m.namingSnippet && m.snippetList.FilterState() != list.Filtering {
+				m.showSnippets = false
+				return m.Update(msg)
+			}
+			return m, m.updateSnippets(msg)
+		}
+
+		// The transform menu, where quit closes the menu first
+		if m.showTransforms {
+			if key.Matches(msg, m.keys.Quit) && m.transformList.FilterState() != list.Filtering {
+				m.showTransforms = false
+				return m.Update(msg)
+			}
+			return m, m.updateTransforms(msg)
+		}
+
+		// And the command palette, which replays the picked binding as a key press.
+		// It opens filtering, so only a typed q goes to the filter; ctrl+c still quits.
+		if m.showPalette {
+			if key.Matches(msg, m.keys.Quit) && (msg.Type != tea.KeyRunes || m.paletteList.FilterState() != list.Filtering) {
+				m.showPalette = false
+				return m.Update(msg)
+			}
+			run, cmd := m.updatePalette(msg)
+			if run != nil {
+				return m.Update(*run)
+			}
+			return m, cmd
+		}
+
+		// The save prompt for an accepted file too
+		if m.savingOffer != nil {
+			return m, m.updateSavePrompt(msg)
+		}
+
+		// Likewise the transfer path prompt, so typed paths don't trigger shortcuts
+		if m.promptingPath {
+			switch {
+			case key.Matches(msg, m.keys.CloseModal):
+				m.promptingPath = false
+				m.logf("Transfer cancelled.")
+			case msg.Type == tea.KeyEnter:
+				path := strings.TrimSpace(m.pathInput.Value())
+				xfer, err := m.offerPath(path, m.xferTargetID, m.xferGroup)
+				if err != nil {
+					m.logf("Cannot send '%s': %v", path, err)
+					return m, nil // Keep the prompt open to fix the path
+				}
+				m.promptingPath = false
+				cmds = append(cmds, xfer)
+			default:
+				m.pathInput, cmd = m.pathInput.Update(msg)
+				cmds = append(cmds, cmd)
+			}
+			return m, tea.Batch(cmds...)
+		}
+
 		// Handle keys even if lists have focus for global actions
 		switch {
 		case key.Matches(msg, m.keys.Quit):
 			m.logf("Quitting...")
+			m.quitting = true
+			m.cancelSyncPause() // Drop any pending resume tick
 			if m.wsCtxCancel != nil {
 				m.wsCtxCancel() // Signal background tasks to stop
 			}
+			m.cleanupTransfers()
 			if m.wsConn != nil {
 				// Attempt clean close
 				m.wsConn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
 				m.wsConn.Close()
 			}
+			for _, s := range m.extraServers {
+				if s.conn != nil {
+					s.conn.Close()
+				}
+			}
 			return m, tea.Quit
 
 		case key.Matches(msg, m.keys.ToggleSync):
+			m.cancelSyncPause() // A manual toggle overrides any timed pause
 			m.syncEnabled = !m.syncEnabled
 			m.logf("Clipboard sync %s", map[bool]string{true: "enabled", false: "disabled"}[m.syncEnabled])
 			// Maybe send status to server? Optional.
 			return m, nil
 
+		case key.Matches(msg, m.keys.CycleSyncMode) && !m.filtering():
+			m.syncMode = (m.syncMode + 1) % (SyncOff + 1)
+			m.logf("Sync direction: %s", m.syncMode)
+			return m, nil
+
+		case key.Matches(msg, m.keys.RetryNow):
+			retryExtra := m.retryExtraServers()
+			if m.connectedState != Disconnected {
+				return m, retryExtra
+			}
+			if m.gaveUp {
+				m.gaveUp = false
+				m.reconnectAttempt = 0 // A fresh round of MAX_RECONNECT_ATTEMPTS
+			}
+			m.logf("Reconnecting now...")
+			return m, tea.Batch(m.reconnect(), retryExtra)
+
+		case key.Matches(msg, m.keys.NextChannel) && !m.filtering():
+			if len(m.channels) < 2 {
+				m.logf("Only one channel configured; set CLIP_CHANNELS to add more.")
+				return m, nil
+			}
+			return m, m.nextChannel()
+
+		case key.Matches(msg, m.keys.CopyReceived) && !m.filtering():
+			// Works with sync off, which is when received clips aren't applied automatically
+			switch {
+			case m.lastRcvdClip == "":
+				m.logf("Nothing received yet to copy.")
+				return m, nil
+			case !m.clipboardAvailable:
+				m.logf("Local clipboard unavailable, cannot copy.")
+				return m, nil
+			}
+			m.logf("Copying last received clip (%d bytes) to clipboard.", len(m.lastRcvdClip))
+			return m, writeToClipboardCmd(localLineEndings(m.lastRcvdClip))
+
+		case key.Matches(msg, m.keys.Snippets) && !m.filtering():
+			m.showSnippets = true
+			return m, nil
+
+		case key.Matches(msg, m.keys.Palette) && !m.filtering():
+			return m, m.openPalette()
+
+		case key.Matches(msg, m.keys.Transform) && !m.filtering():
+			m.openTransforms()
+			return m, nil
+
+		case key.Matches(msg, m.keys.ResendClip) && !m.filtering():
+			switch {
+			case m.connectedState != Connected:
+				m.logf("Not connected; nothing to resend to.")
+			case !m.sendsClips():
+				m.logf("Sync is off or pull-only; nothing is sent.")
+			case !m.clipboardAvailable:
+				m.logf("Local clipboard unavailable, cannot resend.")
+			default:
+				return m, resendClipboardCmd(false, 0)
+			}
+			return m, nil
+
+		case key.Matches(msg, m.keys.SendExpiring) && !m.filtering():
+			switch {
+			case m.connectedState != Connected:
+				m.logf("Not connected; can't send an expiring clip.")
+			case !m.sendsClips():
+				m.logf("Sync is off or pull-only; nothing is sent.")
+			case !m.clipboardAvailable:
+				m.logf("Local clipboard unavailable, cannot send.")
+			case m.clipTTL <= 0:
+				m.logf("CLIP_TTL is not set; can't send an expiring clip.")
+			default:
+				return m, resendClipboardCmd(false, m.clipTTL)
+			}
+			return m, nil
+
+		case key.Matches(msg, m.keys.SyncNow) && !m.filtering():
+			// Deliberately ignores syncEnabled and syncMode, and leaves them as they are
+			switch {
+			case m.connectedState != Connected:
+				m.logf("Not connected; nothing to sync to.")
+			case !m.clipboardAvailable:
+				m.logf("Local clipboard unavailable, cannot sync.")
+			default:
+				return m, resendClipboardCmd(true, 0)
+			}
+			return m, nil
+
+		case key.Matches(msg, m.keys.ServerClip) && !m.filtering():
+			if m.connectedState != Connected {
+				m.logf("Not connected; can't ask the server for its clip.")
+				return m, nil
+			}
+			return m, sendWebsocketMessageCmd(m.wsConn, BaseMessage{Type: "request_clip"})
+
+		case key.Matches(msg, m.keys.OpenURL) && !m.filtering():
+			if link, ok := m.openableURL(); ok {
+				return m, openURLCmd(link)
+			}
+			return m, nil
+
+		case key.Matches(msg, m.keys.ExportLog) && !m.filtering():
+			return m, exportLogCmd(m.bugReport())
+
+		case key.Matches(msg, m.keys.PairDevice) && !m.filtering():
+			if m.apiKey == "" {
+				m.logf("Pairing needs CLIPBOARD_API_KEY to request a session token.")
+				return m, nil
+			}
+			m.logf("Requesting a pairing token...")
+			return m, pairingCmd(m.serverURL, m.apiKey)
+
+		case key.Matches(msg, m.keys.PauseSync) && !m.filtering():
+			return m, m.cycleSyncPause()
+
+		case key.Matches(msg, m.keys.ToggleSelf) && !m.filtering():
+			m.showSelf = !m.showSelf
+			m.refreshDeviceList()
+			m.logf("Own device %s in device list", map[bool]string{true: "shown", false: "hidden"}[m.showSelf])
+			return m, nil
+
+		case key.Matches(msg, m.keys.RefreshDevices) && !m.filtering():
+			m.logf("Requesting device list...")
+			return m, sendWebsocketMessageCmd(m.wsConn, BaseMessage{Type: "request_devices"})
+
+		case key.Matches(msg, m.keys.RefreshHistory) && !m.filtering():
+			m.logf("Requesting clipboard history...")
+			return m, sendWebsocketMessageCmd(m.wsConn, BaseMessage{Type: "request_history"})
+
 		case key.Matches(msg, m.keys.FocusNext):
-			m.focus = (m.focus + 1) % NumPanes
-			m.updateFocus()
+			m.cycleFocus(1)
 			return m, nil
 		case key.Matches(msg, m.keys.FocusPrev):
-			m.focus = (m.focus - 1 + NumPanes) % NumPanes
+			m.cycleFocus(-1)
+			return m, nil
+		case key.Matches(msg, m.keys.FocusHistory) && !m.filtering():
+			m.focus = HistoryPane
+			m.updateFocus()
+			return m, nil
+		case key.Matches(msg, m.keys.FocusDevices) && !m.filtering():
+			m.focus = DevicesPane
+			m.updateFocus()
+			return m, nil
+		case key.Matches(msg, m.keys.FocusLog) && !m.filtering():
+			m.focus = LogPane
 			m.updateFocus()
 			return m, nil
 
 		case key.Matches(msg, m.keys.AcceptFile):
-			if m.incomingFileOffer != nil {
-				m.logf("Accepting file offer for '%s' from %s", m.incomingFileOffer.Filename, m.devicesMap[m.offeringClientID])
-				ack := BaseMessage{
-					Type: "file_ack",
-					Data: FileAckData{Filename: m.incomingFileOffer.Filename, Allow: true, SourceID: m.offeringClientID},
+			if len(m.incomingOffers) > 0 && !m.dnd {
+				p := m.popIncomingOffer()
+				if m.promptSavePath && !p.Offer.AsClipboard {
+					return m, m.askSavePath(p)
 				}
-				cmds = append(cmds, sendWebsocketMessageCmd(m.wsConn, ack))
-				// TODO: Prepare to receive file chunks
-				m.incomingFileOffer = nil // Clear offer state
+				return m, m.answerOffer(p, true, "")
 			}
-			return m, tea.Batch(cmds...)
+			return m, nil
+
+		case (key.Matches(msg, m.keys.AllowDevice) || key.Matches(msg, m.keys.BlockDevice)) && !m.filtering():
+			if len(m.incomingOffers) == 0 || m.dnd {
+				return m, nil
+			}
+			return m, m.setTransferPolicy(m.incomingOffers[0].FromID, key.Matches(msg, m.keys.AllowDevice))
+
+		case m.pendingOverwrite != nil && key.Matches(msg, m.keys.ConfirmOverwrite):
+			content := *m.pendingOverwrite
+			m.pendingOverwrite = nil
+			m.logf("Overwriting local clipboard with received clip.")
+			return m, m.applyReceivedClip(content)
+
+		case m.pendingOverwrite != nil && key.Matches(msg, m.keys.SkipOverwrite):
+			m.pendingOverwrite = nil
+			m.logf("Kept local clipboard; received clip is still in history.")
+			return m, nil
+
+		case m.motd != "" && key.Matches(msg, m.keys.DismissMOTD):
+			m.motd = ""
+			m.updateFocus()
+			return m, m.relayout()
 
 		case key.Matches(msg, m.keys.RejectFile):
-			if m.incomingFileOffer != nil {
-				m.logf("Rejecting file offer for '%s'", m.incomingFileOffer.Filename)
-				ack := BaseMessage{
-					Type: "file_ack",
-					Data: FileAckData{Filename: m.incomingFileOffer.Filename, Allow: false, SourceID: m.offeringClientID},
-				}
-				cmds = append(cmds, sendWebsocketMessageCmd(m.wsConn, ack))
-				m.incomingFileOffer = nil // Clear offer state
+			if len(m.incomingOffers) > 0 && !m.dnd {
+				return m, m.answerOffer(m.popIncomingOffer(), false, "")
 			}
-			return m, tea.Batch(cmds...)
+			return m, nil
+
+		case key.Matches(msg, m.keys.ViewEntry) && m.focus == HistoryPane && m.histList.FilterState() != list.Filtering:
+			if item, ok := m.histList.SelectedItem().(historyItem); ok {
+				m.openContentModal("Clipboard Entry", item.Content)
+			}
+			return m, nil
+
+		case key.Matches(msg, m.keys.HistoryTop) && m.focus == HistoryPane && !m.filtering():
+			m.histList.Select(0)
+			return m, nil
+
+		case key.Matches(msg, m.keys.HistoryBottom) && m.focus == HistoryPane && !m.filtering():
+			// The oldest loaded entry; landing there pages in more if the server has them
+			if n := len(m.histList.VisibleItems()); n > 0 {
+				m.histList.Select(n - 1)
+			}
+			return m, m.loadMoreHistory()
+
+		case key.Matches(msg, m.keys.ToggleMark) && m.focus == HistoryPane && m.histList.FilterState() != list.Filtering:
+			m.toggleJoinMark()
+			return m, nil
+
+		case key.Matches(msg, m.keys.JoinMarked) && m.focus == HistoryPane && m.histList.FilterState() != list.Filtering:
+			return m, m.joinMarked()
+
+		case key.Matches(msg, m.keys.Favorite) && m.focus == HistoryPane && m.histList.FilterState() != list.Filtering:
+			m.toggleFavorite()
+			return m, nil
+
+		case key.Matches(msg, m.keys.FavoritesView) && m.focus == HistoryPane && m.histList.FilterState() != list.Filtering:
+			m.toggleFavoritesView()
+			return m, nil
+
+		case key.Matches(msg, m.keys.MarkDiff) && m.focus == HistoryPane && m.histList.FilterState() != list.Filtering:
+			item, ok := m.histList.SelectedItem().(historyItem)
+			switch {
+			case !ok:
+			case m.diffMark == nil:
+				m.diffMark = &item
+				m.logf("Marked entry for diff; select another and press %s.", m.keys.MarkDiff.Help().Key)
+			case m.diffMark.Content == item.Content:
+				m.diffMark = nil
+				m.logf("Diff mark cleared.")
+			default:
+				m.openDiffModal(*m.diffMark, item)
+				m.diffMark = nil
+			}
+			return m, nil
+
+		case key.Matches(msg, m.keys.DeleteEntry) && m.focus == HistoryPane && m.histList.FilterState() != list.Filtering:
+			item, ok := m.histList.SelectedItem().(historyItem)
+			if !ok {
+				return m, nil
+			}
+			m.removeHistoryEntry(item.Content)
+			m.logf("Deleted history entry (%d bytes)", len(item.Content))
+			if m.connectedState != Connected {
+				return m, nil // Local only; the server copy stays until we're back online
+			}
+			return m, sendWebsocketMessageCmd(m.wsConn, BaseMessage{Type: "delete_history_entry", Data: newHistoryEntryData(item.Content)})
 
 		case key.Matches(msg, m.keys.InitiateXfer):
+			if group, ok := m.deviceList.SelectedItem().(groupItem); ok && m.focus == DevicesPane {
+				if m.connectedState != Connected {
+					m.logf("Not connected; cannot send files.")
+					return m, nil
+				}
+				m.xferTargetID, m.xferGroup = "", group.Name // The server offers it to the group's devices but us
+				m.pathInput.SetValue("")
+				m.promptingPath = true
+				return m, m.pathInput.Focus()
+			}
 			if m.focus == DevicesPane && m.deviceList.SelectedItem() != nil {
 				selectedDevice := m.deviceList.SelectedItem().(deviceItem)
-				if selectedDevice.ID == "" { // Don't xfer to self or unknown
+				if selectedDevice.ID == "" || selectedDevice.ID == m.selfID { // Don't xfer to self or unknown
 					m.logf("Cannot initiate transfer with selected device.")
 					return m, nil
 				}
-				m.logf("Initiating file transfer with %s (Not Implemented)", selectedDevice.Hostname)
-				// TODO: Implement file selection (needs external library or input field)
-				// 1. Prompt for file path
-				// 2. Get file size
-				// 3. Send file_offer message
+				if selectedDevice.Server != "" {
+					m.logf("Files can only be sent to devices on %s.", m.serverLabel)
+					return m, nil
+				}
+				m.xferTargetID, m.xferGroup = selectedDevice.ID, ""
+				m.pathInput.SetValue("")
+				m.promptingPath = true
+				return m, m.pathInput.Focus()
+			}
+			return m, nil
+
+		case key.Matches(msg, m.keys.SendToAll) && !m.filtering():
+			if m.connectedState != Connected {
+				m.logf("Not connected; cannot send files.")
+				return m, nil
+			}
+			m.xferTargetID, m.xferGroup = "", "" // The server offers it to everyone but us
+			m.pathInput.SetValue("")
+			m.promptingPath = true
+			return m, m.pathInput.Focus()
+
+		case key.Matches(msg, m.keys.ToggleGroup) && m.focus == DevicesPane && !m.filtering():
+			if group, ok := m.deviceList.SelectedItem().(groupItem); ok {
+				m.collapsedGroups[group.Name] = !group.Collapsed
+				m.refreshDeviceList()
+			}
+			return m, nil
+
+		case key.Matches(msg, m.keys.PushToGroup) && m.focus == DevicesPane && !m.filtering():
+			group, ok := m.deviceList.SelectedItem().(groupItem)
+			if !ok {
+				m.logf("Select a group in the Devices pane to push to.")
+				return m, nil
+			}
+			return m, m.pushToGroup(group.Name)
+
+		case key.Matches(msg, m.keys.AppendMode) && !m.filtering():
+			m.appendMode = !m.appendMode
+			if m.appendMode {
+				m.logf("Append mode on: received clips are added to the end of your clipboard.")
+			} else {
+				m.logf("Append mode off: received clips replace your clipboard.")
+			}
+			return m, nil
+
+		case key.Matches(msg, m.keys.DoNotDisturb) && !m.filtering():
+			m.dnd = !m.dnd
+			switch {
+			case m.dnd:
+				m.logf("Do not disturb on: file offers will wait without prompting.")
+			case len(m.incomingOffers) > 0:
+				m.logf("Do not disturb off: %d offer(s) held, first '%s' from %s. Press 'a' to accept, 'r' to reject.",
+					len(m.incomingOffers), m.incomingOffers[0].Offer.Filename, m.devicesMap[m.incomingOffers[0].FromID])
+			default:
+				m.logf("Do not disturb off.")
 			}
 			return m, nil
 		}
@@ -220,7 +871,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		switch m.focus {
 		case HistoryPane:
 			m.histList, cmd = m.histList.Update(msg)
-			cmds = append(cmds, cmd)
+			cmds = append(cmds, cmd, m.loadMoreHistory(), m.searchServerHistory())
 		case DevicesPane:
 			m.deviceList, cmd = m.deviceList.Update(msg)
 			cmds = append(cmds, cmd)
@@ -230,6 +881,43 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			cmds = append(cmds, cmd)
 		}
 
+	case tea.MouseMsg:
+		m.statusHover = m.overStatusBar(msg.Y)
+
+	case PingRTTMsg:
+		m.rttSamples = append(m.rttSamples, msg.RTT)
+		if len(m.rttSamples) > rttWindow {
+			m.rttSamples = m.rttSamples[1:]
+		}
+
+	case ReconnectTickMsg:
+		if msg.Gen != m.reconnectGen || m.connectedState != Disconnected {
+			break // Connected or retried manually since
+		}
+		if time.Now().Before(m.nextRetry) {
+			cmds = append(cmds, reconnectTick(m.reconnectGen)) // Keep the countdown ticking
+			break
+		}
+		cmds = append(cmds, m.reconnect())
+
+	case ServerInfoTimeoutMsg:
+		if msg.Gen == m.reconnectGen && m.awaitingServerInfo {
+			m.awaitingServerInfo = false
+			m.logf("No reply to server_info; assuming the server supports everything.")
+		}
+
+	case SyncPauseTickMsg:
+		if msg.Gen != m.pauseGen || m.pauseStep == 0 {
+			break // Cancelled or replaced by a newer pause
+		}
+		if time.Now().Before(m.pausedUntil) {
+			cmds = append(cmds, syncPauseTick(m.pauseGen)) // Keep the countdown ticking
+			break
+		}
+		m.cancelSyncPause()
+		m.syncEnabled = true
+		m.logf("Sync resumed")
+
 	case spinner.TickMsg:
 		if m.connectedState == Connecting {
 			m.spinner, cmd = m.spinner.Update(msg)
@@ -238,18 +926,29 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	// --- Connection and App Logic Messages ---
 	case ConnectionStatusMsg:
+		if msg.Server != 0 {
+			return m, m.handleExtraStatus(msg)
+		}
 		m.connectedState = msg.Status
 		m.lastError = msg.Err // Store error even on success (becomes nil)
+		m.recordConnEvent(msg.Status, msg.Err)
 
 		if msg.Status == Connected && msg.Conn != nil {
 			m.wsConn = msg.Conn
 			m.wsCtxCancel = msg.Cancel
+			m.compressionActive = msg.Compressed
+			m.reconnectAttempt = 0
+			m.gaveUp = false
+			m.nextRetry = time.Time{}
+			m.reconnectGen++
 			m.logf("Connected to server.")
-			// Start the listener and clipboard checker *after* connection established
-			cmds = append(cmds, listenWebSocketCmd(context.Background(), m.wsConn, m.programRef)) // Pass program ref!
-			cmds = append(cmds, checkLocalClipboardCmd(m.lastSentClip)) // Initial check
+			// Start the listener *after* connection established
+			cmds = append(cmds, listenWebSocketCmd(context.Background(), m.wsConn, m.programRef, 0)) // Pass program ref!
 			// Request initial device list from server
 			cmds = append(cmds, sendWebsocketMessageCmd(m.wsConn, BaseMessage{Type: "request_devices"}))
+			m.serverInfo = nil // It may have been upgraded while we were away
+			m.awaitingServerInfo = true
+			cmds = append(cmds, sendWebsocketMessageCmd(m.wsConn, BaseMessage{Type: "server_info"}), serverInfoTimeout(m.reconnectGen))
 
 		} else { // Disconnected or Error during connection
 			if m.wsCtxCancel != nil {
@@ -257,66 +956,231 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.wsCtxCancel = nil
 			}
 			m.wsConn = nil
+			m.selfID = "" // The server assigns a new one on reconnect
+			m.awaitingServerInfo = false
+			clear(m.pendingAcks)
+			m.historyLoading = false
+			m.rttSamples = nil
+			m.cleanupTransfers() // In-flight transfers can't survive the connection
 			if msg.Err != nil {
 				m.logf("Connection Error: %v", msg.Err)
-				// Schedule reconnect attempt?
-				// cmds = append(cmds, tea.Tick(5*time.Second, func(t time.Time) tea.Msg {
-				// 	 return connectCmd(m.serverURL, m.apiKey, m.hostname)
-				// }))
 			} else {
 				m.logf("Disconnected.")
 			}
+			if !m.quitting {
+				cmds = append(cmds, m.scheduleReconnect())
+			}
 		}
 
 	case ReceivedServerMsg: // Process messages received via WebSocket listener
+		if msg.Server != 0 {
+			return m, m.handleExtraMessage(msg.Server, msg.Msg)
+		}
 		serverMsg := msg.Msg
-		m.logf("Server -> Type: %s", serverMsg.Type) // Log received type
+		if serverMsg.Type != "stats" && serverMsg.Type != "file_chunk" { // Too frequent to be useful in the log pane
+			m.logf("Server -> Type: %s", serverMsg.Type) // Log received type
+		}
 
 		switch serverMsg.Type {
 		case "clipboard_update":
+			if serverMsg.SenderID != "" && serverMsg.SenderID == m.selfID {
+				m.logf("Ignoring echo of our own clipboard_update")
+				break
+			}
 			var data ClipboardUpdateData
-			if err := RemarshalData(serverMsg.Data, &data); err == nil {
-				m.lastRcvdClip = data.Content
-				// Add to history list
-				m.histList.InsertItem(0, historyItem(data.Content))
-				if len(m.histList.Items()) > maxHistorySize {
-					m.histList.RemoveItem(len(m.histList.Items()) - 1)
-				}
-				// Write to local clipboard if sync enabled and not an echo
-				if m.syncEnabled && data.Content != m.lastSentClip {
-					cmds = append(cmds, writeToClipboardCmd(data.Content))
-				}
+			err := RemarshalData(serverMsg.Data, &data)
+			if err == nil && data.Channel != "" && data.Channel != m.channel {
+				break // Sent before the server saw our set_channel
+			}
+			var content string
+			if err == nil {
+				content, err = data.Text()
+			}
+			if err == nil && data.Initial && !m.seedOnConnect {
+				// Just show it; lastRcvdClip keeps it from echoing if it's already our clipboard
+				m.lastRcvdClip = content
+				m.addHistoryEntry(historyItem{Content: content, Seq: data.Seq})
+				m.logf("Server clip added to history, not written to clipboard (SEED_ON_CONNECT is off).")
+			} else if err == nil {
+				cmds = append(cmds, m.applyRemoteClip(historyItem{Content: content, Seq: data.Seq, Origin: m.clipOrigin(serverMsg.SenderID)}))
 			} else {
 				m.logf("Error decoding clipboard_update: %v", err)
 			}
 
+		case "primary_update":
+			var data ClipboardUpdateData
+			err := RemarshalData(serverMsg.Data, &data)
+			var content string
+			if err == nil {
+				content, err = data.Text()
+			}
+			if err != nil {
+				m.logf("Error decoding primary_update: %v", err)
+				break
+			}
+			if !syncPrimary || !m.appliesClips() || (data.Channel != "" && data.Channel != m.channel) {
+				break
+			}
+			m.lastRcvdPrimary, m.lastPrimary = content, content
+			cmds = append(cmds, writePrimaryCmd(content))
+
 		case "clipboard_history":
 			var data ClipboardHistoryData
 			if err := RemarshalData(serverMsg.Data, &data); err == nil {
-				newHist := make([]list.Item, len(data.History))
-				for i, h := range data.History {
-					newHist[i] = historyItem(h)
+				if data.Channel != "" && data.Channel != m.channel {
+					break
+				}
+				// Binary entries come base64-encoded, as they were sent; the list holds them decoded
+				var skipped int
+				data.History, skipped = decodeHistory(data.History)
+				if skipped > 0 {
+					m.logf("Skipped %d history entries that could not be decoded", skipped)
+				}
+				removedListed := false
+				if data.Removed != "" {
+					if removed, err := (ClipboardUpdateData{Content: data.Removed, Encoding: data.RemovedEncoding}).Text(); err == nil {
+						data.Removed = removed
+						removedListed = m.removeHistoryEntry(data.Removed)
+					} else {
+						m.logf("Error decoding removed history entry: %v", err)
+					}
+				}
+				if data.Expired {
+					if m.lastRcvdClip == data.Removed {
+						m.lastRcvdClip = "" // Gone from CopyReceived too
+					}
+					m.logf("An expiring clip (%d bytes) reached its TTL and was removed from history.", len(data.Removed))
+					if m.clipboardAvailable {
+						// Set before the clear, so a poll that sees the empty clipboard first doesn't send it
+						m.clearedExpired = true
+						cmds = append(cmds, clearExpiredClipCmd(data.Removed)) // If it's still what's on the clipboard
+					}
+				}
+				if data.Cleared {
+					m.histList.SetItems(nil)
+					m.histAll = nil
+					m.logf("History was cleared by another device.")
 				}
-				m.histList.SetItems(newHist)
-				m.logf("Received clipboard history (%d items)", len(newHist))
+				switch {
+				case data.Removed != "" && len(data.History) == 0:
+					// Just the removal; the rest of the list, older pages included, still stands
+					if removedListed {
+						m.historyLoaded--
+					}
+					m.historyLoaded = max(min(m.historyLoaded, data.Total), 0)
+				case data.Since > 0:
+					m.mergeServerHistory(data.History)
+					// The delta lands on top of what we already hold, pushing it down
+					m.historyLoaded = min(m.historyLoaded+len(data.History), data.Total)
+				case data.Offset == 0 && data.Before == 0:
+					m.mergeServerHistory(data.History)
+					m.historyLoaded = len(data.History)
+				default:
+					m.appendServerHistory(data.History)
+					m.historyLoaded = data.Offset + len(data.History)
+				}
+				m.historyTotal = data.Total
+				m.historyLoading = false
+				m.logf("Received clipboard history (%d items)", len(data.History))
 			} else {
 				m.logf("Error decoding clipboard_history: %v", err)
 			}
 
+		case "search_results":
+			var data SearchResultsData
+			if err := RemarshalData(serverMsg.Data, &data); err == nil {
+				before := len(m.historyItems())
+				data.Results, _ = decodeHistory(data.Results)
+				m.appendServerHistory(data.Results)
+				m.logf("Server search for %q: %d matches, %d not yet listed", data.Query, len(data.Results), len(m.historyItems())-before)
+				if data.Truncated {
+					m.logf("More entries match; refine the filter to see them.")
+				}
+				// Re-runs an applied filter so the new entries show up in it
+				cmds = append(cmds, m.histList.SetItems(m.histList.Items()))
+			} else {
+				m.logf("Error decoding search_results: %v", err)
+			}
+
+		case "clipboard_ack":
+			var data ClipAckData
+			if err := RemarshalData(serverMsg.Data, &data); err == nil {
+				if pending, ok := m.pendingAcks[data.Digest]; ok {
+					delete(m.pendingAcks, data.Digest)
+					m.numberLocalEntry(pending.Content, data.Seq)
+					if pending.Content == m.lastSentClip {
+						m.lastSentSeq = data.Seq
+					}
+				}
+			} else {
+				m.logf("Error decoding clipboard_ack: %v", err)
+			}
+
+		case "current_clip":
+			var data ClipboardUpdateData
+			err := RemarshalData(serverMsg.Data, &data)
+			var content string
+			if err == nil {
+				content, err = data.Text()
+			}
+			switch {
+			case err != nil:
+				m.logf("Error decoding current_clip: %v", err)
+			case content == "":
+				m.logf("The server has no current clip.")
+			default:
+				match := "differs from"
+				if content == m.lastLocalClip {
+					match = "matches"
+				}
+				m.logf("Server clip (seq %d) %s the local clipboard.", data.Seq, match)
+				m.openContentModal(fmt.Sprintf("Server Clip, seq %d", data.Seq), content)
+			}
+
+		case "server_restarting":
+			var data RestartData
+			if err := RemarshalData(serverMsg.Data, &data); err == nil {
+				// Jitter spreads every client's first retry so the new server isn't hit at once
+				hint := time.Duration(data.DowntimeSeconds) * time.Second
+				m.restartHold = max(hint, reconnectBaseDelay) + time.Duration(rand.Int63n(int64(max(hint/4, restartJitter))))
+				m.logf("Server is restarting; reconnecting in about %s.", m.restartHold.Round(time.Second))
+			} else {
+				m.logf("Error decoding server_restarting: %v", err)
+			}
+
+		case "welcome":
+			var data WelcomeData
+			if err := RemarshalData(serverMsg.Data, &data); err == nil {
+				m.selfID = data.ID
+				m.refreshDeviceList() // In case the device list arrived first
+				m.logf("Server assigned ID %s", data.ID)
+				if m.epoch != "" && data.Epoch != m.epoch {
+					// Seqs start over after a restart, so ours can't be compared to the server's
+					m.logf("Server restarted; resyncing history.")
+					m.forgetSeqs()
+				}
+				m.epoch = data.Epoch
+				if data.Credential != "" && data.Credential != m.credential {
+					m.credential = data.Credential
+					saveDeviceCredential(data.Credential)
+					m.logf("Saved the device credential issued for this pairing; reconnects use it.")
+				}
+			} else {
+				m.logf("Error decoding welcome: %v", err)
+			}
+
 		case "device_list":
 			var data DeviceListData
 			if err := RemarshalData(serverMsg.Data, &data); err == nil {
-				devItems := make([]list.Item, 0, len(data.Devices))
+				m.devices = data.Devices
 				m.devicesMap = make(map[string]string) // Reset map
 				for _, d := range data.Devices {
-					// Don't list self (based on hostname potentially?)
-					// Or server could filter based on SenderID if request initiated it
-					// For now, list all received
-					devItems = append(devItems, deviceItem(d))
-					m.devicesMap[d.ID] = d.Hostname // Store for lookup
+					m.devicesMap[d.ID] = d.Name() // Store for lookup
 				}
-				m.deviceList.SetItems(devItems)
-				m.logf("Updated device list (%d devices)", len(devItems))
+				m.refreshDeviceList()
+				m.pruneOffers() // Nobody is left to answer offers to or from departed devices
+				m.logf("Updated device list (%d devices)", len(data.Devices))
+				emitEvent(headlessEvent{Type: "devices", Devices: data.Devices})
 			} else {
 				m.logf("Error decoding device_list: %v", err)
 			}
@@ -328,10 +1192,43 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				if senderHostname == "" {
 					senderHostname = serverMsg.SenderID // Fallback to ID
 				}
-				m.logf(">>> Incoming file offer: '%s' (%d bytes) from %s", data.Filename, data.Filesize, senderHostname)
-				m.logf(">>> Press 'a' to accept, 'r' to reject.")
-				m.incomingFileOffer = &data
-				m.offeringClientID = serverMsg.SenderID // Store sender ID
+				if data.AsClipboard {
+					// Large clips are just sync over the file path, so accept without prompting
+					allow := m.appliesClips()
+					if allow {
+						if err := m.beginReceive(data, serverMsg.SenderID, ""); err != nil {
+							m.logf("Cannot receive large clip from %s: %v", senderHostname, err)
+							allow = false
+						}
+					}
+					ack := BaseMessage{
+						Type: "file_ack",
+						Data: FileAckData{TransferID: data.TransferID, Filename: data.Filename, Allow: allow, SourceID: serverMsg.SenderID},
+					}
+					cmds = append(cmds, sendWebsocketMessageCmd(m.wsConn, ack))
+					break
+				}
+				size := fmt.Sprintf("%d bytes", data.Filesize)
+				if data.Filesize == unknownFilesize {
+					size = "streamed, size unknown"
+				}
+				m.logf(">>> Incoming file offer: '%s' (%s) from %s", data.Filename, size, senderHostname)
+				if allow, ok := m.transferPolicyFor(serverMsg.SenderID); ok {
+					reason := ""
+					if !allow {
+						reason = "device blocked"
+					}
+					cmds = append(cmds, m.answerOffer(pendingOffer{Offer: data, FromID: serverMsg.SenderID}, allow, reason))
+					break
+				}
+				if m.dnd {
+					m.logf("(DND) Holding offer for later review.")
+				} else if len(m.incomingOffers) > 0 {
+					m.logf(">>> Queued behind %d pending offer(s).", len(m.incomingOffers))
+				} else {
+					m.logf(">>> Press 'a' to accept, 'r' to reject.")
+				}
+				m.incomingOffers = append(m.incomingOffers, pendingOffer{Offer: data, FromID: serverMsg.SenderID})
 			} else {
 				m.logf("Error decoding file_offer: %v", err)
 			}
@@ -344,43 +1241,342 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					receiverHostname = serverMsg.SenderID
 				}
 				if data.Allow {
-					m.logf("'%s' accepted file '%s'. Starting transfer (Not Implemented)", receiverHostname, data.Filename)
-					// TODO: Implement command to start sending file chunks
+					m.logf("'%s' accepted file '%s'. Starting transfer", receiverHostname, data.Filename)
+					cmds = append(cmds, m.startSendSession(data.TransferID, serverMsg.SenderID))
 				} else {
-					m.logf("'%s' rejected file '%s'.", receiverHostname, data.Filename)
+					if data.Reason != "" {
+						m.logf("'%s' rejected file '%s': %s.", receiverHostname, data.Filename, data.Reason)
+					} else {
+						m.logf("'%s' rejected file '%s'.", receiverHostname, data.Filename)
+					}
+					cmds = append(cmds, m.advanceOfferQueue(serverMsg.SenderID, data.TransferID))
 				}
 			} else {
 				m.logf("Error decoding file_ack: %v", err)
 			}
 
+		case "file_chunk":
+			var data FileChunkData
+			if err := RemarshalData(serverMsg.Data, &data); err == nil {
+				cmds = append(cmds, m.handleFileChunk(data, serverMsg.SenderID))
+			} else {
+				m.logf("Error decoding file_chunk: %v", err)
+			}
+
+		case "file_cancel":
+			var data FileCancelData
+			if err := RemarshalData(serverMsg.Data, &data); err == nil {
+				cmds = append(cmds, m.handleFileCancel(data, serverMsg.SenderID))
+			} else {
+				m.logf("Error decoding file_cancel: %v", err)
+			}
+
+		case "error":
+			var data ErrorData
+			if err := RemarshalData(serverMsg.Data, &data); err == nil && m.awaitingServerInfo &&
+				(data.Code == "unknown_type" || data.Code == "invalid_message") && strings.Contains(data.Message, "server_info") {
+				// Older servers answer server_info with one of these, naming the type
+				m.awaitingServerInfo = false
+				m.logf("Server predates server_info; assuming it supports everything.")
+			} else if err == nil {
+				m.logf(">>> Server rejected message (%s): %s", data.Code, data.Message)
+				m.lastError = fmt.Errorf("server: %s", data.Message)
+			} else {
+				m.logf("Error decoding error message: %v", err)
+			}
+
+		case "auth_challenge":
+			// Answered with the configured key; if that's the old one, the server drops us
+			m.logf("Server API key changed; re-authenticating with the configured key.")
+			cmds = append(cmds, sendWebsocketMessageCmd(m.wsConn, BaseMessage{Type: "auth_response", Data: AuthResponseData{APIKey: m.apiKey}}))
+
+		case "server_info":
+			var data ServerInfoData
+			m.awaitingServerInfo = false
+			if err := RemarshalData(serverMsg.Data, &data); err == nil {
+				m.serverInfo = &data
+				maxClip := "no limit"
+				if data.MaxClipBytes > 0 {
+					maxClip = formatBytes(int64(data.MaxClipBytes))
+				}
+				m.logf("Server version %s (max clip %s, history %d, transfers %v, channels %v)",
+					data.Version, maxClip, data.HistorySize, data.Transfers, data.Channels)
+			} else {
+				m.logf("Error decoding server_info: %v", err)
+			}
+
+		case "motd":
+			var data MOTDData
+			if err := RemarshalData(serverMsg.Data, &data); err != nil {
+				m.logf("Error decoding motd: %v", err)
+				break
+			}
+			if data.Text == m.motdSeen {
+				break // Sent again on reconnect; a dismissed banner stays dismissed
+			}
+			if data.Text == "" {
+				m.logf("Server message withdrawn.")
+			} else {
+				m.logf("Server message: %s", data.Text)
+			}
+			m.motdSeen, m.motd = data.Text, data.Text
+			cmds = append(cmds, m.relayout())
+
+		case "stats":
+			var data StatsData
+			if err := RemarshalData(serverMsg.Data, &data); err == nil {
+				m.serverStats = &data
+			} else {
+				m.logf("Error decoding stats: %v", err)
+			}
+
 		default:
 			m.logf("Received unhandled server message type: %s", serverMsg.Type)
 		}
 
+	case ClipboardResendMsg:
+		switch {
+		case msg.Err != nil:
+			m.logf("Error reading clipboard to resend: %v", msg.Err)
+		case msg.Content == "":
+			m.logf("Clipboard is empty, nothing to resend.")
+		case m.connectedState != Connected:
+			m.logf("Disconnected before the clipboard could be resent.")
+		case msg.OneShot && msg.Content == m.lastSentClip:
+			m.logf("Clipboard unchanged since it was last sent; nothing to sync.")
+		case msg.TTLSeconds > 0 && m.sendsAsFile(msg.Content):
+			m.logf("Clipboard is too large to send as an expiring clip (%d bytes); files don't expire.", len(msg.Content))
+		default:
+			// A resend bypasses change detection: the point is to push content peers may have missed
+			m.lastLocalClip, m.lastSentClip = msg.Content, msg.Content
+			m.addHistoryEntry(historyItem{Content: msg.Content, Local: true})
+			if m.sendsAsFile(msg.Content) {
+				cmds = append(cmds, prepareClipFileCmd(msg.Content))
+			} else {
+				data := newClipboardUpdateData(msg.Content)
+				data.Resend = !msg.OneShot
+				data.TTLSeconds = msg.TTLSeconds
+				m.expectAck(data, msg.Content)
+				m.lastSentAt, m.lastSentSeq = time.Now(), 0
+				cmds = append(cmds, sendWebsocketMessageCmd(m.wsConn, BaseMessage{Type: "clipboard_update", Data: data}))
+			}
+			m.clipsSent++
+			if msg.OneShot {
+				m.logf("One-shot sync sent (%d bytes)", len(msg.Content))
+			} else if msg.TTLSeconds > 0 {
+				m.logf("Sent current clipboard (%d bytes), expiring in %s", len(msg.Content), time.Duration(msg.TTLSeconds)*time.Second)
+			} else {
+				m.logf("Resent current clipboard (%d bytes)", len(msg.Content))
+			}
+		}
+
 	case LocalClipboardCheckedMsg:
-		if m.connectedState != Connected { // Don't process if not connected
-			return m, nil
+		// Read errors are ignored here to reduce log noise; the poller just tries again
+		changed := msg.Err == nil && msg.Changed
+		// A clip we just received (or appended) lands here too and must not echo back,
+		// nor may a transform result copied without syncing
+		// (with NORMALIZE_LINE_ENDINGS it comes back with local line endings)
+		echo := msg.Content == m.lastRcvdClip || msg.Content == localLineEndings(m.lastRcvdClip) ||
+			(m.lastAppendedClip != "" && msg.Content == m.lastAppendedClip) ||
+			(m.unsyncedClip != "" && msg.Content == m.unsyncedClip) ||
+			(m.clearedExpired && msg.Content == "")
+		if changed && m.clipSettle > 0 && !msg.Settled {
+			// Hold the change until it's stayed put for clipSettle; polling carries on meanwhile
+			m.lastLocalClip = msg.Content
+			m.settleSince = time.Now()
+			pending, since := msg.Content, m.settleSince
+			cmds = append(cmds, tea.Tick(m.clipSettle, func(time.Time) tea.Msg {
+				return ClipSettleMsg{Content: pending, Since: since}
+			}))
+			changed = false
+		}
+		if changed && msg.Settled && msg.Content == m.lastSentClip {
+			changed = false // Edited and put back within the window
+		}
+		if changed && msg.Content != m.unsyncedClip {
+			m.unsyncedClip = "" // Copying it again later syncs as usual
+		}
+		if changed && msg.Content != "" {
+			m.clearedExpired = false
+		}
+		if changed {
+			m.lastLocalClip = msg.Content
+			// Echoes are already in history.
+			// An emptied clipboard is still sent, and the server decides whether to relay it.
+			if !echo && msg.Content != "" {
+				m.addHistoryEntry(historyItem{Content: msg.Content, Local: true})
+			}
+		}
+		// Only send if connected, sync enabled, content changed, and it's not an echo of what we just received
+		if m.connectedState == Connected && m.sendsClips() && changed && !echo {
+			m.lastSentClip = msg.Content
+			if m.sendsAsFile(msg.Content) {
+				m.logf("Local clipboard changed (%d bytes), sending as file...", len(msg.Content))
+				cmds = append(cmds, prepareClipFileCmd(msg.Content))
+				m.clipsSent++
+			} else {
+				if msg.Binary {
+					m.logf("Local clipboard changed (binary, base64-encoded), sending update...")
+				} else {
+					m.logf("Local clipboard changed, sending update...")
+				}
+				data := newClipboardUpdateData(msg.Content)
+				m.expectAck(data, msg.Content)
+				m.lastSentAt, m.lastSentSeq = time.Now(), 0
+				updateMsg := BaseMessage{
+					Type: "clipboard_update",
+					Data: data,
+				}
+				cmds = append(cmds, sendWebsocketMessageCmd(m.wsConn, updateMsg))
+				m.clipsSent++
+			}
+		}
+		// Extra servers get it whether or not the main server is up; large clips only go to the main one
+		if len(m.extraServers) > 0 && m.sendsClips() && changed && !echo &&
+			!m.sendsAsFile(msg.Content) {
+			m.lastSentClip = msg.Content
+			cmds = append(cmds, m.sendToExtraServers(msg.Content)...)
+		}
+		// Schedule the next check regardless of change; settle re-reads aren't part of the poll loop
+		if !msg.Settled {
+			cmds = append(cmds, tea.Tick(2*time.Second, func(t time.Time) tea.Msg {
+				// Pass the *current* lastLocalClip value when scheduling the next check
+				return checkLocalClipboardCmd(m.lastLocalClip)()
+			}))
+		}
+
+	case ClipSettleMsg:
+		// Stale if a newer change restarted the window
+		if msg.Since.Equal(m.settleSince) {
+			return m, settleClipboardCmd(msg.Content)
+		}
+
+	case ExtraReconnectMsg:
+		if s := m.extraServers[msg.Server-1]; msg.Gen == s.gen && s.state != Connected && !m.quitting {
+			return m, m.extraConnectCmd(msg.Server)
+		}
+
+	case ScreenLockMsg:
+		return m, m.handleScreenLock(msg)
+
+	case DimCheckMsg:
+		return m, m.checkDim()
+
+	case PrimaryCheckedMsg:
+		if msg.Err == nil && msg.Changed {
+			m.lastPrimary = msg.Content
+			// Selections too big to relay are skipped rather than sent as files
+			tooBig := m.sendsAsFile(msg.Content)
+			if m.connectedState == Connected && m.sendsClips() && msg.Content != "" && msg.Content != m.lastRcvdPrimary && !tooBig {
+				cmds = append(cmds, sendWebsocketMessageCmd(m.wsConn, BaseMessage{Type: "primary_update", Data: newClipboardUpdateData(msg.Content)}))
+			}
+		}
+		cmds = append(cmds, tea.Tick(2*time.Second, func(time.Time) tea.Msg {
+			return checkPrimaryCmd(m.lastPrimary)()
+		}))
+
+	case OverwriteCheckedMsg:
+		// Nothing to lose if the clipboard can't be read, is empty, or already matches
+		if msg.Err != nil || msg.Local == "" || msg.Local == localLineEndings(msg.Incoming) {
+			return m, m.applyReceivedClip(msg.Incoming)
 		}
+		incoming := msg.Incoming
+		m.pendingOverwrite = &incoming
+		m.overwriteSummary = diffSummary(msg.Local, msg.Incoming)
+		m.logf(">>> Received clip differs from your clipboard: %s", m.overwriteSummary)
+
+	case AppendReadMsg:
+		// Unreadable or empty clipboards just take the clip as is
+		combined := msg.Incoming
+		if msg.Err == nil && msg.Local != "" {
+			combined = msg.Local + "\n" + msg.Incoming
+		}
+		combined = localLineEndings(combined)
+		m.lastAppendedClip = combined
+		cmd := writeToClipboardCmd(combined)
+		if m.clipHook != "" {
+			cmd = tea.Sequence(cmd, runClipHookCmd(m.clipHook, msg.Incoming)) // The hook gets only the new clip
+		}
+		return m, cmd
+
+	case ClipFilePreparedMsg:
 		if msg.Err != nil {
-			// m.logf("Clipboard read error: %v", msg.Err) // Reduce log noise
+			m.logf("Error preparing large clip: %v", msg.Err)
 			return m, nil
 		}
-		// If sync enabled, content changed, and it's not an echo of what we just received
-		if m.syncEnabled && msg.Changed && msg.Content != m.lastRcvdClip {
-			m.logf("Local clipboard changed, sending update...")
-			m.lastSentClip = msg.Content
-			updateMsg := BaseMessage{
-				Type: "clipboard_update",
-				Data: ClipboardUpdateData{Content: msg.Content},
+		// Only the newest large clip matters; drop the previous one's temp file
+		for id, o := range m.outgoingOffers {
+			if o.Offer.AsClipboard && o.TempFile {
+				os.Remove(o.Path)
+				delete(m.outgoingOffers, id)
 			}
-			cmds = append(cmds, sendWebsocketMessageCmd(m.wsConn, updateMsg))
 		}
-		// Schedule the next check regardless of change
-		cmds = append(cmds, tea.Tick(2*time.Second, func(t time.Time) tea.Msg {
-			// Pass the *current* lastSentClip value when scheduling the next check
-			return checkLocalClipboardCmd(m.lastSentClip)()
+		cmds = append(cmds, m.offerFile(&outgoingOffer{
+			Offer:    FileOfferData{Filesize: msg.Size, AsClipboard: true},
+			Path:     msg.Path,
+			TempFile: true,
 		}))
 
+	case FileChunkSentMsg:
+		s, ok := m.sendSessions[msg.Key]
+		if !ok {
+			return m, nil
+		}
+		if msg.Err != nil {
+			m.logf("Transfer of '%s' failed: %v", s.Filename, msg.Err)
+			m.finishSendSession(msg.Key)
+			// A failed read, like a zip of a directory erroring partway, leaves the peer
+			// holding a truncated file; a failed send most likely means no connection to
+			// tell it over, but it costs nothing to try
+			reason := "sender failed"
+			if msg.Read {
+				reason = cancelReadFailed
+			}
+			return m, tea.Batch(m.cancelTransfer(s.TransferID, s.PeerID, reason), m.advanceOfferQueue(s.PeerID, s.TransferID))
+		}
+		s.Sent += int64(msg.N)
+		if msg.Done {
+			m.logf("Sent '%s' to %s (%d bytes)", s.Filename, m.devicesMap[s.PeerID], s.Sent)
+			if o, ok := m.outgoingOffers[s.TransferID]; ok && !o.Offer.AsClipboard {
+				m.filesSent++
+			}
+			m.finishSendSession(msg.Key)
+			return m, m.advanceOfferQueue(s.PeerID, s.TransferID)
+		}
+		cmds = append(cmds, m.nextChunkCmd(msg.Key, s))
+
+	case ClipboardWrittenMsg:
+		if msg.Err == nil {
+			if m.clipWriteFailures >= clipWriteFailureWarn {
+				m.lastError = nil // Clear the warning set below
+			}
+			m.clipWriteFailures = 0
+			m.logf("Local clipboard updated.")
+			break
+		}
+		m.clipWriteFailures++
+		m.logf("Error: %v", msg.Err)
+		if m.clipWriteFailures >= clipWriteFailureWarn {
+			m.lastError = fmt.Errorf("%d clipboard writes failed in a row: %w", m.clipWriteFailures, msg.Err)
+		}
+
+	case PairingMsg:
+		if msg.Err != nil {
+			m.logf("Pairing failed: %v", msg.Err)
+			break
+		}
+		m.openPairingModal(msg.URL)
+
+	case ClipHookDoneMsg:
+		switch {
+		case msg.Err != nil && msg.Output != "":
+			m.logf("ON_CLIP_CHANGE failed: %v: %s", msg.Err, msg.Output)
+		case msg.Err != nil:
+			m.logf("ON_CLIP_CHANGE failed: %v", msg.Err)
+		case msg.Output != "":
+			m.logf("ON_CLIP_CHANGE: %s", msg.Output)
+		}
+
 	case ErrorMsg:
 		m.lastError = msg.Err
 		m.logf("Error: %v", msg.Err)
@@ -388,6 +1584,22 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case LogMsg:
 		m.logf(string(msg))
 
+	case list.FilterMatchesMsg:
+		// Lists filter asynchronously; the results belong to whichever list is being filtered
+		switch {
+		case m.showSnippets:
+			m.snippetList, cmd = m.snippetList.Update(msg)
+		case m.showPalette:
+			m.paletteList, cmd = m.paletteList.Update(msg)
+		case m.showTransforms:
+			m.transformList, cmd = m.transformList.Update(msg)
+		case m.focus == DevicesPane:
+			m.deviceList, cmd = m.deviceList.Update(msg)
+		default:
+			m.histList, cmd = m.histList.Update(msg)
+		}
+		cmds = append(cmds, cmd)
+
 	} // End main switch
 
 	// Update spinner if needed (outside main switch)
@@ -402,6 +1614,147 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, tea.Batch(cmds...)
 }
 
+// applyRemoteClip records a clip received from another device and writes it locally.
+func (m *Model) applyRemoteClip(item historyItem) tea.Cmd {
+	emitClipEvent(item)
+	content := item.Content
+	m.lastRcvdClip = content
+	m.clipsReceived++
+	if content == "" {
+		m.logf("Clipboard cleared on another device.") // Relayed by servers with SYNC_EMPTY_CLIPS on
+	} else {
+		m.addHistoryEntry(item)
+	}
+	// Write to local clipboard if sync enabled and not an echo
+	if m.appliesClips() && m.clipboardAvailable && content != m.lastSentClip {
+		if m.appendMode {
+			if content == "" {
+				return nil // Nothing to add
+			}
+			return readForAppendCmd(content) // Nothing is lost, so no overwrite confirmation
+		}
+		if m.racedWithSend(item) {
+			m.logf(">>> Clipboard conflict: remote overwrote your recent copy (%d bytes); it's still in history.", len(m.lastSentClip))
+		}
+		if m.confirmOverwrite {
+			return checkOverwriteCmd(content) // Compares with the local clipboard first
+		}
+		return m.applyReceivedClip(content)
+	}
+	return nil
+}
+
+// sendsClips reports whether local clipboard changes go to the server.
+func (m *Model) sendsClips() bool {
+	return m.syncEnabled && !m.pausedByLock && (m.syncMode == SyncBoth || m.syncMode == SyncPush)
+}
+
+// appliesClips reports whether received clips are written to the local clipboard.
+// They're recorded in history either way.
+func (m *Model) appliesClips() bool {
+	return m.syncEnabled && !m.pausedByLock && (m.syncMode == SyncBoth || m.syncMode == SyncPull)
+}
+
+// clipOrigin names the device a clip came from for the history pane: its hostname,
+// or a shortened ID if it's not in the device list. Empty when SHOW_CLIP_ORIGIN is off.
+func (m *Model) clipOrigin(senderID string) string {
+	if !m.showClipOrigin || senderID == "" {
+		return ""
+	}
+	name, ok := m.devicesMap[senderID]
+	if !ok {
+		name = senderID
+		if len(name) > 8 {
+			name = name[:8]
+		}
+	}
+	if len(m.extraServers) > 0 {
+		return m.serverLabel + ": " + name // Extra servers' clips are labelled the same way
+	}
+	return name
+}
+
+// syncPausePresets are the durations the PauseSync key cycles through.
+var syncPausePresets = []time.Duration{5 * time.Minute, 15 * time.Minute, 60 * time.Minute}
+
+// cycleSyncPause moves to the next pause preset, or resumes sync after the last one.
+func (m *Model) cycleSyncPause() tea.Cmd {
+	step := m.pauseStep + 1
+	if step > len(syncPausePresets) {
+		m.cancelSyncPause()
+		m.syncEnabled = true
+		m.logf("Sync resumed")
+		return nil
+	}
+	m.pauseGen++
+	m.pauseStep = step
+	m.pausedUntil = time.Now().Add(syncPausePresets[step-1])
+	m.syncEnabled = false
+	m.logf("Sync paused for %s", syncPausePresets[step-1])
+	return syncPauseTick(m.pauseGen)
+}
+
+// cancelSyncPause ends a timed pause without touching syncEnabled.
+func (m *Model) cancelSyncPause() {
+	m.pauseGen++
+	m.pauseStep = 0
+}
+
+func syncPauseTick(gen int) tea.Cmd {
+	return tea.Tick(time.Second, func(time.Time) tea.Msg {
+		return SyncPauseTickMsg{Gen: gen}
+	})
+}
+
+// sessionSummary is printed to stdout after the TUI exits via the Quit key.
+func (m Model) sessionSummary() string {
+	return fmt.Sprintf("clipd session: %s | clips sent %d, received %d | files sent %d, received %d",
+		time.Since(m.sessionStart).Round(time.Second), m.clipsSent, m.clipsReceived, m.filesSent, m.filesReceived)
+}
+
+// refreshDeviceList rebuilds the Devices pane from m.devices, leaving out this
+// device unless showSelf is set.
+func (m *Model) refreshDeviceList() {
+	devices := make([]ClientInfo, 0, len(m.devices))
+	for _, d := range m.devices {
+		if d.ID == m.selfID && !m.showSelf {
+			continue
+		}
+		devices = append(devices, d)
+	}
+	devItems := m.groupDevices(devices) // Only the main server's devices can be targeted as a group
+	for _, s := range m.extraServers {
+		for _, d := range s.devices {
+			if d.ID == s.selfID && !m.showSelf {
+				continue
+			}
+			devItems = append(devItems, deviceItem(d))
+		}
+	}
+	m.deviceList.SetItems(devItems)
+}
+
+// cycleFocus moves the focus step panes along paneOrder, wrapping around.
+func (m *Model) cycleFocus(step int) {
+	n := len(m.paneOrder)
+	for i, p := range m.paneOrder {
+		if p == m.focus {
+			m.focus = m.paneOrder[((i+step)%n+n)%n]
+			break
+		}
+	}
+	m.updateFocus()
+}
+
+// relayout re-runs the WindowSizeMsg layout, for when a banner comes or goes.
+func (m Model) relayout() tea.Cmd {
+	if !m.ready {
+		return nil // The first WindowSizeMsg will account for it
+	}
+	size := tea.WindowSizeMsg{Width: m.width, Height: m.height}
+	return func() tea.Msg { return size }
+}
+
 // updateFocus ensures the correct components are focused/blurred
 func (m *Model) updateFocus() {
 	m.histList.SetShowPagination(m.focus == HistoryPane)
@@ -409,15 +1762,214 @@ func (m *Model) updateFocus() {
 	m.deviceList.SetShowPagination(m.focus == DevicesPane)
 	m.deviceList.SetShowFilter(m.focus == DevicesPane)
 	m.logView.MouseWheelEnabled = (m.focus == LogPane)
+	_, canOpen := m.openableURL()
+	m.keys.OpenURL.SetEnabled(canOpen)
+	transfers := m.serverInfo == nil || m.serverInfo.Transfers
+	m.keys.InitiateXfer.SetEnabled(transfers)
+	m.keys.SendToAll.SetEnabled(transfers)
+	m.keys.NextChannel.SetEnabled(m.serverInfo == nil || m.serverInfo.Channels)
+	m.keys.DismissMOTD.SetEnabled(m.motd != "")
+
+}
+
+// Reconnect backoff doubles from reconnectBaseDelay up to reconnectMaxDelay.
+const (
+	reconnectBaseDelay = time.Second
+	reconnectMaxDelay  = 30 * time.Second
+	restartJitter      = 3 * time.Second // Minimum jitter window after server_restarting
+)
+
+// reconnectDelay is the backoff before the given attempt, counting from 1.
+func reconnectDelay(attempt int) time.Duration {
+	if attempt >= 6 { // 1s << 5 is already past the cap
+		return reconnectMaxDelay
+	}
+	return min(reconnectBaseDelay<<(attempt-1), reconnectMaxDelay)
+}
+
+// scheduleReconnect counts another attempt and starts the countdown to it.
+func (m *Model) scheduleReconnect() tea.Cmd {
+	if m.maxReconnects > 0 && m.reconnectAttempt >= m.maxReconnects {
+		m.gaveUp = true
+		m.nextRetry = time.Time{}
+		m.reconnectGen++
+		m.logf("Gave up after %d reconnect attempts; press ctrl+r to retry.", m.reconnectAttempt)
+		return nil
+	}
+	m.reconnectAttempt++
+	delay := reconnectDelay(m.reconnectAttempt)
+	if m.restartHold > 0 {
+		delay, m.restartHold = m.restartHold, 0
+	}
+	m.nextRetry = time.Now().Add(delay)
+	m.reconnectGen++
+	m.logf("Reconnecting in %s (attempt %d)", delay, m.reconnectAttempt)
+	return reconnectTick(m.reconnectGen)
+}
+
+// reconnect dials now, dropping any scheduled retry.
+func (m *Model) reconnect() tea.Cmd {
+	m.reconnectGen++
+	m.nextRetry = time.Time{}
+	m.connectedState = Connecting
+	return tea.Batch(m.spinner.Tick, connectCmd(m.serverURL, m.apiKey, m.credential, m.hostname, m.deviceID, m.channel, m.epoch, m.lastSeq))
+}
+
+// nextChannel switches to the next of CLIP_CHANNELS. The history pane only ever
+// shows one channel, so it's emptied and refilled from the server.
+func (m *Model) nextChannel() tea.Cmd {
+	i := 0
+	for j, c := range m.channels {
+		if c == m.channel {
+			i = (j + 1) % len(m.channels)
+			break
+		}
+	}
+	m.channel = m.channels[i]
+	m.histList.SetItems(nil)
+	m.histAll = nil
+	m.historyCap = m.historySize
+	m.lastSeq = 0
+	m.historyLoaded, m.historyTotal, m.historyLoading = 0, 0, false
+	m.logf("Switched to channel %q", m.channel)
+	if m.connectedState != Connected {
+		return nil // connectCmd joins m.channel
+	}
+	return sendWebsocketMessageCmd(m.wsConn, BaseMessage{Type: "set_channel", Data: ChannelData{Channel: m.channel}})
+}
+
+// serverInfoWait is how long a server gets to answer server_info, in case it
+// drops unknown types without an error.
+const serverInfoWait = 10 * time.Second
+
+func serverInfoTimeout(gen int) tea.Cmd {
+	return tea.Tick(serverInfoWait, func(time.Time) tea.Msg {
+		return ServerInfoTimeoutMsg{Gen: gen}
+	})
+}
+
+func reconnectTick(gen int) tea.Cmd {
+	return tea.Tick(time.Second, func(time.Time) tea.Msg {
+		return ReconnectTickMsg{Gen: gen}
+	})
+}
+
+// panicWipe clears the local clipboard, the history pane and the server's history
+// in one go, and turns sync off so the emptied clipboard isn't synced.
+func (m *Model) panicWipe() tea.Cmd {
+	m.cancelSyncPause()
+	m.syncEnabled = false
+	m.histList.SetItems(nil)
+	m.histAll, m.favorites = nil, make(map[string]bool)
+	m.showContent, m.showSnippets, m.promptingPath = false, false, false
+	m.showTransforms, m.transformSrc, m.unsyncedClip = false, "", ""
+	m.pendingOverwrite, m.diffMark = nil, nil
+	m.lastLocalClip, m.lastRcvdClip, m.lastSentClip, m.lastAppendedClip = "", "", "", ""
+	m.historyLoaded, m.historyTotal, m.lastSeq = 0, 0, 0 // A reconnect resyncs from scratch
+	m.logf("Panic wipe: clipboard and history cleared, sync disabled.")
+
+	var cmds []tea.Cmd
+	if m.clipboardAvailable {
+		cmds = append(cmds, writeToClipboardCmd(""))
+	}
+	if m.connectedState == Connected {
+		cmds = append(cmds, sendWebsocketMessageCmd(m.wsConn, BaseMessage{Type: "clear_history"}))
+	}
+	return tea.Batch(cmds...)
+}
+
+// clipWriteFailureWarn is how many consecutive failed clipboard writes it takes
+// to show a warning in the status bar rather than only in the log.
+const clipWriteFailureWarn = 3
+
+// rttWindow is how many recent pings the connection quality averages over.
+const rttWindow = 5
+
+// overStatusBar reports whether screen row y is the status line, below the
+// top margin and any banners View puts above it.
+func (m *Model) overStatusBar(y int) bool {
+	row := docStyle.GetMarginTop()
+	if !m.clipboardAvailable {
+		row++
+	}
+	if m.motd != "" {
+		row++
+	}
+	return y == row
+}
+
+// connectionQuality renders a colored dot for the average ping RTT, plus the value
+// in ms while the mouse is over the status bar, or "" before the first pong.
+func (m Model) connectionQuality() string {
+	if m.connectedState != Connected || len(m.rttSamples) == 0 {
+		return ""
+	}
+	var sum time.Duration
+	for _, rtt := range m.rttSamples {
+		sum += rtt
+	}
+	avg := sum / time.Duration(len(m.rttSamples))
+	style := syncStatusStyle // good
+	switch {
+	case avg >= 300*time.Millisecond:
+		style = errorStyle // poor
+	case avg >= 100*time.Millisecond:
+		style = warnStyle // ok
+	}
+	if !m.statusHover {
+		return style.Render("●")
+	}
+	return style.Render("●") + fmt.Sprintf(" %dms", avg.Milliseconds())
+}
 
+// filtering reports whether the focused list is taking typed filter input.
+func (m *Model) filtering() bool {
+	switch m.focus {
+	case HistoryPane:
+		return m.histList.FilterState() == list.Filtering
+	case DevicesPane:
+		return m.deviceList.FilterState() == list.Filtering
+	}
+	return false
 }
 
+// openContentModal shows the full content of a clip in a scrollable viewport
+func (m *Model) openContentModal(title, content string) {
+	lines := strings.Count(content, "\n") + 1
+	m.contentHeader = fmt.Sprintf(" %s | %d bytes, %d lines | c to copy, esc to close ", title, len(content), lines)
+	m.contentClip = content
+	m.contentView.SetContent(lipgloss.NewStyle().Width(m.contentView.Width).Render(sanitizeForDisplay(content)))
+	m.contentView.GotoTop()
+	m.showContent = true
+}
 
 func (m Model) View() string {
+	if m.dimmed {
+		return dimView(m.view())
+	}
+	return m.view()
+}
+
+func (m Model) view() string {
 	if !m.ready {
 		return "Initializing..."
 	}
 
+	if m.showContent {
+		header := listTitleStyle.Render(m.contentHeader)
+		body := focusedPaneStyle.Render(m.contentView.View())
+		return docStyle.Render(lipgloss.JoinVertical(lipgloss.Left, header, body))
+	}
+	if m.showSnippets {
+		return m.snippetsView()
+	}
+	if m.showPalette {
+		return m.paletteView()
+	}
+	if m.showTransforms {
+		return m.transformsView()
+	}
+
 	status := fmt.Sprintf(" Status: %s", m.connectedState)
 	if m.connectedState == Connecting {
 		status += " " + m.spinner.View()
@@ -425,6 +1977,19 @@ func (m Model) View() string {
 	if m.lastError != nil {
 		status = fmt.Sprintf(" Status: %s | %s", m.connectedState, errorStyle.Render(m.lastError.Error()))
 	}
+	if m.connectedState == Disconnected && !m.nextRetry.IsZero() {
+		limit := "∞"
+		if m.maxReconnects > 0 {
+			limit = fmt.Sprint(m.maxReconnects)
+		}
+		status += fmt.Sprintf(" — retry %d/%s in %s", m.reconnectAttempt, limit, time.Until(m.nextRetry).Round(time.Second))
+	}
+	if m.connectedState == Disconnected && m.gaveUp {
+		status += " — " + errorStyle.Render("Gave up, press ctrl+r to retry")
+	}
+	if q := m.connectionQuality(); q != "" {
+		status += " " + q
+	}
 	statusView := statusStyle.Width(m.width).Render(status)
 
 	// Sync Status
@@ -432,33 +1997,129 @@ func (m Model) View() string {
 	if m.syncEnabled {
 		syncText = "ON"
 	}
+	if m.pauseStep > 0 {
+		syncText = fmt.Sprintf("PAUSED %s", time.Until(m.pausedUntil).Round(time.Second))
+	}
+	if m.syncMode != SyncBoth {
+		syncText += " (" + m.syncMode.String() + ")"
+	}
 	syncView := syncStatusStyle.Render(fmt.Sprintf("Sync: %s", syncText))
+	if m.serverInfo != nil {
+		syncView += helpStyle.Render(" | server " + m.serverInfo.Version)
+	}
+	if m.serverStats != nil {
+		syncView += helpStyle.Render(fmt.Sprintf(" | %d clips, %s, %d devices",
+			m.serverStats.Clips, formatBytes(m.serverStats.Bytes), m.serverStats.Devices))
+	}
+	if m.connectedState == Connected {
+		compression := "off"
+		if m.compressionActive {
+			compression = "on"
+			if r := wireStats.Ratio(); r > 0 {
+				compression = fmt.Sprintf("%.1fx", r)
+			}
+		}
+		syncView += helpStyle.Render(" | compression: " + compression)
+	}
+	if m.pausedByLock {
+		syncView += syncStatusStyle.Render(" | LOCKED")
+	}
+	if m.appendMode {
+		syncView += syncStatusStyle.Render(" | APPEND")
+	}
+	if m.dnd {
+		dndText := " | DND"
+		if n := len(m.incomingOffers); n > 0 {
+			dndText += fmt.Sprintf(" (%d held)", n)
+		}
+		syncView += syncStatusStyle.Render(dndText)
+	}
+	if len(m.extraServers) > 0 {
+		syncView += helpStyle.Render(" | " + m.extraServersStatus())
+	}
+	if syncPrimary {
+		syncView += helpStyle.Render(" | +primary")
+	}
+	if len(m.channels) > 1 {
+		syncView += helpStyle.Render(" | channel: " + m.channel)
+	}
+	if m.lastSeq > 0 {
+		syncView += helpStyle.Render(fmt.Sprintf(" | seq %d", m.lastSeq))
+	}
 
 	// Combine Status and Sync
 	statusBar := lipgloss.JoinHorizontal(lipgloss.Top,
 		statusView, // Let status take available width
 		lipgloss.NewStyle().PaddingLeft(1).Render(syncView),
 	)
+	if !m.clipboardAvailable {
+		banner := errorStyle.Render(fmt.Sprintf(" Local clipboard unavailable (%v): receiving and displaying only", m.clipboardErr))
+		statusBar = lipgloss.JoinVertical(lipgloss.Left, banner, statusBar)
+	}
+	if m.motd != "" {
+		// One line, so the layout only has to make room for one
+		text := strings.Join(strings.Fields(m.motd), " ")
+		w, _ := docStyle.GetFrameSize()
+		banner := lipgloss.NewStyle().Foreground(special).MaxWidth(m.width - w).
+			Render(fmt.Sprintf(" Server: %s (%s to dismiss)", text, m.keys.DismissMOTD.Help().Key))
+		statusBar = lipgloss.JoinVertical(lipgloss.Left, banner, statusBar)
+	}
 
 	// Panes
 	histPane := getPaneStyle(m.focus == HistoryPane).Render(m.histList.View())
 	devPane := getPaneStyle(m.focus == DevicesPane).Render(m.deviceList.View())
 	logPane := getPaneStyle(m.focus == LogPane).Render(m.logView.View())
 
-	// Combine Panes Horizontally
-	panes := lipgloss.JoinHorizontal(lipgloss.Top, histPane, devPane, logPane)
+	// Combine Panes Horizontally, in PANE_ORDER
+	rendered := map[FocusablePane]string{HistoryPane: histPane, DevicesPane: devPane, LogPane: logPane}
+	ordered := make([]string, len(m.paneOrder))
+	for i, p := range m.paneOrder {
+		ordered[i] = rendered[p]
+	}
+	panes := lipgloss.JoinHorizontal(lipgloss.Top, ordered...)
 
 	// Help View
 	helpView := helpStyle.Render(m.help.View(m.keys))
-	if m.incomingFileOffer != nil {
+	if len(m.incomingOffers) > 0 && !m.dnd {
+		offerText := fmt.Sprintf("Offer: '%s' ", m.incomingOffers[0].Offer.Filename)
+		if n := len(m.incomingOffers); n > 1 {
+			offerText += fmt.Sprintf("(%d pending offers) ", n)
+		}
 	offerHelp := lipgloss.JoinHorizontal(lipgloss.Left,
 			// Correct way: Create style -> Set Color -> Render
-			lipgloss.NewStyle().Foreground(special).Render(fmt.Sprintf("Offer: '%s' ", m.incomingFileOffer.Filename)),
+			lipgloss.NewStyle().Foreground(special).Render(offerText),
 			m.keys.AcceptFile.Help().Key+" accept", " | ",
-			m.keys.RejectFile.Help().Key+" reject",
+			m.keys.RejectFile.Help().Key+" reject", " | ",
+			m.keys.AllowDevice.Help().Key+" always allow", " | ",
+			m.keys.BlockDevice.Help().Key+" block device",
 		)
 		helpView = lipgloss.JoinVertical(lipgloss.Left, offerHelp, helpView)
 	}
+	if m.pendingOverwrite != nil {
+		overwriteHelp := lipgloss.JoinHorizontal(lipgloss.Left,
+			lipgloss.NewStyle().Foreground(special).Render("Overwrite clipboard? "+m.overwriteSummary+" "),
+			m.keys.ConfirmOverwrite.Help().Key+" overwrite", " | ",
+			m.keys.SkipOverwrite.Help().Key+" keep mine",
+		)
+		helpView = lipgloss.JoinVertical(lipgloss.Left, overwriteHelp, helpView)
+	}
+	if m.savingOffer != nil {
+		title := lipgloss.NewStyle().Foreground(special).Render(fmt.Sprintf("Save '%s' (enter to accept, esc to decide later):", m.savingOffer.Offer.Filename))
+		if m.saveErr != nil {
+			title += " " + errorStyle.Render(m.saveErr.Error())
+		}
+		helpView = lipgloss.JoinVertical(lipgloss.Left, title, m.saveInput.View(), helpView)
+	}
+	if m.promptingPath {
+		prompt := lipgloss.JoinVertical(lipgloss.Left,
+			lipgloss.NewStyle().Foreground(special).Render(fmt.Sprintf("Send to %s (enter to offer, esc to cancel):", m.targetName(m.xferTargetID, m.xferGroup))),
+			m.pathInput.View(),
+		)
+		helpView = lipgloss.JoinVertical(lipgloss.Left, prompt, helpView)
+	}
+	if transfers := m.transfersView(); transfers != "" {
+		helpView = lipgloss.JoinVertical(lipgloss.Left, syncStatusStyle.Render(transfers), helpView)
+	}
 
 
 	// Final Layout
@@ -484,4 +2145,19 @@ func (m *Model) logf(format string, args ...interface{}) {
 	m.logView.SetContent(strings.Join(m.logMessages, "\n"))
 	m.logView.GotoBottom() // Scroll to bottom
 	log.Println(logEntry)  // Also log to file
+	headlessLog(logEntry)
+}
+
+// formatBytes renders a byte count in human units (e.g. 1.5 MB)
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
 }
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// contentKind is what a clip looks like, for actions that only make sense on some clips.
+type contentKind int
+
+const (
+	contentText contentKind = iota
+	contentURL              // A single http(s) URL, nothing else
+)
+
+// classifyContent sorts a clip by its content. Only http and https count as URLs,
+// so a synced clip can never get a file:// or custom scheme opened.
+func classifyContent(content string) contentKind {
+	s := strings.TrimSpace(content)
+	if s == "" || strings.ContainsAny(s, " \t\r\n") {
+		return contentText
+	}
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return contentText
+	}
+	return contentURL
+}
+
+// openableURL returns the URL OpenURL would open: the selected history entry when
+// the history pane has focus, otherwise the last received clip.
+func (m *Model) openableURL() (string, bool) {
+	content := m.lastRcvdClip
+	if m.focus == HistoryPane {
+		item, ok := m.histList.SelectedItem().(historyItem)
+		if !ok {
+			return "", false
+		}
+		content = item.Content
+	}
+	if classifyContent(content) != contentURL {
+		return "", false
+	}
+	return strings.TrimSpace(content), true
+}
+
+// openURLCmd opens a URL in the default browser.
+func openURLCmd(link string) tea.Cmd {
+	return func() tea.Msg {
+		var cmd *exec.Cmd
+		switch runtime.GOOS {
+		case "darwin":
+			cmd = exec.Command("open", link)
+		case "windows":
+			cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", link)
+		default:
+			cmd = exec.Command("xdg-open", link)
+		}
+		if err := cmd.Start(); err != nil {
+			return LogMsg(fmt.Sprintf("Error opening %s: %v", link, err))
+		}
+		go cmd.Wait() // Reap it; the browser outlives us anyway
+		return LogMsg("Opened " + link)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// pairingCmd asks /auth/token for a single-use token and builds the WebSocket
+// URL a new device can connect with, no API key needed.
+func pairingCmd(serverURL, apiKey string) tea.Cmd {
+	return func() tea.Msg {
+		u, err := url.Parse(serverURL)
+		if err != nil {
+			return PairingMsg{Err: fmt.Errorf("parsing url: %w", err)}
+		}
+		token, err := fetchSessionToken(u, apiKey)
+		if err != nil {
+			return PairingMsg{Err: fmt.Errorf("fetching session token: %w", err)}
+		}
+		q := u.Query()
+		q.Del("apiKey")
+		q.Set("token", token)
+		u.RawQuery = q.Encode()
+		return PairingMsg{URL: u.String()}
+	}
+}
+
+// openPairingModal shows the pairing URL as a QR code in the content modal; c copies the URL.
+func (m *Model) openPairingModal(pairURL string) {
+	code, err := encodeQR([]byte(pairURL))
+	if err != nil {
+		m.logf("Cannot show pairing QR code: %v", err)
+		return
+	}
+	m.contentHeader = " Pair a device | token works once, expires in minutes | c to copy URL, esc to close "
+	m.contentView.SetContent(qrStyle.Render(code.render(4)) + "\n\n" + sanitizeForDisplay(pairURL))
+	m.contentView.GotoTop()
+	m.contentClip = pairURL
+	m.showContent = true
+}
+
+// The server gives a device that connected with a session token a credential in
+// its welcome. Pairing tokens only work once, so a paired device, which has no API
+// key, keeps the credential in the config dir and presents it on every connect.
+
+func deviceCredentialPath() (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "device-credential"), nil
+}
+
+// loadDeviceCredential returns the saved credential, or "" if there is none.
+func loadDeviceCredential() string {
+	path, err := deviceCredentialPath()
+	if err != nil {
+		return ""
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+func saveDeviceCredential(credential string) {
+	path, err := deviceCredentialPath()
+	if err == nil {
+		err = os.WriteFile(path, []byte(credential+"\n"), 0600)
+	}
+	if err != nil {
+		log.Printf("Warning: could not save device credential: %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// paletteItem is one action in the command palette, straight from keyMap.
+type paletteItem struct {
+	binding key.Binding
+}
+
+func (p paletteItem) FilterValue() string { return p.binding.Help().Desc + " " + p.binding.Help().Key }
+func (p paletteItem) Title() string       { return p.binding.Help().Desc }
+func (p paletteItem) Description() string { return p.binding.Help().Key }
+
+// keyTypes maps key names as bindings spell them ("ctrl+x", "tab") back to
+// tea.KeyTypes, so the palette can replay a binding as a key press.
+var keyTypes = func() map[string]tea.KeyType {
+	types := make(map[string]tea.KeyType)
+	for t := tea.KeyType(-256); t < 256; t++ { // Covers the control codes and bubbletea's named keys
+		if name := t.String(); name != "" && t != tea.KeyRunes {
+			types[name] = t
+		}
+	}
+	return types
+}()
+
+// keyMsgFor turns a binding's key name into the KeyMsg that pressing it sends.
+func keyMsgFor(name string) (tea.KeyMsg, bool) {
+	if t, ok := keyTypes[name]; ok {
+		return tea.KeyMsg{Type: t}, true
+	}
+	if r := []rune(name); len(r) == 1 {
+		return tea.KeyMsg{Type: tea.KeyRunes, Runes: r}, true
+	}
+	return tea.KeyMsg{}, false
+}
+
+// openPalette lists every enabled binding in FullHelp, so new bindings show up
+// without touching the palette, and starts filtering right away.
+func (m *Model) openPalette() tea.Cmd {
+	var items []list.Item
+	for _, row := range m.keys.FullHelp() {
+		for _, b := range row {
+			if b.Enabled() && len(b.Keys()) > 0 && b.Help() != m.keys.Palette.Help() {
+				items = append(items, paletteItem{binding: b})
+			}
+		}
+	}
+	m.showPalette = true
+	m.paletteList.ResetFilter()
+	cmd := m.paletteList.SetItems(items)
+	m.paletteList.Select(0)
+	var filter tea.Cmd
+	m.paletteList, filter = m.paletteList.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'/'}})
+	return tea.Batch(cmd, filter)
+}
+
+// updatePalette handles keys while the palette is open. When an action is picked
+// it returns the key press to replay, so it runs exactly as if typed.
+func (m *Model) updatePalette(msg tea.KeyMsg) (run *tea.KeyMsg, cmd tea.Cmd) {
+	switch {
+	case key.Matches(msg, m.keys.CloseModal):
+		m.showPalette = false
+	case msg.Type == tea.KeyEnter:
+		item, ok := m.paletteList.SelectedItem().(paletteItem)
+		if !ok {
+			return nil, nil
+		}
+		m.showPalette = false
+		press, ok := keyMsgFor(item.binding.Keys()[0])
+		if !ok {
+			m.logf("Can't run '%s' from the palette; press %s instead.", item.binding.Help().Desc, item.binding.Help().Key)
+			return nil, nil
+		}
+		return &press, nil
+	default:
+		m.paletteList, cmd = m.paletteList.Update(msg)
+	}
+	return nil, cmd
+}
+
+// paletteView renders the command palette.
+func (m Model) paletteView() string {
+	footer := helpStyle.Render(fmt.Sprintf("type to filter • enter run • %s close", m.keys.CloseModal.Help().Key))
+	body := focusedPaneStyle.Render(m.paletteList.View())
+	return docStyle.Render(lipgloss.JoinVertical(lipgloss.Left, body, footer))
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// syncPrimary turns on syncing the Linux primary selection (SYNC_PRIMARY), set in
+// main. Selections go out as primary_update, which the server only relays to
+// devices that asked for them, and never touch the clipboard or history.
+var syncPrimary bool
+
+// checkPrimaryCmd reads the primary selection and reports whether it changed.
+func checkPrimaryCmd(last string) tea.Cmd {
+	return func() tea.Msg {
+		content, err := readPrimary()
+		if err != nil {
+			return PrimaryCheckedMsg{Err: err}
+		}
+		return PrimaryCheckedMsg{Content: content, Changed: content != last}
+	}
+}
+
+func writePrimaryCmd(content string) tea.Cmd {
+	return func() tea.Msg {
+		if err := writePrimary(content); err != nil {
+			return LogMsg(fmt.Sprintf("Error writing primary selection: %v", err))
+		}
+		return nil
+	}
+}
@@ -0,0 +1,55 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const primarySupported = true
+
+// primaryTools are tried in order: wl-clipboard under Wayland, then xclip and xsel.
+// Each entry is the read command and the write command (which takes stdin).
+func primaryTools() [][2][]string {
+	tools := [][2][]string{
+		{{"xclip", "-o", "-selection", "primary"}, {"xclip", "-i", "-selection", "primary"}},
+		{{"xsel", "--primary", "--output"}, {"xsel", "--primary", "--input"}},
+	}
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		wl := [2][]string{{"wl-paste", "--primary", "--no-newline"}, {"wl-copy", "--primary"}}
+		tools = append([][2][]string{wl}, tools...)
+	}
+	return tools
+}
+
+// primaryTool returns the first available read/write pair.
+func primaryTool() ([2][]string, error) {
+	for _, t := range primaryTools() {
+		if _, err := exec.LookPath(t[0][0]); err == nil {
+			return t, nil
+		}
+	}
+	return [2][]string{}, errors.New("no primary selection tool found (wl-clipboard, xclip or xsel)")
+}
+
+func readPrimary() (string, error) {
+	t, err := primaryTool()
+	if err != nil {
+		return "", err
+	}
+	out, err := exec.Command(t[0][0], t[0][1:]...).Output()
+	return string(out), err
+}
+
+func writePrimary(content string) error {
+	t, err := primaryTool()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(t[1][0], t[1][1:]...)
+	cmd.Stdin = strings.NewReader(content)
+	return cmd.Run()
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// Only X11 and Wayland have a primary selection
+const primarySupported = false
+
+var errNoPrimary = errors.New("the primary selection only exists on Linux")
+
+func readPrimary() (string, error) { return "", errNoPrimary }
+
+func writePrimary(string) error { return errNoPrimary }
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// qrCode is the module grid of an encoded symbol, without its quiet zone; true is dark.
+type qrCode struct {
+	size    int
+	modules [][]bool
+}
+
+// encodeQR encodes data in the smallest symbol that fits at error correction
+// level L, which is plenty for a server URL with a session token.
+func encodeQR(data []byte) (*qrCode, error) {
+	q, err := qrcode.New(string(data), qrcode.Low)
+	if err != nil {
+		return nil, err
+	}
+	q.DisableBorder = true // render draws its own quiet zone
+	modules := q.Bitmap()
+	return &qrCode{size: len(modules), modules: modules}, nil
+}
+
+// render draws the symbol with half-block characters, two module rows per line,
+// inside a quiet zone. Light modules are drawn as foreground, so the result needs
+// a light-on-dark style to keep dark modules dark for the scanner.
+func (q *qrCode) render(quiet int) string {
+	n := q.size + 2*quiet
+	dark := func(x, y int) bool {
+		x, y = x-quiet, y-quiet
+		return x >= 0 && y >= 0 && x < q.size && y < q.size && q.modules[y][x]
+	}
+	var b strings.Builder
+	for y := 0; y < n; y += 2 {
+		for x := 0; x < n; x++ {
+			top, bottom := !dark(x, y), y+1 < n && !dark(x, y+1)
+			switch {
+			case top && bottom:
+				b.WriteRune('█')
+			case top:
+				b.WriteRune('▀')
+			case bottom:
+				b.WriteRune('▄')
+			default:
+				b.WriteRune(' ')
+			}
+		}
+		if y+2 < n {
+			b.WriteByte('\n')
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEncodeQR(t *testing.T) {
+	code, err := encodeQR([]byte("wss://clipd.example:8080/ws?token=0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if (code.size-17)%4 != 0 || code.size < 21 {
+		t.Fatalf("size %d isn't a QR symbol size", code.size)
+	}
+	// Finder patterns: dark 7x7 ring with a dark 3x3 centre, in three corners
+	for _, corner := range [][2]int{{0, 0}, {code.size - 7, 0}, {0, code.size - 7}} {
+		x0, y0 := corner[0], corner[1]
+		for y := 0; y < 7; y++ {
+			for x := 0; x < 7; x++ {
+				ring := x == 0 || y == 0 || x == 6 || y == 6
+				centre := x >= 2 && x <= 4 && y >= 2 && y <= 4
+				if got := code.modules[y0+y][x0+x]; got != (ring || centre) {
+					t.Fatalf("finder at %v: module (%d,%d) = %v", corner, x, y, got)
+				}
+			}
+		}
+	}
+
+	lines := strings.Split(code.render(4), "\n")
+	if want := (code.size + 8 + 1) / 2; len(lines) != want {
+		t.Errorf("render gave %d lines, want %d", len(lines), want)
+	}
+}
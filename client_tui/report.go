@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// maxConnEvents caps the connection history kept for log exports.
+const maxConnEvents = 50
+
+// recordConnEvent notes a connection state change for exportLog.
+func (m *Model) recordConnEvent(status ConnectionState, err error) {
+	event := time.Now().Format("2006-01-02 15:04:05 ") + status.String()
+	if err != nil {
+		event += ": " + err.Error()
+	}
+	m.connEvents = append(m.connEvents, event)
+	e := headlessEvent{Type: "connection", State: status.String()}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	emitEvent(e)
+	if len(m.connEvents) > maxConnEvents {
+		m.connEvents = m.connEvents[len(m.connEvents)-maxConnEvents:]
+	}
+}
+
+// bugReport renders the log pane plus enough context to make sense of it. The
+// API key and any clip content we know of are redacted, so the file can be
+// attached to an issue as is.
+func (m *Model) bugReport() string {
+	version := "unknown"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		version = info.Main.Version
+	}
+	server := m.serverURL
+	if u, err := url.Parse(m.serverURL); err == nil {
+		u.User, u.RawQuery = nil, "" // Keys and tokens only ever travel in these
+		server = u.String()
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "clipd TUI log export, %s\n\n", time.Now().Format(time.RFC3339))
+	fmt.Fprintf(&b, "Version:    %s (%s)\n", version, runtime.Version())
+	fmt.Fprintf(&b, "Platform:   %s/%s\n", runtime.GOOS, runtime.GOARCH)
+	fmt.Fprintf(&b, "Server:     %s\n", server)
+	fmt.Fprintf(&b, "State:      %s, sync %v (%s), channel %q\n", m.connectedState, m.syncEnabled, m.syncMode, m.channel)
+	fmt.Fprintf(&b, "Clipboard:  available %v\n", m.clipboardAvailable)
+	fmt.Fprintf(&b, "Uptime:     %s\n", time.Since(m.sessionStart).Round(time.Second))
+	b.WriteString("\nConnection history:\n")
+	for _, e := range m.connEvents {
+		b.WriteString("  " + e + "\n")
+	}
+	b.WriteString("\nLog:\n")
+	for _, l := range m.logMessages {
+		b.WriteString(l + "\n")
+	}
+	return m.redact(b.String())
+}
+
+// redact strips the API key and every clip the TUI holds from s.
+func (m *Model) redact(s string) string {
+	secrets := []string{m.lastLocalClip, m.lastRcvdClip, m.lastSentClip, m.lastAppendedClip, m.lastPrimary, m.lastRcvdPrimary}
+	for _, it := range m.historyItems() {
+		if h, ok := it.(historyItem); ok {
+			secrets = append(secrets, h.Content)
+		}
+	}
+	for _, it := range m.snippetList.Items() {
+		if sn, ok := it.(snippetItem); ok {
+			secrets = append(secrets, sn.Content)
+		}
+	}
+	if m.apiKey != "" {
+		s = strings.ReplaceAll(s, m.apiKey, "[api key redacted]")
+	}
+	// Longest first, so a clip containing a shorter one isn't left half redacted
+	sort.Slice(secrets, func(i, j int) bool { return len(secrets[i]) > len(secrets[j]) })
+	for _, c := range secrets {
+		if len(strings.TrimSpace(c)) >= 4 { // Shorter would mangle ordinary words
+			s = strings.ReplaceAll(s, c, "[clip redacted]")
+		}
+	}
+	return s
+}
+
+// exportLogCmd writes a report to a timestamped file in the config dir.
+func exportLogCmd(report string) tea.Cmd {
+	return func() tea.Msg {
+		dir, err := configDir()
+		if err != nil {
+			return LogMsg(fmt.Sprintf("Error exporting log: %v", err))
+		}
+		path := filepath.Join(dir, "clipd-log-"+time.Now().Format("20060102-150405")+".txt")
+		if err := os.WriteFile(path, []byte(report), 0600); err != nil {
+			return LogMsg(fmt.Sprintf("Error exporting log: %v", err))
+		}
+		return LogMsg("Log exported to " + path)
+	}
+}
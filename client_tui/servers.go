@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/gorilla/websocket"
+)
+
+// extraServer is a connection to one of EXTRA_SERVERS, e.g. a work server next
+// to the home one. It is deliberately simpler than the main connection: its clips
+// and devices are shown, labelled, and local copies are sent to it, but history
+// paging, file transfers, acks and channels stay with the main server.
+type extraServer struct {
+	ServerConfig
+	conn    *websocket.Conn
+	state   ConnectionState
+	selfID  string
+	devices []ClientInfo
+	attempt int  // Reconnect attempts since it was last connected
+	gaveUp  bool // MAX_RECONNECT_ATTEMPTS ran out, as for the main server; RetryNow dials again
+	gen     int  // Bumped to drop stale ExtraReconnectMsgs
+}
+
+func newExtraServers(cfgs []ServerConfig) []*extraServer {
+	servers := make([]*extraServer, 0, len(cfgs))
+	for _, c := range cfgs {
+		servers = append(servers, &extraServer{ServerConfig: c, state: Disconnected})
+	}
+	return servers
+}
+
+// extraConnectCmd dials extra server i (1-based, as in ConnectionStatusMsg.Server).
+func (m *Model) extraConnectCmd(i int) tea.Cmd {
+	s := m.extraServers[i-1]
+	s.state = Connecting
+	connect := connectCmd(s.URL, s.APIKey, "", m.hostname, m.deviceID, "", "", 0)
+	return func() tea.Msg {
+		msg, ok := connect().(ConnectionStatusMsg)
+		if !ok { // A bad URL comes back as an ErrorMsg
+			msg = ConnectionStatusMsg{Status: Disconnected, Err: fmt.Errorf("connecting to %s failed", s.Label)}
+		}
+		msg.Server = i
+		return msg
+	}
+}
+
+// connectExtraServers dials every extra server; called once from Init.
+func (m *Model) connectExtraServers() tea.Cmd {
+	var cmds []tea.Cmd
+	for i := range m.extraServers {
+		cmds = append(cmds, m.extraConnectCmd(i+1))
+	}
+	return tea.Batch(cmds...)
+}
+
+// handleExtraStatus is ConnectionStatusMsg for an extra server.
+func (m *Model) handleExtraStatus(msg ConnectionStatusMsg) tea.Cmd {
+	s := m.extraServers[msg.Server-1]
+	s.state = msg.Status
+	s.gen++
+	if msg.Status == Connected && msg.Conn != nil {
+		s.conn, s.attempt = msg.Conn, 0
+		m.logf("Connected to %s.", s.Label)
+		return tea.Batch(
+			listenWebSocketCmd(context.Background(), s.conn, m.programRef, msg.Server),
+			sendWebsocketMessageCmd(s.conn, BaseMessage{Type: "request_devices"}),
+		)
+	}
+
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	s.conn, s.selfID, s.devices = nil, "", nil
+	m.refreshDeviceList()
+	if msg.Err != nil {
+		m.logf("%s: connection error: %v", s.Label, msg.Err)
+	}
+	if m.quitting {
+		return nil
+	}
+	if m.maxReconnects > 0 && s.attempt >= m.maxReconnects {
+		s.gaveUp = true
+		m.logf("%s: gave up after %d reconnect attempts; press ctrl+r to retry.", s.Label, s.attempt)
+		return nil
+	}
+	s.attempt++
+	server, gen := msg.Server, s.gen
+	return tea.Tick(reconnectDelay(s.attempt), func(time.Time) tea.Msg { return ExtraReconnectMsg{Server: server, Gen: gen} })
+}
+
+// retryExtraServers redials the extra servers that ran out of reconnect attempts,
+// with a fresh round of them.
+func (m *Model) retryExtraServers() tea.Cmd {
+	var cmds []tea.Cmd
+	for i, s := range m.extraServers {
+		if s.gaveUp {
+			s.gaveUp, s.attempt = false, 0
+			m.logf("Reconnecting to %s now...", s.Label)
+			cmds = append(cmds, m.extraConnectCmd(i+1))
+		}
+	}
+	return tea.Batch(cmds...)
+}
+
+// handleExtraMessage is ReceivedServerMsg for an extra server. Only what the
+// panes show is handled; everything else belongs to the main connection.
+func (m *Model) handleExtraMessage(server int, msg BaseMessage) tea.Cmd {
+	s := m.extraServers[server-1]
+	switch msg.Type {
+	case "welcome":
+		var data WelcomeData
+		if err := RemarshalData(msg.Data, &data); err == nil {
+			s.selfID = data.ID
+			m.refreshDeviceList()
+		}
+
+	case "device_list":
+		var data DeviceListData
+		if err := RemarshalData(msg.Data, &data); err != nil {
+			m.logf("%s: error decoding device_list: %v", s.Label, err)
+			break
+		}
+		for i := range data.Devices {
+			data.Devices[i].Server = s.Label
+		}
+		s.devices = data.Devices
+		m.refreshDeviceList()
+
+	case "auth_challenge":
+		m.logf("%s: server API key changed; re-authenticating.", s.Label)
+		return sendWebsocketMessageCmd(s.conn, BaseMessage{Type: "auth_response", Data: AuthResponseData{APIKey: s.APIKey}})
+
+	case "clipboard_update":
+		if msg.SenderID != "" && msg.SenderID == s.selfID {
+			break
+		}
+		var data ClipboardUpdateData
+		err := RemarshalData(msg.Data, &data)
+		var content string
+		if err == nil {
+			content, err = data.Text()
+		}
+		if err != nil {
+			m.logf("%s: error decoding clipboard_update: %v", s.Label, err)
+			break
+		}
+		// Seqs are per server, so they'd only confuse the main server's ordering
+		item := historyItem{Content: content, Origin: s.Label}
+		if m.showClipOrigin {
+			item.Origin += ": " + s.hostname(msg.SenderID)
+		}
+		if data.Initial {
+			m.addHistoryEntry(item) // Listed only, as SEED_ON_CONNECT applies to the main server
+			break
+		}
+		m.logf("Clip from %s", s.Label)
+		return m.applyRemoteClip(item)
+	}
+	return nil
+}
+
+// hostname names a device on this server for clip origins.
+func (s *extraServer) hostname(id string) string {
+	for _, d := range s.devices {
+		if d.ID == id {
+			return d.Hostname
+		}
+	}
+	if id == "" {
+		return "server"
+	}
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
+
+// sendToExtraServers sends a local clip to every connected extra server.
+func (m *Model) sendToExtraServers(content string) []tea.Cmd {
+	var cmds []tea.Cmd
+	for _, s := range m.extraServers {
+		if s.conn != nil && s.state == Connected {
+			cmds = append(cmds, sendWebsocketMessageCmd(s.conn, BaseMessage{Type: "clipboard_update", Data: newClipboardUpdateData(content)}))
+		}
+	}
+	return cmds
+}
+
+// extraServersStatus summarises the extra connections for the status bar.
+func (m *Model) extraServersStatus() string {
+	parts := make([]string, 0, len(m.extraServers))
+	for _, s := range m.extraServers {
+		if s.gaveUp {
+			parts = append(parts, s.Label+": gave up")
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%s: %s", s.Label, s.state))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestExtraServerStopsAtReconnectLimit(t *testing.T) {
+	m := newTestModel(t, func(cfg *Config) {
+		cfg.MaxReconnects = 2
+		cfg.ExtraServers = []ServerConfig{{Label: "work", URL: "ws://work.invalid/ws"}}
+	})
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		if cmd := m.handleExtraStatus(ConnectionStatusMsg{Status: Disconnected, Server: 1}); cmd == nil {
+			t.Fatalf("attempt %d: no retry scheduled", attempt)
+		}
+	}
+	if cmd := m.handleExtraStatus(ConnectionStatusMsg{Status: Disconnected, Server: 1}); cmd != nil {
+		t.Error("retried past MAX_RECONNECT_ATTEMPTS")
+	}
+	if s := m.extraServers[0]; !s.gaveUp {
+		t.Error("extra server not marked as given up")
+	}
+	if m.retryExtraServers() == nil || m.extraServers[0].gaveUp || m.extraServers[0].attempt != 0 {
+		t.Error("RetryNow didn't start a fresh round for the extra server")
+	}
+}
+
+func TestReconnectDelay(t *testing.T) {
+	for attempt, want := range map[int]string{1: "1s", 2: "2s", 5: "16s", 6: "30s", 40: "30s"} {
+		if got := reconnectDelay(attempt).String(); got != want {
+			t.Errorf("attempt %d: got %s, want %s", attempt, got, want)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// snippetItem is a named, persistent clip. Unlike history it never rolls over
+// and never goes through the server.
+type snippetItem struct {
+	Name    string `json:"name"`
+	Content string `json:"content"`
+}
+
+func (s snippetItem) FilterValue() string { return s.Name }
+func (s snippetItem) Title() string       { return s.Name }
+func (s snippetItem) Description() string {
+	return strings.ReplaceAll(sanitizeForDisplay(snippet(s.Content, 60)), "\n", "↵")
+}
+
+// loadSnippets reads the snippets file; a missing file is an empty library.
+func loadSnippets(path string) ([]list.Item, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return []list.Item{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if b, err = openLine(b); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	var snippets []snippetItem
+	if err := json.Unmarshal(b, &snippets); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	items := make([]list.Item, len(snippets))
+	for i, s := range snippets {
+		items[i] = s
+	}
+	return items, nil
+}
+
+// saveSnippets writes the library atomically so a crash can't truncate it.
+func saveSnippets(path string, items []list.Item) error {
+	snippets := make([]snippetItem, 0, len(items))
+	for _, it := range items {
+		if s, ok := it.(snippetItem); ok {
+			snippets = append(snippets, s)
+		}
+	}
+	b, err := json.MarshalIndent(snippets, "", "  ")
+	if err != nil {
+		return err
+	}
+	if sealWrites {
+		if b, err = sealLine(b); err != nil {
+			return err
+		}
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (m *Model) persistSnippets() {
+	if m.snippetsErr != nil {
+		m.logf("Not saving snippets; %s couldn't be read and would be overwritten: %v", m.snippetsFile, m.snippetsErr)
+		return
+	}
+	if err := saveSnippets(m.snippetsFile, m.snippetList.Items()); err != nil {
+		m.logf("Error saving snippets: %v", err)
+	}
+}
+
+// updateSnippets handles keys while the snippets modal is open.
+func (m *Model) updateSnippets(msg tea.KeyMsg) tea.Cmd {
+	var cmd tea.Cmd
+
+	// Naming prompt for a new or renamed snippet
+	if m.namingSnippet {
+		switch {
+		case key.Matches(msg, m.keys.CloseModal):
+			m.namingSnippet = false
+		case msg.Type == tea.KeyEnter:
+			name := strings.TrimSpace(m.snippetInput.Value())
+			if name == "" {
+				return nil // Keep the prompt open
+			}
+			m.namingSnippet = false
+			if m.renameIndex >= 0 {
+				s := m.snippetList.Items()[m.renameIndex].(snippetItem)
+				s.Name = name
+				cmd = m.snippetList.SetItem(m.renameIndex, s)
+				m.logf("Renamed snippet to '%s'", name)
+			} else {
+				cmd = m.snippetList.InsertItem(len(m.snippetList.Items()), snippetItem{Name: name, Content: m.lastLocalClip})
+				m.logf("Saved clipboard as snippet '%s'", name)
+			}
+			m.persistSnippets()
+		default:
+			m.snippetInput, cmd = m.snippetInput.Update(msg)
+		}
+		return cmd
+	}
+
+	if m.snippetList.FilterState() == list.Filtering {
+		m.snippetList, cmd = m.snippetList.Update(msg)
+		return cmd
+	}
+
+	selected, hasSelection := m.snippetList.SelectedItem().(snippetItem)
+	switch {
+	case key.Matches(msg, m.keys.CloseModal):
+		m.showSnippets = false
+	case key.Matches(msg, m.keys.AddSnippet):
+		if m.lastLocalClip == "" {
+			m.logf("Clipboard is empty, nothing to save as a snippet.")
+			return nil
+		}
+		return m.startNamingSnippet(-1, "")
+	case key.Matches(msg, m.keys.RenameSnippet) && hasSelection:
+		return m.startNamingSnippet(m.selectedSnippetIndex(), selected.Name)
+	case key.Matches(msg, m.keys.DeleteEntry) && hasSelection:
+		m.snippetList.RemoveItem(m.selectedSnippetIndex())
+		m.persistSnippets()
+		m.logf("Deleted snippet '%s'", selected.Name)
+	case key.Matches(msg, m.keys.ViewEntry) && hasSelection && !m.clipboardAvailable:
+		m.logf("Local clipboard unavailable, cannot copy snippet.")
+	case key.Matches(msg, m.keys.ViewEntry) && hasSelection:
+		// The clipboard poller picks this up and syncs it like any local copy
+		m.showSnippets = false
+		m.logf("Copied snippet '%s' to clipboard", selected.Name)
+		cmd = writeToClipboardCmd(selected.Content)
+	default:
+		m.snippetList, cmd = m.snippetList.Update(msg)
+	}
+	return cmd
+}
+
+// selectedSnippetIndex returns the selected snippet's index among all items, which
+// SetItem and RemoveItem take. Index() is into the filtered view, so it differs
+// while a filter is applied.
+func (m *Model) selectedSnippetIndex() int {
+	selected := m.snippetList.SelectedItem()
+	for i, it := range m.snippetList.Items() {
+		if it == selected {
+			return i
+		}
+	}
+	return -1
+}
+
+// startNamingSnippet opens the name prompt; index is the snippet to rename, or -1 to add.
+func (m *Model) startNamingSnippet(index int, name string) tea.Cmd {
+	m.renameIndex = index
+	m.namingSnippet = true
+	m.snippetInput.SetValue(name)
+	m.snippetInput.CursorEnd()
+	return m.snippetInput.Focus()
+}
+
+// snippetsView renders the snippets modal.
+func (m Model) snippetsView() string {
+	footer := helpStyle.Render(fmt.Sprintf("%s copy • %s add clipboard • %s rename • %s delete • %s close",
+		m.keys.ViewEntry.Help().Key, m.keys.AddSnippet.Help().Key, m.keys.RenameSnippet.Help().Key,
+		m.keys.DeleteEntry.Help().Key, m.keys.CloseModal.Help().Key))
+	if m.namingSnippet {
+		footer = lipgloss.JoinVertical(lipgloss.Left,
+			lipgloss.NewStyle().Foreground(special).Render("Snippet name (enter to save, esc to cancel):"),
+			m.snippetInput.View(),
+		)
+	}
+	body := focusedPaneStyle.Render(m.snippetList.View())
+	return docStyle.Render(lipgloss.JoinVertical(lipgloss.Left, body, footer))
+}
@@ -1,8 +1,13 @@
 package main
 
 import (
+	"encoding/base64"
 	"fmt"
-//	"time"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/charmbracelet/bubbles/key"
 //	"github.com/charmbracelet/bubbles/list"
@@ -23,12 +28,51 @@ func (s ConnectionState) String() string {
 	return [...]string{"Connecting", "Connected", "Disconnected"}[s]
 }
 
+// SyncMode limits which way clips flow, on top of the sync on/off toggle:
+// a "source" machine can push only and its "sinks" pull only.
+type SyncMode int
+
+const (
+	SyncBoth SyncMode = iota
+	SyncPush          // Send local changes, ignore incoming clips
+	SyncPull          // Apply incoming clips, never send
+	SyncOff
+)
+
+var syncModeNames = [...]string{"both", "push", "pull", "off"}
+
+func (s SyncMode) String() string { return syncModeNames[s] }
+
+// parseSyncMode reads a SYNC_MODE value; ok is false for unknown names.
+func parseSyncMode(name string) (mode SyncMode, ok bool) {
+	for i, n := range syncModeNames {
+		if strings.EqualFold(name, n) {
+			return SyncMode(i), true
+		}
+	}
+	return SyncBoth, false
+}
+
 // --- Server Message Structs (mirrored for client use) ---
 // These should match the structs used by the server
 
 type ClientInfo struct {
-	ID       string `json:"id"`
-	Hostname string `json:"hostname"`
+	ID          string `json:"id"`
+	DeviceID    string `json:"deviceId,omitempty"`
+	Platform    string `json:"platform,omitempty"` // The device's GOOS, sent on connect
+	Hostname    string `json:"hostname"`
+	DisplayName string `json:"displayName,omitempty"` // Set by the server operator; shown instead of Hostname
+	SyncEnabled bool   `json:"syncEnabled"` // Set by the server operator per device
+	Groups      []string `json:"groups,omitempty"` // Device groups it joined, see groupItem
+	Server      string `json:"-"`           // Label of the EXTRA_SERVERS entry it's on, "" for the main server
+}
+
+// Name is how the device is shown: its display name if the server has one for it.
+func (c ClientInfo) Name() string {
+	if c.DisplayName != "" {
+		return c.DisplayName
+	}
+	return c.Hostname
 }
 
 type BaseMessage struct {
@@ -38,27 +82,206 @@ type BaseMessage struct {
 }
 
 type ClipboardUpdateData struct {
-	Content string `json:"content"`
+	Content  string `json:"content"`
+	Encoding string `json:"encoding,omitempty"` // "" for UTF-8 text, clipEncodingBase64 otherwise
+	Channel  string `json:"channel,omitempty"`  // Set by the server; our sends go to the channel we've joined
+	Resend   bool   `json:"resend,omitempty"`   // Ask the server to broadcast even an unchanged clip
+	Initial  bool   `json:"initial,omitempty"`  // The server's current clip, sent on connect and channel switch
+	Seq      int64  `json:"seq,omitempty"`      // Server-assigned order of accepted clips
+	TargetGroup string `json:"targetGroup,omitempty"` // Pushed to one device group; not kept in history
+	TTLSeconds  int    `json:"ttlSeconds,omitempty"`  // Every device drops it from history this long after the server accepts it
+}
+
+const clipEncodingBase64 = "base64"
+
+// newClipboardUpdateData wraps clipboard content for the wire. JSON strings must be
+// valid UTF-8, so anything else is base64-encoded rather than silently mangled.
+func newClipboardUpdateData(content string) ClipboardUpdateData {
+	if utf8.ValidString(content) {
+		if sendLF {
+			content = normalizeLineEndings(content, "\n")
+		}
+		return ClipboardUpdateData{Content: content}
+	}
+	return ClipboardUpdateData{
+		Content:  base64.StdEncoding.EncodeToString([]byte(content)),
+		Encoding: clipEncodingBase64,
+	}
+}
+
+// Text returns the raw clipboard content, decoding it if needed.
+func (d ClipboardUpdateData) Text() (string, error) {
+	switch d.Encoding {
+	case "":
+		return d.Content, nil
+	case clipEncodingBase64:
+		b, err := base64.StdEncoding.DecodeString(d.Content)
+		if err != nil {
+			return "", fmt.Errorf("decoding base64 clip: %w", err)
+		}
+		return string(b), nil
+	default:
+		return "", fmt.Errorf("unknown clip encoding %q", d.Encoding)
+	}
 }
 
+// ClipboardHistoryData is one page of the server's history, newest first
 type ClipboardHistoryData struct {
-	History []string `json:"history"`
+	Channel string             `json:"channel,omitempty"`
+	History []HistoryEntryData `json:"history"`
+	Offset  int                `json:"offset"`
+	Before  int64              `json:"before,omitempty"` // Reply to a request for entries older than this seq
+	Total   int                `json:"total"`
+	Removed string             `json:"removed,omitempty"` // Entry another client deleted; drop our local copy too. Comes without a page
+	RemovedEncoding string     `json:"removedEncoding,omitempty"` // Encoding of Removed
+	Cleared bool               `json:"cleared,omitempty"` // Someone sent clear_history; drop the whole list
+	Since   int64              `json:"since,omitempty"`   // Reply to a resumed connect: only entries newer than this seq
+	Expired bool               `json:"expired,omitempty"` // Removed was a clip sent with a TTL that ran out
+}
+
+// ChannelData is the payload of set_channel
+type ChannelData struct {
+	Channel string `json:"channel"`
+}
+
+type SearchHistoryData struct {
+	Query string `json:"query"`
+}
+
+// SearchResultsData is the server's answer to search_history, newest first
+type SearchResultsData struct {
+	Query     string             `json:"query"`
+	Results   []HistoryEntryData `json:"results"`
+	Truncated bool               `json:"truncated,omitempty"`
+}
+
+type HistoryRequestData struct {
+	Offset int   `json:"offset"`
+	Before int64 `json:"before,omitempty"` // Seq of our oldest entry; servers that know it page from there instead of Offset
+	Limit  int   `json:"limit"`
+}
+
+type HistoryEntryData struct {
+	Content  string `json:"content"`
+	Encoding string `json:"encoding,omitempty"` // As in ClipboardUpdateData
+	Seq      int64  `json:"seq,omitempty"`
+}
+
+// newHistoryEntryData is the wire form of a listed entry, encoded the way the
+// server stored it, for delete_history_entry.
+func newHistoryEntryData(content string) HistoryEntryData {
+	d := newClipboardUpdateData(content)
+	return HistoryEntryData{Content: d.Content, Encoding: d.Encoding}
+}
+
+// decodeHistory decodes server history entries in place, dropping any that can't
+// be decoded, and returns what's left along with how many were dropped.
+func decodeHistory(history []HistoryEntryData) ([]HistoryEntryData, int) {
+	kept := history[:0]
+	for _, h := range history {
+		text, err := ClipboardUpdateData{Content: h.Content, Encoding: h.Encoding}.Text()
+		if err != nil {
+			continue
+		}
+		kept = append(kept, HistoryEntryData{Content: text, Seq: h.Seq})
+	}
+	return kept, len(history) - len(kept)
 }
 
 type DeviceListData struct {
 	Devices []ClientInfo `json:"devices"`
 }
 
+// ClipAckData numbers a clip we sent; Digest is clipDigest of the content as sent
+type ClipAckData struct {
+	Digest string `json:"digest"`
+	Seq    int64  `json:"seq"`
+}
+
+// RestartData is the payload of server_restarting; DowntimeSeconds is optional
+type RestartData struct {
+	DowntimeSeconds int `json:"downtimeSeconds,omitempty"`
+}
+
+// WelcomeData is sent once by the server right after connect
+type WelcomeData struct {
+	ID         string `json:"id"`                   // Our server-assigned client ID
+	Credential string `json:"credential,omitempty"` // Sent after connecting with a session token; see loadDeviceCredential
+	Epoch      string `json:"epoch,omitempty"`      // Changes when the server restarts; resume seqs are only valid within one
+}
+
+// AuthChallengeData is sent when the server's API key was rotated; connections
+// that don't answer with the new key in time are dropped.
+type AuthChallengeData struct {
+	DeadlineSeconds int `json:"deadlineSeconds"`
+}
+
+// AuthResponseData answers an auth_challenge.
+type AuthResponseData struct {
+	APIKey string `json:"apiKey"`
+}
+
+// ServerInfoData answers server_info: what the server runs and supports.
+type ServerInfoData struct {
+	Version      string   `json:"version"`
+	MessageTypes []string `json:"messageTypes"`
+	MaxClipBytes int      `json:"maxClipBytes"` // 0 means no limit
+	HistorySize  int      `json:"historySize"`
+	History      bool     `json:"history"`
+	Compression  bool     `json:"compression"`
+	Channels     bool     `json:"channels"`
+	Transfers    bool     `json:"transfers"`
+}
+
+// MOTDData is the server's message of the day (MOTD), sent on connect and when
+// the operator changes it. Empty Text means it was taken down.
+type MOTDData struct {
+	Text string `json:"text"`
+}
+
+// ErrorData is sent by the server when it rejects one of our messages
+type ErrorData struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type StatsData struct {
+	Clips   int64 `json:"clips"`
+	Bytes   int64 `json:"bytes"`
+	Devices int   `json:"devices"`
+}
+
 type FileOfferData struct {
-	Filename string `json:"filename"`
-	Filesize int64  `json:"filesize"`
-	TargetID string `json:"targetId,omitempty"`
+	TransferID  string `json:"transferId"`
+	Filename    string `json:"filename"`
+	Filesize    int64  `json:"filesize"`
+	TargetID    string `json:"targetId,omitempty"`
+	TargetGroup string `json:"targetGroup,omitempty"` // Offered to a device group rather than everyone
+	AsClipboard bool   `json:"asClipboard,omitempty"` // Receiver writes the file to its clipboard
 }
 
 type FileAckData struct {
-	Filename string `json:"filename"`
-	Allow    bool   `json:"allow"`
-	SourceID string `json:"sourceId"` // ID of the client who offered
+	TransferID string `json:"transferId"`
+	Filename   string `json:"filename"`
+	Allow      bool   `json:"allow"`
+	Reason     string `json:"reason,omitempty"` // Set when rejected by policy rather than by the user
+	SourceID   string `json:"sourceId"` // ID of the client who offered
+}
+
+type FileChunkData struct {
+	TransferID string `json:"transferId"`
+	TargetID   string `json:"targetId"`
+	Offset     int64  `json:"offset"`
+	Data       []byte `json:"data"` // base64 in JSON
+	Final      bool   `json:"final,omitempty"`
+}
+
+// FileCancelData aborts an accepted transfer from either end: the sender when it
+// can't read the file, the receiver when it can't write it.
+type FileCancelData struct {
+	TransferID string `json:"transferId"`
+	TargetID   string `json:"targetId"`
+	Reason     string `json:"reason,omitempty"`
 }
 
 // --- Bubbletea Messages ---
@@ -69,13 +292,86 @@ type ConnectionStatusMsg struct {
 	Err    error
 	Conn   *websocket.Conn 
 	Cancel func()         
+	Compressed bool // permessage-deflate was negotiated
+	Server     int  // 0 for the main server, else 1 + index into EXTRA_SERVERS
+}
+type ReceivedServerMsg struct { // Generic message from server
+	Msg    BaseMessage
+	Server int // As in ConnectionStatusMsg
+}
+type ExtraReconnectMsg struct {
+	Server int // 1 + index into EXTRA_SERVERS
+	Gen    int // Matches extraServer.gen unless it connected since
+}
+// ClipboardResendMsg carries a fresh read of the local clipboard for ResendClip or SyncNow
+type ClipboardResendMsg struct {
+	Content string
+	OneShot bool // SyncNow: sent only if it changed, but whether or not sync is on
+	TTLSeconds int // SendExpiring: sent with this TTL
+	Err     error
 }
-type ReceivedServerMsg struct{ Msg BaseMessage } // Generic message from server
 type LocalClipboardCheckedMsg struct {
 	Content string
 	Changed bool
+	Binary  bool // Content isn't valid UTF-8 and will be base64-encoded on the wire
+	Settled bool // A re-read once the settle window passed, not a poll; no next poll is scheduled
 	Err     error
 }
+// ClipSettleMsg ends the CLIP_SETTLE_MS window started by the change to Content at Since
+type ClipSettleMsg struct {
+	Content string
+	Since   time.Time
+}
+type FileChunkSentMsg struct {
+	Key  string // Send session key (see transferKey)
+	N    int    // Bytes sent in this chunk
+	Done bool
+	Err  error
+	Read bool // Err came from reading the source, e.g. zipping a directory, rather than sending
+}
+type ClipFilePreparedMsg struct {
+	Path string
+	Size int64
+	Err  error
+}
+type OverwriteCheckedMsg struct {
+	Incoming string
+	Local    string
+	Err      error
+}
+type AppendReadMsg struct {
+	Incoming string
+	Local    string
+	Err      error
+}
+type SyncPauseTickMsg struct {
+	Gen int // Matches Model.pauseGen unless the pause was changed or cancelled since
+}
+type PingRTTMsg struct {
+	RTT time.Duration // Round trip of one ping/pong
+}
+type ClipboardWrittenMsg struct {
+	Err error // Set once all retries failed
+}
+type ScreenLockMsg struct {
+	Locked bool
+	Err    error // Lock state can't be read here; PAUSE_ON_LOCK stops watching
+}
+type PrimaryCheckedMsg struct {
+	Content string // Linux primary selection, see syncPrimary
+	Changed bool
+	Err     error
+}
+type PairingMsg struct {
+	URL string // Connect URL carrying a fresh session token, for the pairing QR code
+	Err error
+}
+type ReconnectTickMsg struct {
+	Gen int // Matches Model.reconnectGen unless connected or retried manually since
+}
+type ServerInfoTimeoutMsg struct {
+	Gen int // Model.reconnectGen of the connection that sent server_info
+}
 type ErrorMsg struct{ Err error }
 type LogMsg string // Simple message to add to log view
 
@@ -83,22 +379,71 @@ type LogMsg string // Simple message to add to log view
 type keyMap struct {
 	Quit        key.Binding
 	ToggleSync  key.Binding
+	PauseSync   key.Binding
+	CycleSyncMode key.Binding
 	FocusNext   key.Binding
 	FocusPrev   key.Binding
+	FocusHistory key.Binding
+	FocusDevices key.Binding
+	FocusLog     key.Binding
 	AcceptFile  key.Binding 
 	RejectFile  key.Binding 
 	InitiateXfer key.Binding
-
+	SendToAll    key.Binding
+	ToggleGroup  key.Binding
+	PushToGroup  key.Binding
+	DoNotDisturb key.Binding
+	AppendMode   key.Binding
+	ViewEntry   key.Binding
+	HistoryTop    key.Binding
+	HistoryBottom key.Binding
+	CloseModal  key.Binding
+	ToggleSelf  key.Binding
+	RefreshDevices key.Binding
+	RefreshHistory key.Binding
+	DeleteEntry    key.Binding
+	Snippets       key.Binding
+	Palette        key.Binding
+	CopyReceived   key.Binding
+	PanicWipe      key.Binding
+	RetryNow       key.Binding
+	NextChannel    key.Binding
+	ServerClip     key.Binding
+	PairDevice     key.Binding
+	ExportLog      key.Binding
+	OpenURL        key.Binding
+	ResendClip     key.Binding
+	SyncNow        key.Binding
+	SendExpiring   key.Binding
+	MarkDiff       key.Binding
+	ToggleMark     key.Binding
+	JoinMarked     key.Binding
+	Favorite       key.Binding
+	FavoritesView  key.Binding
+	AllowDevice    key.Binding
+	BlockDevice    key.Binding
+	CopyShown      key.Binding
+	AddSnippet     key.Binding
+	RenameSnippet  key.Binding
+	ConfirmOverwrite key.Binding
+	SkipOverwrite    key.Binding
+	DismissMOTD      key.Binding
+	Transform        key.Binding
+	CopyUnsynced     key.Binding
 }
 
 func (k keyMap) ShortHelp() []key.Binding {
-    return []key.Binding{k.Quit, k.ToggleSync, k.FocusNext, k.FocusPrev}
+    return []key.Binding{k.Quit, k.ToggleSync, k.FocusNext, k.FocusPrev, k.Palette}
 }
 
 func (k keyMap) FullHelp() [][]key.Binding {
     return [][]key.Binding{
-        {k.Quit, k.ToggleSync, k.FocusNext, k.FocusPrev},                // General
-        {k.AcceptFile, k.RejectFile, k.InitiateXfer}, 
+        {k.Quit, k.ToggleSync, k.PauseSync, k.CycleSyncMode, k.AppendMode, k.FocusNext, k.FocusPrev, k.Palette},   // General
+        {k.AcceptFile, k.RejectFile, k.AllowDevice, k.BlockDevice, k.InitiateXfer, k.SendToAll, k.ToggleGroup, k.PushToGroup, k.DoNotDisturb},
+        {k.ViewEntry, k.HistoryTop, k.HistoryBottom, k.MarkDiff, k.ToggleMark, k.JoinMarked, k.Favorite, k.FavoritesView, k.OpenURL, k.Transform, k.CloseModal, k.ToggleSelf},
+        {k.RefreshDevices, k.RefreshHistory, k.DeleteEntry, k.Snippets, k.CopyReceived, k.ServerClip, k.ResendClip, k.SyncNow, k.SendExpiring, k.PairDevice},
+        {k.ConfirmOverwrite, k.SkipOverwrite, k.DismissMOTD},
+        {k.FocusHistory, k.FocusDevices, k.FocusLog, k.PanicWipe, k.RetryNow, k.NextChannel, k.ExportLog},
     }
 }
 
@@ -115,6 +460,10 @@ func defaultKeyMap() keyMap {
 			key.WithKeys("s"),
 			key.WithHelp("s", "toggle sync"),
 		),
+		PauseSync: key.NewBinding(
+			key.WithKeys("p"),
+			key.WithHelp("p", "pause sync 5/15/60m"),
+		),
 		FocusNext: key.NewBinding(
 			key.WithKeys("tab"),
 			key.WithHelp("tab", "next panel"),
@@ -123,6 +472,18 @@ func defaultKeyMap() keyMap {
 			key.WithKeys("shift+tab"),
 			key.WithHelp("shift+tab", "prev panel"),
 		),
+		FocusHistory: key.NewBinding(
+			key.WithKeys("1"),
+			key.WithHelp("1", "history panel"),
+		),
+		FocusDevices: key.NewBinding(
+			key.WithKeys("2"),
+			key.WithHelp("2", "devices panel"),
+		),
+		FocusLog: key.NewBinding(
+			key.WithKeys("3"),
+			key.WithHelp("3", "log panel"),
+		),
 		AcceptFile: key.NewBinding(
 			key.WithKeys("a"),
 			key.WithHelp("a", "accept file"),
@@ -133,7 +494,176 @@ func defaultKeyMap() keyMap {
 		),
 		InitiateXfer: key.NewBinding(
 			key.WithKeys("x"),
-			key.WithHelp("x", "initiate transfer (on device)"),
+			key.WithHelp("x", "send file/dir (on device)"),
+		),
+		ViewEntry: key.NewBinding(
+			key.WithKeys("enter", "v"),
+			key.WithHelp("enter/v", "view full entry"),
+		),
+		CloseModal: key.NewBinding(
+			key.WithKeys("esc"),
+			key.WithHelp("esc", "close view"),
+		),
+		ToggleSelf: key.NewBinding(
+			key.WithKeys("m"),
+			key.WithHelp("m", "show/hide this device"),
+		),
+		RefreshDevices: key.NewBinding(
+			key.WithKeys("D"),
+			key.WithHelp("D", "refresh devices"),
+		),
+		RefreshHistory: key.NewBinding(
+			key.WithKeys("H"),
+			key.WithHelp("H", "refresh history"),
+		),
+		DeleteEntry: key.NewBinding(
+			key.WithKeys("delete", "X"), // "d" is the list's next-page key
+			key.WithHelp("del/X", "delete history entry"),
+		),
+		RetryNow: key.NewBinding(
+			key.WithKeys("ctrl+r"),
+			key.WithHelp("ctrl+r", "reconnect now"),
+		),
+		AllowDevice: key.NewBinding(
+			key.WithKeys("A"),
+			key.WithHelp("A", "accept, always allow device"),
+		),
+		BlockDevice: key.NewBinding(
+			key.WithKeys("B"),
+			key.WithHelp("B", "reject, block device"),
+		),
+		MarkDiff: key.NewBinding(
+			key.WithKeys("="),
+			key.WithHelp("=", "mark/diff entries"),
+		),
+		ToggleMark: key.NewBinding(
+			key.WithKeys(" "),
+			key.WithHelp("space", "mark for join"),
+		),
+		JoinMarked: key.NewBinding(
+			key.WithKeys("J"),
+			key.WithHelp("J", "join marked"),
+		),
+		Favorite: key.NewBinding(
+			key.WithKeys("f"),
+			key.WithHelp("f", "favorite"),
+		),
+		FavoritesView: key.NewBinding(
+			key.WithKeys("F"),
+			key.WithHelp("F", "favorites only"),
+		),
+		SendToAll: key.NewBinding(
+			key.WithKeys("ctrl+x"),
+			key.WithHelp("ctrl+x", "send file to all"),
+		),
+		ToggleGroup: key.NewBinding(
+			key.WithKeys(" "),
+			key.WithHelp("space", "fold group"),
+		),
+		PushToGroup: key.NewBinding(
+			key.WithKeys("ctrl+g"),
+			key.WithHelp("ctrl+g", "push clip to group"),
+		),
+		CycleSyncMode: key.NewBinding(
+			key.WithKeys("M"),
+			key.WithHelp("M", "sync direction"),
+		),
+		DoNotDisturb: key.NewBinding(
+			key.WithKeys("z"),
+			key.WithHelp("z", "do not disturb"),
+		),
+		AppendMode: key.NewBinding(
+			key.WithKeys("ctrl+a"),
+			key.WithHelp("ctrl+a", "append mode"),
+		),
+		ResendClip: key.NewBinding(
+			key.WithKeys("R"),
+			key.WithHelp("R", "resend clipboard"),
+		),
+		SyncNow: key.NewBinding(
+			key.WithKeys("u"),
+			key.WithHelp("u", "sync once"),
+		),
+		SendExpiring: key.NewBinding(
+			key.WithKeys("E"),
+			key.WithHelp("E", "send clipboard, expiring"),
+		),
+		OpenURL: key.NewBinding( // Enabled only while there's a URL to open
+			key.WithKeys("o"),
+			key.WithHelp("o", "open link"),
+			key.WithDisabled(),
+		),
+		HistoryTop: key.NewBinding( // The list's own go-to-start keys, listed in our help
+			key.WithKeys("home", "g"),
+			key.WithHelp("g/home", "newest entry"),
+		),
+		HistoryBottom: key.NewBinding(
+			key.WithKeys("end", "G"),
+			key.WithHelp("G/end", "oldest entry"),
+		),
+		ExportLog: key.NewBinding(
+			key.WithKeys("L"),
+			key.WithHelp("L", "export log"),
+		),
+		PairDevice: key.NewBinding(
+			key.WithKeys("P"),
+			key.WithHelp("P", "pairing QR code"),
+		),
+		ServerClip: key.NewBinding(
+			key.WithKeys("W"),
+			key.WithHelp("W", "show server clip"),
+		),
+		CopyShown: key.NewBinding( // Content modal only
+			key.WithKeys("c"),
+			key.WithHelp("c", "copy"),
+		),
+		NextChannel: key.NewBinding(
+			key.WithKeys("C"),
+			key.WithHelp("C", "next channel"),
+		),
+		PanicWipe: key.NewBinding(
+			key.WithKeys("ctrl+k"),
+			key.WithHelp("ctrl+k", "panic wipe"),
+		),
+		CopyReceived: key.NewBinding(
+			key.WithKeys("c"),
+			key.WithHelp("c", "copy last received"),
+		),
+		Snippets: key.NewBinding(
+			key.WithKeys("S"),
+			key.WithHelp("S", "snippets"),
+		),
+		Palette: key.NewBinding(
+			key.WithKeys("ctrl+p", ":"),
+			key.WithHelp(": / ctrl+p", "command palette"),
+		),
+		AddSnippet: key.NewBinding( // Only in the snippets view
+			key.WithKeys("a"),
+			key.WithHelp("a", "add clipboard as snippet"),
+		),
+		RenameSnippet: key.NewBinding( // Only in the snippets view
+			key.WithKeys("e"),
+			key.WithHelp("e", "rename snippet"),
+		),
+		ConfirmOverwrite: key.NewBinding(
+			key.WithKeys("y"),
+			key.WithHelp("y", "overwrite clipboard"),
+		),
+		SkipOverwrite: key.NewBinding(
+			key.WithKeys("n"),
+			key.WithHelp("n", "keep local clipboard"),
+		),
+		DismissMOTD: key.NewBinding(
+			key.WithKeys("N"),
+			key.WithHelp("N", "dismiss server message"),
+		),
+		Transform: key.NewBinding(
+			key.WithKeys("T"),
+			key.WithHelp("T", "transform entry/clipboard"),
+		),
+		CopyUnsynced: key.NewBinding( // Transform menu only
+			key.WithKeys("c"),
+			key.WithHelp("c", "copy without syncing"),
 		),
 	}
 }
@@ -141,27 +671,146 @@ func defaultKeyMap() keyMap {
 // --- List Items ---
 
 // historyItem implements list.Item for clipboard history
-type historyItem string
+type historyItem struct {
+	Content  string
+	Local    bool   // Copied on this device rather than received from the server
+	Seq      int64  // Server sequence number, 0 if not known (e.g. local copies)
+	Origin   string // Hostname of the sending device, "" if local, unknown or not shown
+	Mark     int    // Position among entries marked for JoinMarked, 0 if unmarked
+	Favorite bool   // Mirrors Model.favorites, see favorites.go
+}
 
-func (h historyItem) FilterValue() string { return string(h) }
-func (h historyItem) Title() string       { return string(h) }
+// previewLength caps history titles in runes (PREVIEW_LENGTH); 0 shows everything
+var previewLength = 120
+
+func (h historyItem) FilterValue() string { return h.Content } // Filtering searches the full clip
+func (h historyItem) Title() string {
+	// Cut before sanitizing so huge clips aren't processed whole on every render
+	content, cut := truncateRunes(h.Content, previewLength)
+	title, cut2 := truncateRunes(strings.ReplaceAll(sanitizeForDisplay(content), "\n", "↵"), previewLength) // Keep list rows one line
+	if cut || cut2 {
+		title += "…"
+	}
+	if h.Mark > 0 {
+		title = fmt.Sprintf("[%d] %s", h.Mark, title)
+	}
+	if h.Favorite {
+		title = "★ " + title
+	}
+	if h.Local {
+		return "• " + title
+	}
+	if h.Origin != "" {
+		return "from " + h.Origin + ": " + title
+	}
+	return title
+}
 func (h historyItem) Description() string { return "" } // No description needed
 
 // deviceItem implements list.Item for connected devices
 type deviceItem ClientInfo // Use the ClientInfo struct
 
-func (d deviceItem) FilterValue() string { return d.Hostname }
-func (d deviceItem) Title() string       { return d.Hostname }
-func (d deviceItem) Description() string { return fmt.Sprintf("ID: %s", d.ID) }
-
-// --- File Transfer State (placeholder) ---
-type fileTransferState struct {
-	IsOffering    bool
-	IsReceiving   bool
-	OfferDetails  *FileOfferData
-	OfferingTo    string // Client ID
-	ReceivingFrom string // Client ID
-	Filename      string
-	Progress      float64 // 0.0 to 1.0
-	// Add more state as needed (file handles, chunk tracking etc)
+func (d deviceItem) FilterValue() string { return ClientInfo(d).Name() }
+func (d deviceItem) Title() string {
+	title := deviceLabel(d.Platform, d.Hostname) + " " + ClientInfo(d).Name()
+	if d.Server != "" {
+		title = d.Server + ": " + title
+	}
+	return title
+}
+func (d deviceItem) Description() string {
+	desc := fmt.Sprintf("ID: %s", d.ID)
+	if d.DisplayName != "" {
+		desc = d.Hostname + ", " + desc // The title shows the display name instead
+	}
+	if !d.SyncEnabled {
+		desc += " [sync off]"
+	}
+	return desc
+}
+
+// deviceLabel picks a short tag for the Devices pane from the platform, or failing
+// that (older clients don't send one) from hints in the hostname.
+func deviceLabel(platform, hostname string) string {
+	switch platform {
+	case "darwin":
+		return "[mac]"
+	case "windows":
+		return "[win]"
+	case "linux":
+		return "[lnx]"
+	case "android":
+		return "[and]"
+	case "ios":
+		return "[ios]"
+	case "freebsd", "openbsd", "netbsd", "dragonfly":
+		return "[bsd]"
+	}
+	h := strings.ToLower(hostname)
+	switch {
+	case strings.Contains(h, "phone") || strings.Contains(h, "pixel") || strings.Contains(h, "android"):
+		return "[tel]"
+	case strings.Contains(h, "macbook") || strings.Contains(h, "laptop"):
+		return "[lap]"
+	case strings.Contains(h, "server") || strings.Contains(h, "srv"):
+		return "[srv]"
+	}
+	return "[ ? ]"
+}
+
+// --- File Transfer State ---
+
+// outgoingOffer is a file we offered and are waiting on acks for
+type outgoingOffer struct {
+	Offer    FileOfferData
+	Path     string
+	IsDir    bool // Path is a directory, streamed to each peer as a zip
+	TempFile bool // Remove Path once we're done with it
+}
+
+// pendingOffer is an incoming offer waiting for the user to accept or reject it
+type pendingOffer struct {
+	Offer    FileOfferData
+	FromID   string
+	SavePath string // Chosen in the save prompt; "" saves to the download dir
+}
+
+// transferSession streams one offered file to one accepting peer
+type transferSession struct {
+	TransferID string
+	PeerID     string
+	Filename   string
+	Source     io.ReadCloser // Read sequentially, one chunk per send
+	Size       int64         // unknownFilesize for streamed archives
+	Sent       int64
+	Started    time.Time
+}
+
+// Rate returns the average send rate in bytes per second so far.
+func (s *transferSession) Rate() float64 {
+	elapsed := time.Since(s.Started).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(s.Sent) / elapsed
+}
+
+// incomingTransfer is a file being received from a peer
+type incomingTransfer struct {
+	Offer    FileOfferData
+	FromID   string
+	Path     string
+	File     *os.File
+	Received int64
+	Started  time.Time
+}
+
+// unknownFilesize marks an offer whose size isn't known up front (e.g. a zip built on the fly)
+const unknownFilesize = -1
+
+func (t *incomingTransfer) Progress() float64 {
+	if t.Offer.Filesize <= 0 {
+		return 0
+	}
+	return float64(t.Received) / float64(t.Offer.Filesize)
 }
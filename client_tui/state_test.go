@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestClipboardUpdateDataRoundTrip(t *testing.T) {
+	for _, content := range []string{"plain text", "héllo wörld", "\xff\xfe\x00 not utf-8", ""} {
+		data := newClipboardUpdateData(content)
+		wire, err := json.Marshal(data)
+		if err != nil {
+			t.Fatalf("marshal %q: %v", content, err)
+		}
+		var got ClipboardUpdateData
+		if err := json.Unmarshal(wire, &got); err != nil {
+			t.Fatalf("unmarshal %q: %v", content, err)
+		}
+		text, err := got.Text()
+		if err != nil || text != content {
+			t.Errorf("%q round-tripped to %q, %v", content, text, err)
+		}
+	}
+	if data := newClipboardUpdateData("\xff"); data.Encoding != clipEncodingBase64 {
+		t.Errorf("invalid UTF-8 sent with encoding %q, want %q", data.Encoding, clipEncodingBase64)
+	}
+}
+
+func TestDecodeHistory(t *testing.T) {
+	binary := "\xff\xfe binary"
+	entry := newHistoryEntryData(binary)
+	history := []HistoryEntryData{
+		{Content: "text", Seq: 3},
+		{Content: entry.Content, Encoding: entry.Encoding, Seq: 2},
+		{Content: "not base64!", Encoding: clipEncodingBase64, Seq: 1},
+	}
+	got, skipped := decodeHistory(history)
+	if skipped != 1 {
+		t.Errorf("skipped %d entries, want 1", skipped)
+	}
+	want := []HistoryEntryData{{Content: "text", Seq: 3}, {Content: binary, Seq: 2}}
+	if len(got) != len(want) {
+		t.Fatalf("got %d entries, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("entry %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
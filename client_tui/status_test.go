@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func TestRTTShownOnlyOnHover(t *testing.T) {
+	m := newTestModel(t, func(*Config) {})
+	m.connectedState, m.clipboardAvailable = Connected, true
+	m.rttSamples = []time.Duration{40 * time.Millisecond}
+	if q := m.connectionQuality(); strings.Contains(q, "ms") {
+		t.Errorf("RTT shown without hovering: %q", q)
+	}
+
+	next, _ := m.Update(tea.MouseMsg{Y: 1, Action: tea.MouseActionMotion})
+	m = next.(Model)
+	if q := m.connectionQuality(); !strings.Contains(q, "40ms") {
+		t.Errorf("RTT not shown while hovering the status bar: %q", q)
+	}
+
+	next, _ = m.Update(tea.MouseMsg{Y: 5, Action: tea.MouseActionMotion})
+	m = next.(Model)
+	if q := m.connectionQuality(); strings.Contains(q, "ms") {
+		t.Errorf("RTT still shown after moving off the status bar: %q", q)
+	}
+
+	// A banner above pushes the status bar down a row
+	m.motd = "maintenance at noon"
+	if m.overStatusBar(1) || !m.overStatusBar(2) {
+		t.Error("status bar row doesn't account for the MOTD banner")
+	}
+}
+
+func TestServerInfoRejectionMustNameIt(t *testing.T) {
+	m := newTestModel(t, func(*Config) {})
+	m.awaitingServerInfo = true
+	reject := func(code, text string) {
+		next, _ := m.Update(ReceivedServerMsg{Msg: BaseMessage{Type: "error", Data: ErrorData{Code: code, Message: text}}})
+		m = next.(Model)
+	}
+
+	reject("invalid_message", "invalid set_channel: channel name longer than 64 bytes")
+	if !m.awaitingServerInfo {
+		t.Fatal("an unrelated rejection was taken as the answer to server_info")
+	}
+	reject("unknown_type", `unknown message type "server_info"`)
+	if m.awaitingServerInfo {
+		t.Error("still waiting after the server rejected server_info")
+	}
+
+	m.awaitingServerInfo = true
+	next, _ := m.Update(ServerInfoTimeoutMsg{Gen: m.reconnectGen})
+	if next.(Model).awaitingServerInfo {
+		t.Error("still waiting for server_info after the timeout")
+	}
+}
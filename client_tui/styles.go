@@ -16,9 +16,16 @@ var (
 			Background(highlight)
 
 	errorStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#FF5E5E"))
+	warnStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("#FFC857"))
+
+	diffAddStyle = lipgloss.NewStyle().Foreground(special)
+	diffDelStyle = errorStyle.Copy()
 
 	syncStatusStyle = lipgloss.NewStyle().Foreground(special)
 
+	// Fixed colours whatever the terminal theme: scanners need dark modules on a light quiet zone
+	qrStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#FFFFFF")).Background(lipgloss.Color("#000000"))
+
 	paneStyle = lipgloss.NewStyle().
 			Border(lipgloss.RoundedBorder()).
 			Padding(0, 1)
@@ -27,6 +34,9 @@ var (
 
 	helpStyle = lipgloss.NewStyle().Foreground(subtle)
 
+	// The whole screen while dimmed for inactivity, see dim.go
+	dimStyle = lipgloss.NewStyle().Foreground(lipgloss.AdaptiveColor{Light: "#B0B0B0", Dark: "#5A5A5A"})
+
 	// List styles (can be customized further)
 	listTitleStyle = lipgloss.NewStyle().
 			Background(highlight).
@@ -0,0 +1,711 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+const fileChunkSize = 64 * 1024 // Raw bytes per file_chunk, well under maxMessageSize once base64'd
+
+// transferKey identifies a send session: one offered file going to one peer
+func transferKey(transferID, peerID string) string {
+	return transferID + ":" + peerID
+}
+
+// sendsAsFile reports whether a clip is too large for clipboard_update: over
+// CLIP_FILE_THRESHOLD, or over the server's own limit once encoded for the wire.
+// The default threshold leaves room for base64 under the default server limit;
+// server_info covers servers configured lower.
+func (m *Model) sendsAsFile(content string) bool {
+	if m.clipFileThreshold > 0 && len(content) > m.clipFileThreshold {
+		return true
+	}
+	if m.serverInfo == nil || m.serverInfo.MaxClipBytes <= 0 {
+		return false
+	}
+	wire := len(content)
+	if !utf8.ValidString(content) {
+		wire = base64.StdEncoding.EncodedLen(len(content))
+	}
+	return wire > m.serverInfo.MaxClipBytes
+}
+
+// prepareClipFileCmd writes an oversized clip to a temp file so it can be offered as a transfer.
+func prepareClipFileCmd(content string) tea.Cmd {
+	return func() tea.Msg {
+		f, err := os.CreateTemp("", "clipd-clip-*.txt")
+		if err != nil {
+			return ClipFilePreparedMsg{Err: fmt.Errorf("creating temp file: %w", err)}
+		}
+		defer f.Close()
+		n, err := io.WriteString(f, content)
+		if err != nil {
+			os.Remove(f.Name())
+			return ClipFilePreparedMsg{Err: fmt.Errorf("writing temp file: %w", err)}
+		}
+		return ClipFilePreparedMsg{Path: f.Name(), Size: int64(n)}
+	}
+}
+
+// sendFileChunkCmd reads the next chunk of a session and sends it to the peer.
+// The Model advances s.Sent when the resulting FileChunkSentMsg arrives, and only
+// then schedules the next chunk, so reads from s.Source never overlap.
+func sendFileChunkCmd(conn *websocket.Conn, key string, s *transferSession) tea.Cmd {
+	offset := s.Sent
+	return func() tea.Msg {
+		buf := make([]byte, fileChunkSize)
+		n, err := io.ReadFull(s.Source, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return FileChunkSentMsg{Key: key, Err: fmt.Errorf("reading %s: %w", s.Filename, err), Read: true}
+		}
+		// A short read means the source is exhausted; this also covers streams of unknown size
+		final := n < fileChunkSize
+		chunk := BaseMessage{
+			Type: "file_chunk",
+			Data: FileChunkData{
+				TransferID: s.TransferID,
+				TargetID:   s.PeerID,
+				Offset:     offset,
+				Data:       buf[:n],
+				Final:      final,
+			},
+		}
+		if msg := sendWebsocketMessageCmd(conn, chunk)(); msg != nil {
+			if errMsg, ok := msg.(ErrorMsg); ok {
+				return FileChunkSentMsg{Key: key, Err: errMsg.Err}
+			}
+		}
+		return FileChunkSentMsg{Key: key, N: n, Done: final}
+	}
+}
+
+// tokenBucket paces outgoing chunks to a byte rate. A nil bucket means unlimited.
+type tokenBucket struct {
+	rate   float64 // Bytes per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(bytesPerSec int) *tokenBucket {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	// Allow one chunk of burst so the first chunk never waits
+	burst := float64(max(bytesPerSec, fileChunkSize))
+	return &tokenBucket{rate: float64(bytesPerSec), burst: burst, tokens: burst, last: time.Now()}
+}
+
+// Reserve takes n bytes worth of tokens and returns how long to wait before sending them.
+func (b *tokenBucket) Reserve(n int) time.Duration {
+	if b == nil {
+		return 0
+	}
+	now := time.Now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// nextChunkCmd schedules the next chunk of a session, waiting on the rate limit if one is set.
+func (m *Model) nextChunkCmd(key string, s *transferSession) tea.Cmd {
+	wait := m.sendLimiter.Reserve(fileChunkSize)
+	if wait == 0 {
+		return sendFileChunkCmd(m.wsConn, key, s)
+	}
+	conn := m.wsConn
+	return tea.Tick(wait, func(time.Time) tea.Msg {
+		return sendFileChunkCmd(conn, key, s)()
+	})
+}
+
+// transfersView renders one progress line per active transfer, or "" if there are none.
+// A file sent to all devices gets one line summing its per-device sessions.
+func (m Model) transfersView() string {
+	type aggregate struct {
+		filename   string
+		peers      int
+		sent, size int64
+		rate       float64
+	}
+	shared := make(map[string]*aggregate)
+	var lines []string
+	for _, s := range m.sendSessions {
+		if o, ok := m.outgoingOffers[s.TransferID]; ok && o.Offer.TargetID == "" && !o.Offer.AsClipboard {
+			a := shared[s.TransferID]
+			if a == nil {
+				a = &aggregate{filename: s.Filename}
+				shared[s.TransferID] = a
+			}
+			a.peers++
+			a.sent += s.Sent
+			a.size += s.Size // Stays negative for streams of unknown size, which progressText handles
+			a.rate += s.Rate()
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("↑ %s -> %s %s @ %s/s",
+			s.Filename, m.devicesMap[s.PeerID], progressText(s.Sent, s.Size), formatBytes(int64(s.Rate()))))
+	}
+	for _, a := range shared {
+		lines = append(lines, fmt.Sprintf("↑ %s -> %d devices %s @ %s/s",
+			a.filename, a.peers, progressText(a.sent, a.size), formatBytes(int64(a.rate))))
+	}
+	for peerID, queue := range m.offerQueue {
+		lines = append(lines, fmt.Sprintf("⋯ %d queued for %s", len(queue), m.devicesMap[peerID]))
+	}
+	for _, t := range m.recvTransfers {
+		rate := float64(t.Received) / max(time.Since(t.Started).Seconds(), 1e-3)
+		lines = append(lines, fmt.Sprintf("↓ %s <- %s %s @ %s/s",
+			t.Offer.Filename, m.devicesMap[t.FromID], progressText(t.Received, t.Offer.Filesize), formatBytes(int64(rate))))
+	}
+	sort.Strings(lines) // Map order is random; keep the lines from jumping around
+	return strings.Join(lines, "\n")
+}
+
+// progressText shows a percentage, or just the byte count for streams of unknown size.
+func progressText(n, total int64) string {
+	if total <= 0 {
+		return formatBytes(n)
+	}
+	return fmt.Sprintf("%3.0f%%", float64(n)/float64(total)*100)
+}
+
+// offerFile registers an outgoing offer and returns the command announcing it.
+// An empty Offer.TargetID offers the file to every other device.
+func (m *Model) offerFile(o *outgoingOffer) tea.Cmd {
+	o.Offer.TransferID = uuid.NewString()
+	if o.Offer.Filename == "" {
+		o.Offer.Filename = filepath.Base(o.Path)
+	}
+	m.outgoingOffers[o.Offer.TransferID] = o
+	return sendWebsocketMessageCmd(m.wsConn, BaseMessage{Type: "file_offer", Data: o.Offer})
+}
+
+// queueOffer offers o to its target, or queues it if a transfer to that device is
+// still pending, so files to one device go one after another rather than interleaved.
+func (m *Model) queueOffer(o *outgoingOffer) tea.Cmd {
+	peerID := o.Offer.TargetID
+	if peerID == "" {
+		// Sent to every device or a group: each accepter gets its own session, so there's nothing to queue behind
+		m.logf("Offered '%s' to %s", o.Path, m.targetName("", o.Offer.TargetGroup))
+		return m.offerFile(o)
+	}
+	if _, busy := m.activeOffers[peerID]; busy {
+		m.offerQueue[peerID] = append(m.offerQueue[peerID], o)
+		m.logf("Queued '%s' for %s (%d waiting)", o.Path, m.devicesMap[peerID], len(m.offerQueue[peerID]))
+		return nil
+	}
+	cmd := m.offerFile(o)
+	m.activeOffers[peerID] = o.Offer.TransferID
+	m.logf("Offered '%s' to %s", o.Path, m.devicesMap[peerID])
+	return cmd
+}
+
+// targetName names an offer's target for display.
+func (m *Model) targetName(targetID, group string) string {
+	if targetID == "" && group != "" {
+		return "group " + group
+	}
+	if targetID == "" {
+		return "all devices"
+	}
+	return m.devicesMap[targetID]
+}
+
+// advanceOfferQueue releases a device once transferID to it is rejected, sent or
+// failed, and offers the next file queued for it.
+func (m *Model) advanceOfferQueue(peerID, transferID string) tea.Cmd {
+	if m.activeOffers[peerID] != transferID {
+		return nil // Not a queued transfer, e.g. a large clip offered to everyone
+	}
+	delete(m.activeOffers, peerID)
+	queue := m.offerQueue[peerID]
+	if len(queue) == 0 {
+		return nil
+	}
+	if len(queue) == 1 {
+		delete(m.offerQueue, peerID)
+	} else {
+		m.offerQueue[peerID] = queue[1:]
+	}
+	return m.queueOffer(queue[0])
+}
+
+// popIncomingOffer removes and returns the oldest pending incoming offer.
+func (m *Model) popIncomingOffer() pendingOffer {
+	p := m.incomingOffers[0]
+	m.incomingOffers = m.incomingOffers[1:]
+	if len(m.incomingOffers) > 0 {
+		m.logf(">>> Next offer: '%s' from %s (%d pending)", m.incomingOffers[0].Offer.Filename,
+			m.devicesMap[m.incomingOffers[0].FromID], len(m.incomingOffers))
+	}
+	return p
+}
+
+// answerOffer accepts or rejects an incoming offer. Reason is sent along with
+// rejections made by policy rather than by the user.
+func (m *Model) answerOffer(p pendingOffer, allow bool, reason string) tea.Cmd {
+	if allow {
+		if err := m.beginReceive(p.Offer, p.FromID, p.SavePath); err != nil {
+			m.logf("Cannot accept '%s': %v", p.Offer.Filename, err)
+			allow = false
+		} else {
+			m.logf("Accepting file offer for '%s' from %s", p.Offer.Filename, m.devicesMap[p.FromID])
+		}
+	} else if reason != "" {
+		m.logf("Rejecting file offer for '%s': %s", p.Offer.Filename, reason)
+	} else {
+		m.logf("Rejecting file offer for '%s'", p.Offer.Filename)
+	}
+	ack := BaseMessage{
+		Type: "file_ack",
+		Data: FileAckData{TransferID: p.Offer.TransferID, Filename: p.Offer.Filename, Allow: allow, Reason: reason, SourceID: p.FromID},
+	}
+	return sendWebsocketMessageCmd(m.wsConn, ack)
+}
+
+// newTransferPolicy builds the TRANSFER_ALLOW/TRANSFER_BLOCK lookup. Blocking wins
+// if a device is on both lists.
+func newTransferPolicy(allow, block []string) map[string]bool {
+	policy := make(map[string]bool, len(allow)+len(block))
+	for _, d := range allow {
+		policy[d] = true
+	}
+	for _, d := range block {
+		policy[d] = false
+	}
+	return policy
+}
+
+// transferPolicyFor reports whether offers from a connected client are always
+// allowed or blocked, matching its stable device ID before its hostname. ok is
+// false for devices without a policy, whose offers are prompted for.
+func (m *Model) transferPolicyFor(clientID string) (allow, ok bool) {
+	for _, d := range m.devices {
+		if d.ID == clientID && d.DeviceID != "" {
+			if allow, ok = m.transferPolicy[d.DeviceID]; ok {
+				return allow, true
+			}
+		}
+	}
+	allow, ok = m.transferPolicy[m.devicesMap[clientID]]
+	return allow, ok
+}
+
+// setTransferPolicy allows or blocks a device's offers for the rest of the session
+// and answers its pending offers accordingly.
+func (m *Model) setTransferPolicy(clientID string, allow bool) tea.Cmd {
+	name := m.devicesMap[clientID]
+	for _, d := range m.devices {
+		if d.ID == clientID && d.DeviceID != "" {
+			name = d.DeviceID // Survives reconnects and hostname changes
+		}
+	}
+	m.transferPolicy[name] = allow
+	m.logf("%s file offers from %s for this session", map[bool]string{true: "Allowing", false: "Blocking"}[allow], m.devicesMap[clientID])
+
+	reason := ""
+	if !allow {
+		reason = "device blocked"
+	}
+	var cmds []tea.Cmd
+	kept := m.incomingOffers[:0]
+	for _, p := range m.incomingOffers {
+		if p.FromID == clientID {
+			cmds = append(cmds, m.answerOffer(p, allow, reason))
+		} else {
+			kept = append(kept, p)
+		}
+	}
+	m.incomingOffers = kept
+	return tea.Batch(cmds...)
+}
+
+// pruneOffers drops offers and partial downloads from, and queued files for,
+// devices no longer connected.
+func (m *Model) pruneOffers() {
+	kept := m.incomingOffers[:0]
+	for _, p := range m.incomingOffers {
+		if _, ok := m.devicesMap[p.FromID]; ok {
+			kept = append(kept, p)
+		}
+	}
+	m.incomingOffers = kept
+	for id, t := range m.recvTransfers {
+		if _, ok := m.devicesMap[t.FromID]; !ok {
+			m.logf("Discarded partial '%s': sender left", t.Offer.Filename)
+			m.abortReceive(id)
+		}
+	}
+	for peerID := range m.activeOffers {
+		if _, ok := m.devicesMap[peerID]; !ok {
+			delete(m.activeOffers, peerID)
+			if n := len(m.offerQueue[peerID]); n > 0 {
+				m.logf("Dropped %d queued file(s): device left", n)
+			}
+			delete(m.offerQueue, peerID)
+		}
+	}
+}
+
+// offerPath offers a file or directory picked by the user to targetID, or queues
+// it behind an earlier file to the same device.
+func (m *Model) offerPath(path, targetID, group string) (tea.Cmd, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	o := &outgoingOffer{
+		Offer: FileOfferData{Filesize: info.Size(), TargetID: targetID, TargetGroup: group},
+		Path:  path,
+	}
+	if info.IsDir() {
+		o.IsDir = true
+		o.Offer.Filename = filepath.Base(filepath.Clean(path)) + ".zip"
+		o.Offer.Filesize = unknownFilesize
+	} else if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file or directory", path)
+	}
+	return m.queueOffer(o), nil
+}
+
+// openSource opens what an offer sends: the file itself, or a zip of a directory.
+func openSource(o *outgoingOffer) (io.ReadCloser, error) {
+	if o.IsDir {
+		return zipDirStream(o.Path), nil
+	}
+	return os.Open(o.Path)
+}
+
+// zipDirStream zips dir on the fly into a pipe, so even very large directories are
+// never buffered in memory. Closing the reader early aborts the archive.
+func zipDirStream(dir string) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		zw := zip.NewWriter(pw)
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.Type().IsRegular() { // Skip dirs (implied by file paths), symlinks, devices
+				return nil
+			}
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			hdr, err := zip.FileInfoHeader(info)
+			if err != nil {
+				return err
+			}
+			hdr.Name = filepath.ToSlash(rel)
+			hdr.Method = zip.Deflate
+			w, err := zw.CreateHeader(hdr)
+			if err != nil {
+				return err
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			_, err = io.Copy(w, f)
+			return err
+		})
+		if err == nil {
+			err = zw.Close()
+		}
+		if err != nil {
+			err = fmt.Errorf("zipping %s: %w", dir, err)
+		}
+		pw.CloseWithError(err) // Fails the read, and so the send, rather than ending the zip short
+	}()
+	return pr
+}
+
+// startSendSession opens the offered file for a peer that accepted it.
+func (m *Model) startSendSession(transferID, peerID string) tea.Cmd {
+	offer, ok := m.outgoingOffers[transferID]
+	if !ok {
+		m.logf("Ack for unknown transfer %s", transferID)
+		return nil
+	}
+	src, err := openSource(offer)
+	if err != nil {
+		m.logf("Cannot open '%s' for transfer: %v", offer.Offer.Filename, err)
+		return tea.Batch(m.cancelTransfer(transferID, peerID, cancelReadFailed), m.advanceOfferQueue(peerID, transferID))
+	}
+	key := transferKey(transferID, peerID)
+	s := &transferSession{
+		TransferID: transferID,
+		PeerID:     peerID,
+		Filename:   offer.Offer.Filename,
+		Source:     src,
+		Size:       offer.Offer.Filesize,
+		Started:    time.Now(),
+	}
+	m.sendSessions[key] = s
+	return m.nextChunkCmd(key, s)
+}
+
+// finishSendSession closes a session's file handle and forgets it.
+func (m *Model) finishSendSession(key string) {
+	s, ok := m.sendSessions[key]
+	if !ok {
+		return
+	}
+	s.Source.Close()
+	delete(m.sendSessions, key)
+}
+
+// cancelReadFailed is the reason sent when the sender can't read what it offered.
+// The error itself stays local; it names paths on this machine.
+const cancelReadFailed = "sender could not read the file"
+
+// cancelTransfer tells the peer an accepted transfer won't complete, so a
+// receiver discards its partial file and a sender stops sending.
+func (m *Model) cancelTransfer(transferID, peerID, reason string) tea.Cmd {
+	return sendWebsocketMessageCmd(m.wsConn, BaseMessage{
+		Type: "file_cancel",
+		Data: FileCancelData{TransferID: transferID, TargetID: peerID, Reason: reason},
+	})
+}
+
+// handleFileCancel ends whichever side of a transfer the peer cancelled.
+func (m *Model) handleFileCancel(data FileCancelData, senderID string) tea.Cmd {
+	reason := data.Reason
+	if reason == "" {
+		reason = "no reason given"
+	}
+	if t, ok := m.recvTransfers[data.TransferID]; ok && t.FromID == senderID {
+		m.logf("%s cancelled '%s': %s", m.devicesMap[senderID], t.Offer.Filename, reason)
+		m.abortReceive(data.TransferID)
+		return nil
+	}
+	key := transferKey(data.TransferID, senderID)
+	if s, ok := m.sendSessions[key]; ok {
+		m.logf("%s cancelled '%s': %s", m.devicesMap[senderID], s.Filename, reason)
+		m.finishSendSession(key)
+		return m.advanceOfferQueue(senderID, data.TransferID)
+	}
+	return nil
+}
+
+// uniquePath returns dir/name, adding a numeric suffix if that file already exists.
+func uniquePath(dir, name string) string {
+	path := filepath.Join(dir, name)
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	for i := 1; ; i++ {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return path
+		}
+		path = filepath.Join(dir, fmt.Sprintf("%s (%d)%s", stem, i, ext))
+	}
+}
+
+// beginReceive creates the destination file for an accepted offer: savePath if
+// one was chosen, else the offered name in the download dir. Existing files are
+// never overwritten; a numbered name is used instead.
+func (m *Model) beginReceive(offer FileOfferData, fromID, savePath string) error {
+	var (
+		f   *os.File
+		err error
+	)
+	switch {
+	case offer.AsClipboard:
+		f, err = os.CreateTemp("", "clipd-recv-*.txt")
+	case savePath != "":
+		f, err = os.Create(uniquePath(filepath.Dir(savePath), filepath.Base(savePath)))
+	default:
+		if err = os.MkdirAll(m.downloadDir, 0750); err != nil {
+			return fmt.Errorf("creating download dir: %w", err)
+		}
+		f, err = os.Create(uniquePath(m.downloadDir, filepath.Base(offer.Filename)))
+	}
+	if err != nil {
+		return fmt.Errorf("creating destination file: %w", err)
+	}
+	m.recvTransfers[offer.TransferID] = &incomingTransfer{
+		Offer:   offer,
+		FromID:  fromID,
+		Path:    f.Name(),
+		File:    f,
+		Started: time.Now(),
+	}
+	return nil
+}
+
+// handleFileChunk writes a received chunk and finalises the transfer on the last one.
+func (m *Model) handleFileChunk(chunk FileChunkData, senderID string) tea.Cmd {
+	t, ok := m.recvTransfers[chunk.TransferID]
+	if !ok || t.FromID != senderID {
+		log.Printf("Dropping chunk for unknown transfer %s from %s", chunk.TransferID, senderID)
+		return nil
+	}
+	if size := t.Offer.Filesize; size != unknownFilesize && chunk.Offset+int64(len(chunk.Data)) > size {
+		// WriteAt would grow the file past what was offered, or seek far beyond it
+		m.logf("Chunk at offset %d runs past the %d bytes offered for '%s'; discarding it.", chunk.Offset, size, t.Offer.Filename)
+		m.abortReceive(chunk.TransferID)
+		return m.cancelTransfer(chunk.TransferID, senderID, "chunk out of range")
+	}
+	if _, err := t.File.WriteAt(chunk.Data, chunk.Offset); err != nil {
+		m.logf("Error writing '%s': %v", t.Offer.Filename, err)
+		m.abortReceive(chunk.TransferID)
+		return m.cancelTransfer(chunk.TransferID, senderID, "receiver cannot write the file")
+	}
+	t.Received += int64(len(chunk.Data))
+	if !chunk.Final {
+		return nil
+	}
+
+	t.File.Close()
+	delete(m.recvTransfers, chunk.TransferID)
+
+	if !t.Offer.AsClipboard {
+		m.logf("Received '%s' (%d bytes) -> %s", t.Offer.Filename, t.Received, t.Path)
+		m.filesReceived++
+		return nil
+	}
+
+	content, err := os.ReadFile(t.Path)
+	os.Remove(t.Path)
+	if err != nil {
+		m.logf("Error reading received clip: %v", err)
+		return nil
+	}
+	m.logf("Received large clip (%d bytes) via file transfer", len(content))
+	// Large clips bypass the server's clip sequence, so there's no Seq
+	return m.applyRemoteClip(historyItem{Content: string(content), Origin: m.clipOrigin(t.FromID)})
+}
+
+// abortReceive discards a partially received file.
+func (m *Model) abortReceive(transferID string) {
+	t, ok := m.recvTransfers[transferID]
+	if !ok {
+		return
+	}
+	t.File.Close()
+	os.Remove(t.Path)
+	delete(m.recvTransfers, transferID)
+}
+
+// cleanupTransfers closes open files, removes temp files and drops prompts for
+// offers and sends, used on quit and when the connection drops.
+func (m *Model) cleanupTransfers() {
+	for key := range m.sendSessions {
+		m.finishSendSession(key)
+	}
+	for id := range m.recvTransfers {
+		m.abortReceive(id)
+	}
+	for id, o := range m.outgoingOffers {
+		if o.TempFile {
+			os.Remove(o.Path)
+		}
+		delete(m.outgoingOffers, id)
+	}
+	clear(m.activeOffers)
+	clear(m.offerQueue)
+	m.incomingOffers = nil // Can't be acked over a new connection
+	// Nor can an offer whose save path is being asked for, and a path typed for a
+	// send would be offered to a target that may be gone
+	if m.savingOffer != nil {
+		m.savingOffer, m.saveErr = nil, nil
+		m.saveInput.Blur()
+		m.logf("Disconnected; dropped the save prompt for a pending offer.")
+	}
+	if m.promptingPath {
+		m.promptingPath = false
+		m.pathInput.Blur()
+		m.logf("Disconnected; transfer cancelled.")
+	}
+}
+
+// askSavePath asks where to save an offer being accepted, starting from the
+// offered name in the download dir. Large clips sent as files skip the prompt.
+func (m *Model) askSavePath(p pendingOffer) tea.Cmd {
+	m.savingOffer = &p
+	m.saveErr = nil
+	m.saveInput.SetValue(filepath.Join(m.downloadDir, filepath.Base(p.Offer.Filename)))
+	m.saveInput.CursorEnd()
+	return m.saveInput.Focus()
+}
+
+// updateSavePrompt handles keys while the save prompt is open. Esc puts the offer
+// back undecided rather than rejecting it.
+func (m *Model) updateSavePrompt(msg tea.KeyMsg) tea.Cmd {
+	p := m.savingOffer
+	switch {
+	case key.Matches(msg, m.keys.CloseModal):
+		m.savingOffer = nil
+		m.incomingOffers = append([]pendingOffer{*p}, m.incomingOffers...)
+		m.logf("Save cancelled; '%s' is still waiting.", p.Offer.Filename)
+	case msg.Type == tea.KeyEnter:
+		path, err := checkSavePath(strings.TrimSpace(m.saveInput.Value()), p.Offer.Filename)
+		if err != nil {
+			m.saveErr = err
+			return nil // Keep the prompt open to fix the path
+		}
+		m.savingOffer = nil
+		p.SavePath = path
+		return m.answerOffer(*p, true, "")
+	default:
+		var cmd tea.Cmd
+		m.saveInput, cmd = m.saveInput.Update(msg)
+		return cmd
+	}
+	return nil
+}
+
+// checkSavePath resolves where a file goes: path itself, or the offered name
+// inside it if path is a directory. The directory must exist and be writable, so
+// the offer isn't accepted only for the download to fail.
+func checkSavePath(path, filename string) (string, error) {
+	if path == "" {
+		return "", errors.New("no path given")
+	}
+	path = filepath.Clean(path)
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		path = filepath.Join(path, filepath.Base(filename))
+	}
+	dir := filepath.Dir(path)
+	info, err := os.Stat(dir)
+	if err != nil {
+		return "", fmt.Errorf("%s does not exist", dir)
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", dir)
+	}
+	probe, err := os.CreateTemp(dir, ".clipd-write-check-*")
+	if err != nil {
+		return "", fmt.Errorf("%s is not writable", dir)
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+	return path, nil
+}
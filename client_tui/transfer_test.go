@@ -0,0 +1,89 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// receiving sets up a Model partway through receiving a size-byte offer from "peer".
+func receiving(t *testing.T, size int64) (*Model, string) {
+	t.Helper()
+	m := &Model{recvTransfers: make(map[string]*incomingTransfer), devicesMap: map[string]string{"peer": "laptop"}}
+	m.downloadDir = t.TempDir()
+	if err := m.beginReceive(FileOfferData{TransferID: "t1", Filename: "f.bin", Filesize: size}, "peer", ""); err != nil {
+		t.Fatal(err)
+	}
+	return m, m.recvTransfers["t1"].Path
+}
+
+func TestFileChunkOutOfRangeAbortsReceive(t *testing.T) {
+	m, path := receiving(t, 4)
+	if cmd := m.handleFileChunk(FileChunkData{TransferID: "t1", Offset: 1 << 40, Data: []byte("x")}, "peer"); cmd == nil {
+		t.Error("out-of-range chunk didn't cancel the transfer with the sender")
+	}
+	if _, ok := m.recvTransfers["t1"]; ok {
+		t.Error("transfer still open after an out-of-range chunk")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("partial file left at %s", path)
+	}
+}
+
+func TestFileChunkWithinRange(t *testing.T) {
+	m, path := receiving(t, 4)
+	m.handleFileChunk(FileChunkData{TransferID: "t1", Offset: 0, Data: []byte("ab")}, "peer")
+	m.handleFileChunk(FileChunkData{TransferID: "t1", Offset: 2, Data: []byte("cd"), Final: true}, "peer")
+	b, err := os.ReadFile(path)
+	if err != nil || string(b) != "abcd" {
+		t.Errorf("received %q, %v; want \"abcd\"", b, err)
+	}
+}
+
+func TestFileCancelDiscardsPartialFile(t *testing.T) {
+	m, path := receiving(t, 4)
+	m.handleFileChunk(FileChunkData{TransferID: "t1", Offset: 0, Data: []byte("ab")}, "peer")
+
+	m.handleFileCancel(FileCancelData{TransferID: "t1", Reason: "sender failed"}, "someone else")
+	if _, ok := m.recvTransfers["t1"]; !ok {
+		t.Fatal("a device other than the sender cancelled the transfer")
+	}
+	m.handleFileCancel(FileCancelData{TransferID: "t1", Reason: "sender failed"}, "peer")
+	if _, ok := m.recvTransfers["t1"]; ok {
+		t.Error("transfer still open after the sender cancelled it")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("partial file left at %s", path)
+	}
+}
+
+func TestZipErrorFailsTheSend(t *testing.T) {
+	s := &transferSession{TransferID: "t1", PeerID: "peer", Filename: "gone.zip", Source: zipDirStream(filepath.Join(t.TempDir(), "gone"))}
+	defer s.Source.Close()
+	msg, ok := sendFileChunkCmd(nil, "k", s)().(FileChunkSentMsg)
+	if !ok || msg.Err == nil || !msg.Read || msg.Done {
+		t.Errorf("got %+v, want a read error and no final chunk", msg)
+	}
+}
+
+func TestSendsAsFileAllowsForEncoding(t *testing.T) {
+	m := &Model{clipFileThreshold: 192 * 1024, serverInfo: &ServerInfoData{MaxClipBytes: 256 * 1024}}
+	text := strings.Repeat("a", 192*1024)
+	binary := strings.Repeat("\xff", 192*1024) // Sent base64-encoded: exactly 256KB on the wire
+	if m.sendsAsFile(text) || m.sendsAsFile(binary) {
+		t.Error("clips at the default threshold should fit the default server limit")
+	}
+	if !m.sendsAsFile(text + "a") {
+		t.Error("clip over CLIP_FILE_THRESHOLD not sent as a file")
+	}
+
+	m.clipFileThreshold = 0
+	m.serverInfo.MaxClipBytes = 1000
+	if m.sendsAsFile(strings.Repeat("a", 1000)) {
+		t.Error("text at the server limit sent as a file")
+	}
+	if !m.sendsAsFile(strings.Repeat("\xff", 751)) {
+		t.Error("binary clip over the server limit once base64-encoded not sent as a file")
+	}
+}
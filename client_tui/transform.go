@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// transform is one entry in the transform menu.
+type transform struct {
+	Name  string
+	Desc  string
+	Apply func(string) string
+}
+
+// transforms is the transform menu, in the order it's listed.
+var transforms = []transform{
+	{"Trim", "remove leading and trailing whitespace", strings.TrimSpace},
+	{"Squeeze whitespace", "collapse runs of spaces and newlines into one space", func(s string) string {
+		return strings.Join(strings.Fields(s), " ")
+	}},
+	{"Uppercase", "convert to upper case", strings.ToUpper},
+	{"Lowercase", "convert to lower case", strings.ToLower},
+	{"Base64 encode", "standard base64 with padding", func(s string) string {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	}},
+	{"URL encode", "escape for use in a query string", url.QueryEscape},
+}
+
+// transformItem lists a transform in the menu.
+type transformItem struct {
+	transform
+}
+
+func (t transformItem) FilterValue() string { return t.Name }
+func (t transformItem) Title() string       { return t.Name }
+func (t transformItem) Description() string { return t.Desc }
+
+func transformItems() []list.Item {
+	items := make([]list.Item, len(transforms))
+	for i, t := range transforms {
+		items[i] = transformItem{t}
+	}
+	return items
+}
+
+// openTransforms opens the transform menu on the selected history entry, or on
+// the local clipboard when the history pane isn't focused.
+func (m *Model) openTransforms() {
+	content, what := m.lastLocalClip, "clipboard"
+	if m.focus == HistoryPane {
+		item, ok := m.histList.SelectedItem().(historyItem)
+		if !ok {
+			m.logf("No history entry selected to transform.")
+			return
+		}
+		content, what = item.Content, "history entry"
+	}
+	if content == "" {
+		m.logf("The %s is empty, nothing to transform.", what)
+		return
+	}
+	m.transformSrc = content
+	m.transformList.Title = fmt.Sprintf("Transform %s (%s)", what, formatBytes(int64(len(content))))
+	m.transformList.ResetFilter()
+	m.transformList.Select(0)
+	m.showTransforms = true
+}
+
+// updateTransforms handles keys while the transform menu is open. The result
+// goes to the local clipboard; with enter the poller then syncs it like any
+// local copy, with CopyUnsynced it stays on this device.
+func (m *Model) updateTransforms(msg tea.KeyMsg) tea.Cmd {
+	var cmd tea.Cmd
+	if m.transformList.FilterState() == list.Filtering {
+		m.transformList, cmd = m.transformList.Update(msg)
+		return cmd
+	}
+	selected, ok := m.transformList.SelectedItem().(transformItem)
+	unsynced := key.Matches(msg, m.keys.CopyUnsynced)
+	switch {
+	case key.Matches(msg, m.keys.CloseModal):
+		m.showTransforms = false
+		m.transformSrc = ""
+	case (msg.Type == tea.KeyEnter || unsynced) && ok:
+		m.showTransforms = false
+		result := selected.Apply(m.transformSrc)
+		m.transformSrc = ""
+		switch {
+		case result == "":
+			m.logf("%s left nothing to copy.", selected.Name)
+			return nil
+		case !m.clipboardAvailable:
+			m.logf("Local clipboard unavailable, cannot copy the result.")
+			return nil
+		}
+		if unsynced {
+			m.unsyncedClip = result
+			m.addHistoryEntry(historyItem{Content: result, Local: true})
+			m.logf("%s: copied %d bytes to the clipboard, not synced.", selected.Name, len(result))
+		} else {
+			m.logf("%s: copied %d bytes to the clipboard.", selected.Name, len(result))
+		}
+		return writeToClipboardCmd(result)
+	default:
+		m.transformList, cmd = m.transformList.Update(msg)
+	}
+	return cmd
+}
+
+// transformsView renders the transform menu.
+func (m Model) transformsView() string {
+	footer := helpStyle.Render(fmt.Sprintf("enter copy and sync • %s %s • %s close",
+		m.keys.CopyUnsynced.Help().Key, m.keys.CopyUnsynced.Help().Desc, m.keys.CloseModal.Help().Key))
+	body := focusedPaneStyle.Render(m.transformList.View())
+	return docStyle.Render(lipgloss.JoinVertical(lipgloss.Left, body, footer))
+}
@@ -3,10 +3,21 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net"
+	"net/http"
 	"net/url"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 "github.com/atotto/clipboard"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/gorilla/websocket"
@@ -19,8 +30,113 @@ const (
 	maxMessageSize = 512 * 1024        // Maximum message size allowed from peer.
 )
 
+// wsWriteMu serialises writes: gorilla/websocket allows only one concurrent writer,
+// and pings, sends and file chunks all run in separate goroutines.
+var wsWriteMu sync.Mutex
+
+// connStats compares message payload bytes with what actually crossed the socket,
+// to show how much permessage-deflate saves. Reset on every connect.
+type connStats struct {
+	rawSent, rawRecv   atomic.Int64 // Message payloads
+	wireSent, wireRecv atomic.Int64 // Bytes through the net.Conn, after compression
+}
+
+var wireStats connStats
+
+func (s *connStats) Reset() {
+	s.rawSent.Store(0)
+	s.rawRecv.Store(0)
+	s.wireSent.Store(0)
+	s.wireRecv.Store(0)
+}
+
+// Ratio returns raw/wire bytes so far, or 0 before any traffic.
+func (s *connStats) Ratio() float64 {
+	wire := s.wireSent.Load() + s.wireRecv.Load()
+	if wire == 0 {
+		return 0
+	}
+	return float64(s.rawSent.Load()+s.rawRecv.Load()) / float64(wire)
+}
+
+// countingConn tallies wire bytes into wireStats.
+type countingConn struct{ net.Conn }
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	wireStats.wireRecv.Add(int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	wireStats.wireSent.Add(int64(n))
+	return n, err
+}
+
+// compressionLevel is applied to compressed connections; 0 keeps gorilla's default.
+var compressionLevel int
+
+// useSessionToken makes connectCmd trade the API key for a single-use token over
+// HTTP first (SESSION_TOKEN), so the key stays out of the /ws URL.
+var useSessionToken bool
+
+// traceWS logs every frame's full JSON to the debug log (TRACE_WS), clip contents included.
+var traceWS bool
+
+// dialer is websocket.DefaultDialer plus compression and wire byte counting.
+var dialer = &websocket.Dialer{
+	Proxy:             http.ProxyFromEnvironment,
+	HandshakeTimeout:  10 * time.Second, // Covers the TCP dial too; DIAL_TIMEOUT, set in main
+	EnableCompression: true, // Offer permessage-deflate; the server decides. Set from Config in main
+	NetDialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+		var d net.Dialer
+		c, err := d.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &countingConn{Conn: c}, nil
+	},
+}
+
+// fetchSessionToken asks the server's /auth/token endpoint, next to /ws, for a
+// token that authenticates one connection.
+func fetchSessionToken(wsURL *url.URL, apiKey string) (string, error) {
+	u := *wsURL
+	u.Scheme = strings.Replace(u.Scheme, "ws", "http", 1) // ws -> http, wss -> https
+	u.Path = strings.TrimSuffix(u.Path, "/ws") + "/auth/token"
+	u.RawQuery = ""
+
+	req, err := http.NewRequest(http.MethodPost, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-API-Key", apiKey)
+	client := &http.Client{
+		Timeout:   dialer.HandshakeTimeout,
+		Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: dialer.TLSClientConfig},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("server returned %s", resp.Status)
+	}
+	var body struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("decoding token response: %w", err)
+	}
+	return body.Token, nil
+}
+
 // It returns a tea.Msg indicating the result (ConnectionStatusMsg).
-func connectCmd(serverURL, apiKey, hostname string) tea.Cmd {
+// credential is the device credential from an earlier welcome, or "". It's sent
+// alongside the API key or the URL's token: the server takes whichever is valid.
+func connectCmd(serverURL, apiKey, credential, hostname, deviceID, channel, epoch string, resume int64) tea.Cmd {
 	return func() tea.Msg {
 		log.Printf("Attempting to connect to %s", serverURL)
 
@@ -30,32 +146,85 @@ func connectCmd(serverURL, apiKey, hostname string) tea.Cmd {
 		}
 
 		q := u.Query()
-		q.Set("apiKey", apiKey)
+		if useSessionToken {
+			token, err := fetchSessionToken(u, apiKey)
+			if err != nil {
+				log.Printf("Session token error: %v", err)
+				return ConnectionStatusMsg{Status: Disconnected, Err: fmt.Errorf("fetching session token: %w", err)}
+			}
+			q.Set("token", token)
+		} else {
+			q.Set("apiKey", apiKey)
+		}
+		if credential != "" {
+			q.Set("credential", credential) // A used-up pairing token in the URL is then ignored
+		}
 		q.Set("hostname", hostname)
+		q.Set("deviceId", deviceID)
+		q.Set("channel", channel) // Rejoin the same channel after a reconnect
+		q.Set("platform", runtime.GOOS)
+		if syncPrimary {
+			q.Set("primary", "1") // Otherwise the server doesn't relay selections to us
+		}
+		if len(deviceGroups) > 0 {
+			q.Set("groups", strings.Join(deviceGroups, ","))
+		}
+		if resume > 0 && epoch != "" {
+			// Only the history we missed, if the server still has it and hasn't restarted since
+			q.Set("resume", strconv.FormatInt(resume, 10))
+			q.Set("epoch", epoch)
+		}
 		u.RawQuery = q.Encode()
 
-		conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+		wireStats.Reset()
+		conn, resp, err := dialer.Dial(u.String(), nil)
+		if err != nil && resp != nil {
+			// The server explains refused handshakes (bad key, duplicate hostname) in the body
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+			resp.Body.Close()
+			if reason := strings.TrimSpace(string(body)); reason != "" {
+				err = fmt.Errorf("%w: %s", err, reason)
+			}
+		}
+		var netErr net.Error
+		if err != nil && (errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout()) {
+			err = fmt.Errorf("no answer from server within %s (DIAL_TIMEOUT): %w", dialer.HandshakeTimeout, err)
+		}
 		if err != nil {
 			log.Printf("Dial error: %v", err)
 			return ConnectionStatusMsg{Status: Disconnected, Err: fmt.Errorf("dial failed: %w", err)}
 		}
-		log.Println("WebSocket connected.")
+		// The server only echoes the extension back if it agreed to compress
+		compressed := strings.Contains(resp.Header.Get("Sec-WebSocket-Extensions"), "permessage-deflate")
+		log.Printf("WebSocket connected (compression: %v).", compressed)
+		conn.EnableWriteCompression(compressed)
+		if compressed && compressionLevel != 0 {
+			if err := conn.SetCompressionLevel(compressionLevel); err != nil {
+				log.Printf("Ignoring WS_COMPRESSION_LEVEL: %v", err)
+			}
+		}
 
 		_, cancel := context.WithCancel(context.Background())
 
-		return ConnectionStatusMsg{Status: Connected, Conn: conn, Cancel: cancel, Err: nil}
+		return ConnectionStatusMsg{Status: Connected, Conn: conn, Cancel: cancel, Compressed: compressed, Err: nil}
 	}
 }
 
 // listenWebSocketCmd starts the read and ping loops for the WebSocket connection.
 // It requires the Program instance to send messages back to the main Update loop.
-func listenWebSocketCmd(ctx context.Context, conn *websocket.Conn, p *tea.Program) tea.Cmd {
+// server is 0 for the main server, or 1 + the index into EXTRA_SERVERS; messages
+// and status changes are tagged with it.
+func listenWebSocketCmd(ctx context.Context, conn *websocket.Conn, p *tea.Program, server int) tea.Cmd {
 	return func() tea.Msg {
 		log.Println("Starting WebSocket listener...")
 		conn.SetReadLimit(maxMessageSize)
 		conn.SetReadDeadline(time.Now().Add(pongWait))
-		conn.SetPongHandler(func(string) error {
+		conn.SetPongHandler(func(appData string) error {
 			conn.SetReadDeadline(time.Now().Add(pongWait))
+			// Pings carry their send time, so the pong gives us the round trip
+			if sent, err := strconv.ParseInt(appData, 10, 64); err == nil && server == 0 { // Quality is only shown for the main server
+				p.Send(PingRTTMsg{RTT: time.Since(time.Unix(0, sent))})
+			}
 			return nil
 		})
 
@@ -76,15 +245,19 @@ func listenWebSocketCmd(ctx context.Context, conn *websocket.Conn, p *tea.Progra
 					if err != nil {
 						if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure, websocket.CloseNormalClosure) {
 							log.Printf("Read error: %v", err)
-							p.Send(ConnectionStatusMsg{Status: Disconnected, Err: fmt.Errorf("read error: %w", err)})
+							p.Send(ConnectionStatusMsg{Status: Disconnected, Err: fmt.Errorf("read error: %w", err), Server: server})
 						} else {
 							log.Printf("WebSocket closed normally or timed out.")
-							p.Send(ConnectionStatusMsg{Status: Disconnected, Err: nil})
+							p.Send(ConnectionStatusMsg{Status: Disconnected, Err: nil, Server: server})
 						}
 						return // Exit goroutine on error or close
 					}
 					// Reset read deadline on successful read
 					conn.SetReadDeadline(time.Now().Add(pongWait))
+					wireStats.rawRecv.Add(int64(len(message)))
+					if traceWS {
+						log.Printf("WS trace <- %s", message)
+					}
 
 					if messageType == websocket.TextMessage {
 						var msg BaseMessage
@@ -94,7 +267,7 @@ func listenWebSocketCmd(ctx context.Context, conn *websocket.Conn, p *tea.Progra
 							continue
 						}
 						// Send the parsed message to the main Update loop
-						p.Send(ReceivedServerMsg{Msg: msg})
+						p.Send(ReceivedServerMsg{Msg: msg, Server: server})
 
 					} else if messageType == websocket.BinaryMessage {
 						log.Printf("Received Binary Message (%d bytes) - Ignoring", len(message))
@@ -112,11 +285,18 @@ func listenWebSocketCmd(ctx context.Context, conn *websocket.Conn, p *tea.Progra
 				ticker.Stop()
 				log.Println("WebSocket ping loop finished.")
 			}()
+			ping := func() error {
+				wsWriteMu.Lock()
+				defer wsWriteMu.Unlock()
+				conn.SetWriteDeadline(time.Now().Add(writeWait))
+				return conn.WriteMessage(websocket.PingMessage, []byte(strconv.FormatInt(time.Now().UnixNano(), 10)))
+			}
+			ping() // Measure RTT right away rather than a ping period from now
 			for {
 				select {
 				case <-ticker.C:
-					conn.SetWriteDeadline(time.Now().Add(writeWait))
-					if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+					err := ping()
+					if err != nil {
 						log.Printf("Ping error: %v", err)
 						// Don't necessarily disconnect here, read loop will detect closure
 						return // Exit ping loop
@@ -144,15 +324,22 @@ func sendWebsocketMessageCmd(conn *websocket.Conn, message BaseMessage) tea.Cmd
 			return ErrorMsg{Err: fmt.Errorf("marshalling ws message: %w", err)}
 		}
 
+		wsWriteMu.Lock()
 		conn.SetWriteDeadline(time.Now().Add(writeWait))
 		err = conn.WriteMessage(websocket.TextMessage, msgBytes)
 		conn.SetWriteDeadline(time.Time{}) // Clear deadline immediately
+		wsWriteMu.Unlock()
 		if err != nil {
 			log.Printf("Websocket write error: %v", err)
 			// Return error, might trigger disconnect logic in model
 			return ErrorMsg{Err: fmt.Errorf("websocket write failed: %w", err)}
 		}
-		log.Printf("WS Sent: Type=%s", message.Type)
+		wireStats.rawSent.Add(int64(len(msgBytes)))
+		if traceWS {
+			log.Printf("WS trace -> %s", msgBytes)
+		} else {
+			log.Printf("WS Sent: Type=%s", message.Type)
+		}
 		return nil // Indicate success (no message needed back to Update)
 	}
 }
@@ -164,18 +351,37 @@ func sendWebsocketBinaryCmd(conn *websocket.Conn, data []byte) tea.Cmd {
 			return ErrorMsg{Err: fmt.Errorf("cannot send binary: not connected")}
 		}
 
+		wsWriteMu.Lock()
 		conn.SetWriteDeadline(time.Now().Add(writeWait)) // Adjust deadline based on chunk size?
 		err := conn.WriteMessage(websocket.BinaryMessage, data)
 		conn.SetWriteDeadline(time.Time{})
+		wsWriteMu.Unlock()
 		if err != nil {
 			log.Printf("Websocket binary write error: %v", err)
 			return ErrorMsg{Err: fmt.Errorf("websocket binary write failed: %w", err)}
 		}
+		wireStats.rawSent.Add(int64(len(data)))
 		log.Printf("WS Sent: Binary Data (%d bytes)", len(data))
 		return nil
 	}
 }
 
+// probeClipboard checks once at startup whether the local clipboard can be used at all.
+func probeClipboard() error {
+	if clipboard.Unsupported {
+		return errors.New("no clipboard utility found (xclip, xsel, wl-clipboard or termux-api)")
+	}
+	_, err := clipboard.ReadAll()
+	if err == nil {
+		return nil
+	}
+	// xclip and friends also fail on an empty clipboard, so only trust the error headless
+	if runtime.GOOS == "linux" && os.Getenv("DISPLAY") == "" && os.Getenv("WAYLAND_DISPLAY") == "" {
+		return fmt.Errorf("no X11 or Wayland display: %w", err)
+	}
+	return nil
+}
+
 // checkLocalClipboardCmd reads the local clipboard and sends a message if changed.
 func checkLocalClipboardCmd(lastContent string) tea.Cmd {
 	return func() tea.Msg {
@@ -188,20 +394,85 @@ func checkLocalClipboardCmd(lastContent string) tea.Cmd {
 		}
 
 		if currentClip != lastContent {
-			return LocalClipboardCheckedMsg{Content: currentClip, Changed: true, Err: nil}
+			return LocalClipboardCheckedMsg{Content: currentClip, Changed: true, Binary: !utf8.ValidString(currentClip), Err: nil}
 		}
 		return LocalClipboardCheckedMsg{Changed: false, Err: nil} // No change
 	}
 }
 
-// writeToClipboardCmd writes content to the local clipboard.
+// settleClipboardCmd re-reads the local clipboard once a change has had time to
+// settle, reporting it as Changed only if it still holds pending.
+func settleClipboardCmd(pending string) tea.Cmd {
+	return func() tea.Msg {
+		currentClip, err := clipboard.ReadAll()
+		if err != nil || currentClip != pending {
+			return LocalClipboardCheckedMsg{Settled: true, Err: err} // The next poll picks up the newer content
+		}
+		return LocalClipboardCheckedMsg{Content: currentClip, Changed: true, Binary: !utf8.ValidString(currentClip), Settled: true}
+	}
+}
+
+// resendClipboardCmd reads the local clipboard unconditionally, for a manual resend
+// or, with oneShot, a SyncNow. A ttl > 0 sends it as an expiring clip.
+func resendClipboardCmd(oneShot bool, ttl int) tea.Cmd {
+	return func() tea.Msg {
+		content, err := clipboard.ReadAll()
+		return ClipboardResendMsg{Content: content, OneShot: oneShot, TTLSeconds: ttl, Err: err}
+	}
+}
+
+// clearExpiredClipCmd empties the local clipboard if it still holds content, a
+// clip whose TTL ran out. Anything copied since is left alone.
+func clearExpiredClipCmd(content string) tea.Cmd {
+	return func() tea.Msg {
+		local, err := clipboard.ReadAll()
+		if err != nil || (local != content && local != localLineEndings(content)) {
+			return nil
+		}
+		if err := clipboard.WriteAll(""); err != nil {
+			return LogMsg(fmt.Sprintf("Could not clear the expired clip from the clipboard: %v", err))
+		}
+		return LogMsg("Cleared the expired clip from the local clipboard.")
+	}
+}
+
+// checkOverwriteCmd reads the local clipboard so the Model can decide whether
+// writing incoming content needs confirmation.
+func checkOverwriteCmd(incoming string) tea.Cmd {
+	return func() tea.Msg {
+		local, err := clipboard.ReadAll()
+		return OverwriteCheckedMsg{Incoming: incoming, Local: local, Err: err}
+	}
+}
+
+// readForAppendCmd reads the local clipboard so a received clip can be added to
+// the end of it in append mode.
+func readForAppendCmd(incoming string) tea.Cmd {
+	return func() tea.Msg {
+		local, err := clipboard.ReadAll()
+		return AppendReadMsg{Incoming: incoming, Local: local, Err: err}
+	}
+}
+
+// Clipboard writes can fail transiently while another app holds the clipboard
+const (
+	clipboardWriteAttempts = 3
+	clipboardWriteBackoff  = 100 * time.Millisecond
+)
+
+// writeToClipboardCmd writes content to the local clipboard, retrying briefly before giving up.
 func writeToClipboardCmd(content string) tea.Cmd {
 	return func() tea.Msg {
-		err := clipboard.WriteAll(content)
-		if err != nil {
-			log.Printf("Error writing to local clipboard: %v", err)
-			return ErrorMsg{fmt.Errorf("clipboard write failed: %w", err)}
+		var err error
+		for attempt := 1; attempt <= clipboardWriteAttempts; attempt++ {
+			if err = clipboard.WriteAll(content); err == nil {
+				return ClipboardWrittenMsg{}
+			}
+			log.Printf("Error writing to local clipboard (attempt %d/%d): %v", attempt, clipboardWriteAttempts, err)
+			if attempt < clipboardWriteAttempts {
+				time.Sleep(clipboardWriteBackoff)
+			}
 		}
-		return LogMsg("Local clipboard updated.") // Notify user via log
+		return ClipboardWrittenMsg{Err: fmt.Errorf("clipboard write failed: %w", err)}
 	}
 }
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// checkAdminAuth accepts the API key as an X-API-Key header or, like /ws, an apiKey query param.
+func checkAdminAuth(w http.ResponseWriter, r *http.Request) bool {
+	key := r.Header.Get("X-API-Key")
+	if key == "" {
+		key = r.URL.Query().Get("apiKey")
+	}
+	if key != currentAPIKey() {
+		log.Printf("Admin auth failed from %s", clientIP(r))
+		http.Error(w, "Forbidden: Invalid API Key", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// findClientByPrefix resolves a full client ID or a unique prefix of one, so
+// scripts don't need to paste whole UUIDs. Callers must hold mutex.
+func findClientByPrefix(prefix string) (*ClientInfo, error) {
+	if prefix == "" {
+		return nil, fmt.Errorf("missing client ID")
+	}
+	if c, ok := clients[prefix]; ok {
+		return c, nil
+	}
+	var match *ClientInfo
+	for id, c := range clients {
+		if strings.HasPrefix(id, prefix) {
+			if match != nil {
+				return nil, fmt.Errorf("ambiguous client ID prefix: %s", prefix)
+			}
+			match = c
+		}
+	}
+	if match == nil {
+		return nil, fmt.Errorf("unknown client: %s", prefix)
+	}
+	return match, nil
+}
+
+// handleListDevices returns the connected clients as JSON, with the inbound bytes
+// each sent in the last minute (see INBOUND_QUOTA_BYTES).
+func handleListDevices(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !checkAdminAuth(w, r) {
+		return
+	}
+	devices := snapshotDevices()
+	sort.Slice(devices, func(i, j int) bool { return devices[i].ConnectedAt.Before(devices[j].ConnectedAt) })
+	now := time.Now()
+	mutex.RLock()
+	for i := range devices {
+		if c, ok := clients[devices[i].ID]; ok {
+			devices[i].InboundBytes = c.inbound.total(now)
+		}
+	}
+	mutex.RUnlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(DeviceListData{Devices: devices})
+}
+
+// handleMetrics reports the stats counters plus the broadcast queue's depth and
+// how often senders found it full. A steadily rising broadcastFull means the hub
+// can't keep up and BROADCAST_BUFFER (or a slow client) needs a look; any
+// hubPanics are a bug, logged with a stack trace. fanOut times how long each
+// message took to write to all its recipients.
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !checkAdminAuth(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		StatsData
+		BroadcastQueued int         `json:"broadcastQueued"`
+		BroadcastBuffer int         `json:"broadcastBuffer"`
+		BroadcastFull   int64       `json:"broadcastFull"`
+		HubPanics       int64       `json:"hubPanics"`
+		FanOut          FanOutStats `json:"fanOut"`
+	}{currentStats(), len(broadcast), cap(broadcast), broadcastFull.Load(), hubPanics.Load(), fanOutTimes.stats()})
+}
+
+// handleTestClip injects a "clipd-test <time>" clip through the normal clipboard
+// path (current clip, history, broadcast to every device) to smoke-test propagation.
+// POST, optionally with ?channel=<name>.
+func handleTestClip(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !checkAdminAuth(w, r) {
+		return
+	}
+	content := "clipd-test " + time.Now().Format(time.RFC3339)
+	// No sender, so every client on the channel gets it
+	if _, err := acceptClip(ClipboardUpdateData{Content: content, Channel: r.URL.Query().Get("channel")}, ""); err != nil {
+		http.Error(w, fmt.Sprintf("Could not send test clip: %v", err), http.StatusConflict)
+		return
+	}
+	log.Printf("Admin: broadcast test clip %q", content)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"content": content})
+}
+
+// handleKick disconnects one client: POST ?id=<id or prefix>.
+func handleKick(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !checkAdminAuth(w, r) {
+		return
+	}
+	mutex.RLock()
+	client, err := findClientByPrefix(r.URL.Query().Get("id"))
+	if err == nil {
+		client.Conn.Close() // readLoop exits and unregisters
+	}
+	mutex.RUnlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	log.Printf("Admin: kicked %s (%s)", client.ID, client.Hostname)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleDisconnectOthers closes every connection except the client given by ?keep=<id or prefix>.
+// The read loops notice the closed sockets and unregister through the hub as usual.
+func handleDisconnectOthers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !checkAdminAuth(w, r) {
+		return
+	}
+	keep := r.URL.Query().Get("keep")
+	if keep == "" {
+		http.Error(w, "Missing keep parameter", http.StatusBadRequest)
+		return
+	}
+
+	mutex.RLock()
+	kept, err := findClientByPrefix(keep)
+	if err != nil {
+		mutex.RUnlock()
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	keep = kept.ID
+	var closed []string
+	for id, c := range clients {
+		if id != keep {
+			c.Conn.Close()
+			closed = append(closed, id)
+		}
+	}
+	mutex.RUnlock()
+
+	log.Printf("Admin: disconnected %d clients, kept %s", len(closed), keep)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"kept": keep, "disconnected": closed})
+}
+
+// handleSetDeviceSync turns clipboard sync on or off for a device: POST ?id=<id or prefix>&enabled=true|false.
+// The setting is remembered by hostname so it sticks when the device reconnects.
+func handleSetDeviceSync(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !checkAdminAuth(w, r) {
+		return
+	}
+	id := r.URL.Query().Get("id")
+	enabled, err := strconv.ParseBool(r.URL.Query().Get("enabled"))
+	if id == "" || err != nil {
+		http.Error(w, "Need id and enabled=true|false", http.StatusBadRequest)
+		return
+	}
+
+	mutex.Lock()
+	client, err := findClientByPrefix(id)
+	if err == nil {
+		client.SyncEnabled = enabled
+		if enabled {
+			delete(syncDisabled, client.Hostname)
+		} else {
+			syncDisabled[client.Hostname] = true
+		}
+	}
+	mutex.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	log.Printf("Admin: sync %s for %s (%s)", map[bool]string{true: "enabled", false: "disabled"}[enabled], client.ID, client.Hostname)
+	broadcastDeviceListUpdate() // So TUIs can show the badge
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleRenameDevice sets a device's display name: POST ?id=<id or prefix>&name=<name>.
+// An empty name clears it. Names are remembered by DeviceID, so they stick when the
+// device reconnects; a client that sends no deviceId is only renamed until then.
+func handleRenameDevice(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !checkAdminAuth(w, r) {
+		return
+	}
+	id := r.URL.Query().Get("id")
+	name := strings.TrimSpace(r.URL.Query().Get("name"))
+	if id == "" || len(name) > maxDisplayName {
+		http.Error(w, fmt.Sprintf("Need id, and a name of at most %d bytes", maxDisplayName), http.StatusBadRequest)
+		return
+	}
+
+	mutex.Lock()
+	client, err := findClientByPrefix(id)
+	if err == nil {
+		client.DisplayName = name
+		switch {
+		case client.DeviceID == "":
+		case name == "":
+			delete(deviceNames, client.DeviceID)
+		default:
+			deviceNames[client.DeviceID] = name
+		}
+	}
+	mutex.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if client.DeviceID == "" {
+		log.Printf("Admin: %s (%s) has no device ID; the name lasts until it reconnects", client.ID, client.Hostname)
+	} else if err := saveDeviceNames(); err != nil {
+		log.Printf("Error saving device names to %s: %v", deviceNamesFile, err)
+	}
+
+	log.Printf("Admin: renamed %s (%s) to %q", client.ID, client.Hostname, name)
+	broadcastDeviceListUpdate()
+	w.WriteHeader(http.StatusNoContent)
+}
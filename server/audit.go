@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const auditFlushInterval = 5 * time.Second
+
+// AuditEvent is one line of the audit log. It deliberately carries no clipboard
+// content or filenames, only metadata.
+type AuditEvent struct {
+	Time     time.Time `json:"time"`
+	Event    string    `json:"event"`
+	SenderID string    `json:"senderId"`
+	Hostname string    `json:"hostname"`
+	Length   int64     `json:"length"`
+}
+
+type auditLogger struct {
+	mu     sync.Mutex
+	file   *os.File
+	w      *bufio.Writer
+	closed bool // Set by Close; later events are dropped
+}
+
+// audit is nil unless AUDIT_LOG_FILE is set; all methods are no-ops on nil.
+var audit *auditLogger
+
+func openAuditLog(path string) (*auditLogger, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	a := &auditLogger{file: f, w: bufio.NewWriter(f)}
+	go a.flushLoop()
+	return a, nil
+}
+
+// Record appends an event to the log. Writes are buffered and flushed periodically.
+func (a *auditLogger) Record(event string, client *ClientInfo, length int64) {
+	if a == nil {
+		return
+	}
+	line, err := json.Marshal(AuditEvent{
+		Time:     time.Now().UTC(),
+		Event:    event,
+		SenderID: client.ID,
+		Hostname: client.Hostname,
+		Length:   length,
+	})
+	if err != nil {
+		log.Printf("Error marshalling audit event: %v", err)
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return
+	}
+	a.w.Write(line)
+	a.w.WriteByte('\n')
+}
+
+func (a *auditLogger) flushLoop() {
+	ticker := time.NewTicker(auditFlushInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		a.mu.Lock()
+		if a.closed {
+			a.mu.Unlock()
+			return
+		}
+		if err := a.w.Flush(); err != nil {
+			log.Printf("Error flushing audit log: %v", err)
+		}
+		a.mu.Unlock()
+	}
+}
+
+// Close flushes buffered events and closes the file, so events since the last
+// periodic flush aren't lost on shutdown.
+func (a *auditLogger) Close() error {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return nil
+	}
+	a.closed = true
+	err := a.w.Flush()
+	if cerr := a.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
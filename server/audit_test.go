@@ -0,0 +1,28 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAuditCloseFlushes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	a, err := openAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Record("clipboard_update", &ClientInfo{ID: "c1", Hostname: "laptop"}, 5)
+	if err := a.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"event":"clipboard_update"`) {
+		t.Errorf("event not flushed on Close; log is %q", b)
+	}
+	a.Record("clear_history", &ClientInfo{ID: "c1"}, 0) // Dropped, not a panic
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	defaultChannel   = "default"
+	maxSearchResults = 50 // Per search_history response
+)
+
+// clipChannel is one independently synced clipboard: its current clip and history.
+// Clients only send and receive clips on the channel they've selected.
+type clipChannel struct {
+	Clip     string
+	Encoding string // Encoding of Clip, forwarded as-is
+	Seq      int64  // Sequence of Clip
+	History  []HistoryEntryData
+}
+
+// channels is keyed by channel name and guarded by clipboardLock.
+var channels = make(map[string]*clipChannel)
+
+// maxChannels caps how many channels the server keeps state for (MAX_CHANNELS),
+// so clients can't exhaust memory by sending clips to ever new channel names.
+var maxChannels = 256
+
+// errTooManyChannels is returned by acceptClip for a clip that would create a
+// channel past maxChannels.
+var errTooManyChannels = errors.New("too many channels")
+
+// channelName maps the empty name older clients send to defaultChannel.
+func channelName(name string) string {
+	if name == "" {
+		return defaultChannel
+	}
+	return name
+}
+
+// getChannel returns the named channel, creating it on first use, or nil if that
+// would go past maxChannels. Callers must hold clipboardLock for writing.
+func getChannel(name string) *clipChannel {
+	ch, ok := channels[name]
+	if !ok {
+		if len(channels) >= maxChannels {
+			return nil
+		}
+		ch = &clipChannel{History: make([]HistoryEntryData, 0, maxHistorySize)}
+		channels[name] = ch
+	}
+	return ch
+}
+
+// clientChannel returns the channel a client has selected.
+func clientChannel(client *ClientInfo) string {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return client.Channel
+}
+
+// acceptClip makes data the current clip of its channel if it differs, records it
+// in history and broadcasts it to the channel's clients except senderID. With
+// DISABLE_HISTORY it only relays, and nothing about the content is kept after the broadcast.
+// A Resend of the current clip is broadcast again under its existing Seq. It returns
+// the clip's Seq, or 0 if it was already the current clip and not a resend, and
+// errTooManyChannels if the clip's channel is new and there's no room for it. A
+// clip with TTLSeconds, a resend included, is expired by expireClip once they're up.
+func acceptClip(data ClipboardUpdateData, senderID string) (int64, error) {
+	data.Channel = channelName(data.Channel)
+	clipboardLock.Lock()
+	defer clipboardLock.Unlock()
+	ch := getChannel(data.Channel)
+	if ch == nil {
+		return 0, errTooManyChannels
+	}
+	if !historyDisabled && ch.Clip == data.Content && ch.Encoding == data.Encoding {
+		if !data.Resend {
+			return 0, nil
+		}
+		data.Initial, data.Resend = false, false
+		data.Seq = ch.Seq
+		if data.TTLSeconds > 0 {
+			channel, content, encoding, seq := data.Channel, data.Content, data.Encoding, ch.Seq
+			time.AfterFunc(time.Duration(data.TTLSeconds)*time.Second, func() { expireClip(channel, content, encoding, seq) })
+		}
+		queueBroadcast(BaseMessage{Type: "clipboard_update", Data: data, SenderID: senderID})
+		return ch.Seq, nil
+	}
+	ch.Seq++
+	if !historyDisabled {
+		ch.Clip = data.Content
+		ch.Encoding = data.Encoding
+		// A cleared clipboard (SYNC_EMPTY_CLIPS) becomes the current clip, but isn't history
+		if ch.Clip != "" {
+			ch.History = append([]HistoryEntryData{{Content: ch.Clip, Encoding: ch.Encoding, Seq: ch.Seq}}, ch.History...)
+			if len(ch.History) > maxHistorySize {
+				ch.History = ch.History[:maxHistorySize]
+			}
+		}
+	}
+	totalClips.Add(1)
+	totalBytes.Add(int64(len(data.Content)))
+	if data.TTLSeconds > 0 && data.Content != "" {
+		channel, content, encoding, seq := data.Channel, data.Content, data.Encoding, ch.Seq
+		time.AfterFunc(time.Duration(data.TTLSeconds)*time.Second, func() { expireClip(channel, content, encoding, seq) })
+	}
+
+	data.Initial, data.Resend = false, false
+	data.Seq = ch.Seq
+	queueBroadcast(BaseMessage{Type: "clipboard_update", Data: data, SenderID: senderID})
+	return ch.Seq, nil
+}
+
+// clipDigest is a short hash of clip content (as sent, i.e. possibly base64), used
+// to match a clipboard_ack to the clip without sending the content back.
+func clipDigest(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:8])
+}
+
+// historyPage copies up to limit history entries of a channel starting at offset,
+// clamped to the history's bounds. A limit <= 0 means historyPageSize.
+func historyPage(channel string, offset, limit int) ClipboardHistoryData {
+	if limit <= 0 {
+		limit = historyPageSize
+	}
+	clipboardLock.RLock()
+	defer clipboardLock.RUnlock()
+	var history []HistoryEntryData
+	if ch, ok := channels[channel]; ok {
+		history = ch.History
+	}
+	total := len(history)
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > total {
+		offset = total
+	}
+	end := offset + limit
+	if end > total {
+		end = total
+	}
+	page := make([]HistoryEntryData, end-offset)
+	copy(page, history[offset:end])
+	return ClipboardHistoryData{Channel: channel, History: page, Offset: offset, Total: total}
+}
+
+// historyBefore copies up to limit history entries of a channel older than seq,
+// newest first. A limit <= 0 means historyPageSize.
+func historyBefore(channel string, seq int64, limit int) ClipboardHistoryData {
+	if limit <= 0 {
+		limit = historyPageSize
+	}
+	clipboardLock.RLock()
+	defer clipboardLock.RUnlock()
+	var history []HistoryEntryData
+	if ch, ok := channels[channel]; ok {
+		history = ch.History
+	}
+	start := 0 // History is newest first, so older entries are a suffix
+	for start < len(history) && history[start].Seq >= seq {
+		start++
+	}
+	end := min(start+limit, len(history))
+	page := make([]HistoryEntryData, end-start)
+	copy(page, history[start:end])
+	return ClipboardHistoryData{Channel: channel, History: page, Offset: start, Before: seq, Total: len(history)}
+}
+
+// historyRemoval tells a channel's clients an entry is gone. It carries no page:
+// the rest of their lists is still right, including older pages they loaded.
+func historyRemoval(channel, content, encoding string) ClipboardHistoryData {
+	clipboardLock.RLock()
+	total := 0
+	if ch, ok := channels[channel]; ok {
+		total = len(ch.History)
+	}
+	clipboardLock.RUnlock()
+	return ClipboardHistoryData{Channel: channel, History: []HistoryEntryData{}, Total: total, Removed: content, RemovedEncoding: encoding}
+}
+
+// searchHistory returns a channel's history entries containing query, ignoring case.
+func searchHistory(channel, query string) SearchResultsData {
+	result := SearchResultsData{Query: query, Results: []HistoryEntryData{}}
+	query = strings.ToLower(query)
+	clipboardLock.RLock()
+	defer clipboardLock.RUnlock()
+	ch, ok := channels[channel]
+	if !ok {
+		return result
+	}
+	for _, h := range ch.History {
+		if !strings.Contains(strings.ToLower(h.Content), query) {
+			continue
+		}
+		if len(result.Results) == maxSearchResults {
+			result.Truncated = true
+			break
+		}
+		result.Results = append(result.Results, h)
+	}
+	return result
+}
+
+// deleteHistoryEntry removes every entry with the given content and encoding from
+// a channel's history, reporting whether anything was removed.
+func deleteHistoryEntry(channel, content, encoding string) bool {
+	clipboardLock.Lock()
+	defer clipboardLock.Unlock()
+	ch, ok := channels[channel]
+	if !ok {
+		return false
+	}
+	kept := ch.History[:0]
+	for _, h := range ch.History {
+		if h.Content != content || h.Encoding != encoding {
+			kept = append(kept, h)
+		}
+	}
+	removed := len(kept) != len(ch.History)
+	ch.History = kept
+	return removed
+}
+
+// expireClip removes the history entry a clip with a TTL was stored as, and the
+// current clip if it's still that one, then tells the channel's clients to drop it
+// from their lists. If the same content was copied again since, the newer copy
+// stays, and so does everyone's entry for it. Clients are told even with
+// DISABLE_HISTORY, since they keep their own histories.
+func expireClip(channel, content, encoding string, seq int64) {
+	clipboardLock.Lock()
+	recopied := false
+	if ch, ok := channels[channel]; ok {
+		kept := ch.History[:0]
+		for _, h := range ch.History {
+			switch {
+			case h.Content == content && h.Seq == seq:
+			case h.Content == content && h.Seq > seq:
+				recopied = true
+				kept = append(kept, h)
+			default:
+				kept = append(kept, h)
+			}
+		}
+		ch.History = kept
+		if ch.Seq == seq && ch.Clip == content {
+			ch.Clip, ch.Encoding = "", ""
+		}
+	}
+	clipboardLock.Unlock()
+	if recopied {
+		return
+	}
+	log.Printf("Clip %d on channel %q expired", seq, channel)
+	page := historyRemoval(channel, content, encoding)
+	page.Expired = true
+	queueBroadcast(BaseMessage{Type: "clipboard_history", Data: page})
+}
+
+// clearChannel forgets a channel's current clip and history.
+func clearChannel(channel string) {
+	clipboardLock.Lock()
+	defer clipboardLock.Unlock()
+	delete(channels, channel) // Drops the history's backing array too
+}
+
+// currentClip returns a channel's current clip; Content is "" if it has none.
+func currentClip(channel string) ClipboardUpdateData {
+	clipboardLock.RLock()
+	defer clipboardLock.RUnlock()
+	current := ClipboardUpdateData{Channel: channel}
+	if ch, ok := channels[channel]; ok {
+		current.Content, current.Encoding, current.Seq = ch.Clip, ch.Encoding, ch.Seq
+	}
+	return current
+}
+
+// historySince returns the history entries of a channel newer than seq, for a
+// client resuming after a reconnect. It reports false when the delta can't be
+// trusted and the client needs the usual first page instead: seq is 0, the server
+// restarted since (seq is ahead of the channel), entries after seq were already
+// trimmed, or the delta is longer than a page. Deletes made while the client was
+// away aren't in the delta and stay in its list until a full resync.
+func historySince(channel string, seq int64) (ClipboardHistoryData, bool) {
+	if seq <= 0 || historyDisabled {
+		return ClipboardHistoryData{}, false
+	}
+	clipboardLock.RLock()
+	defer clipboardLock.RUnlock()
+	ch, ok := channels[channel]
+	if !ok || seq > ch.Seq {
+		return ClipboardHistoryData{}, false
+	}
+	n := 0 // History is newest first, so the delta is a prefix
+	for n < len(ch.History) && ch.History[n].Seq > seq {
+		n++
+	}
+	if n > historyPageSize || (n == len(ch.History) && n > 0 && ch.History[n-1].Seq != seq+1) {
+		return ClipboardHistoryData{}, false
+	}
+	delta := make([]HistoryEntryData, n)
+	copy(delta, ch.History[:n])
+	return ClipboardHistoryData{Channel: channel, History: delta, Total: len(ch.History), Since: seq}, true
+}
+
+// sendChannelState sends a client the current clip and first history page of its
+// channel, on connect and after it switches channels. A client reconnecting with
+// resume, the last seq it saw, gets only what it missed when historySince allows.
+func sendChannelState(client *ClientInfo, channel string, resume int64) {
+	page, resumed := historySince(channel, resume)
+	current := currentClip(channel)
+	current.Initial = true
+	if current.Content != "" && !(resumed && current.Seq <= resume) {
+		msg := BaseMessage{Type: "clipboard_update", Data: current}
+		msgBytes, _ := json.Marshal(msg)
+		writeToClient(client, websocket.TextMessage, msgBytes)
+	}
+
+	if !historyDisabled {
+		if !resumed {
+			page = historyPage(channel, 0, historyPageSize)
+		}
+		if len(page.History) > 0 {
+			msg := BaseMessage{Type: "clipboard_history", Data: page}
+			msgBytes, _ := json.Marshal(msg)
+			writeToClient(client, websocket.TextMessage, msgBytes)
+		}
+	}
+}
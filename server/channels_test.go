@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestBinaryClipHistoryKeepsEncoding(t *testing.T) {
+	const channel = "test-binary"
+	raw := "\xff\xfe binary"
+	content := base64.StdEncoding.EncodeToString([]byte(raw))
+	if seq, err := acceptClip(ClipboardUpdateData{Content: content, Encoding: "base64", Channel: channel}, "sender"); seq == 0 || err != nil {
+		t.Fatalf("clip was not accepted: %v", err)
+	}
+	defer clearChannel(channel)
+
+	page := historyPage(channel, 0, historyPageSize)
+	if len(page.History) != 1 {
+		t.Fatalf("got %d history entries, want 1", len(page.History))
+	}
+	h := page.History[0]
+	if h.Content != content || h.Encoding != "base64" {
+		t.Fatalf("history entry = %+v, want content %q with base64 encoding", h, content)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(h.Content)
+	if err != nil || string(decoded) != raw {
+		t.Fatalf("entry decodes to %q, %v; want %q", decoded, err, raw)
+	}
+
+	// The same text sent as UTF-8 is a different entry
+	if deleteHistoryEntry(channel, content, "") {
+		t.Error("deleted the base64 entry without its encoding")
+	}
+	if !deleteHistoryEntry(channel, content, "base64") {
+		t.Error("could not delete the base64 entry")
+	}
+}
+
+func TestAcceptClipCapsChannels(t *testing.T) {
+	defer func(old int) { maxChannels = old }(maxChannels)
+	clipboardLock.RLock()
+	maxChannels = len(channels) + 1
+	clipboardLock.RUnlock()
+	defer clearChannel("test-cap-a")
+	defer clearChannel("test-cap-b")
+
+	if _, err := acceptClip(ClipboardUpdateData{Content: "a", Channel: "test-cap-a"}, ""); err != nil {
+		t.Fatalf("first new channel: %v", err)
+	}
+	if _, err := acceptClip(ClipboardUpdateData{Content: "b", Channel: "test-cap-b"}, ""); err != errTooManyChannels {
+		t.Fatalf("channel past the cap: got %v, want errTooManyChannels", err)
+	}
+	if _, err := acceptClip(ClipboardUpdateData{Content: "a2", Channel: "test-cap-a"}, ""); err != nil {
+		t.Fatalf("existing channel at the cap: %v", err)
+	}
+}
+
+func TestHistoryBeforeSurvivesDeletes(t *testing.T) {
+	const channel = "test-cursor"
+	defer clearChannel(channel)
+	var seqs []int64
+	for _, c := range []string{"a", "b", "c", "d", "e"} {
+		seq, err := acceptClip(ClipboardUpdateData{Content: c, Channel: channel}, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		seqs = append(seqs, seq)
+	}
+	// A client holding e, d and c asks for what's below c
+	cursor := seqs[2]
+	if !deleteHistoryEntry(channel, "e", "") {
+		t.Fatal("could not delete e")
+	}
+	page := historyBefore(channel, cursor, 10)
+	if len(page.History) != 2 || page.History[0].Content != "b" || page.History[1].Content != "a" {
+		t.Errorf("page below c after deleting e = %+v, want b, a", page.History)
+	}
+	if page.Before != cursor || page.Total != 4 {
+		t.Errorf("page Before=%d Total=%d, want %d and 4", page.Before, page.Total, cursor)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Limits on the shape of client JSON, checked before anything is decoded. Real
+// messages are a few levels deep with a handful of fields; the read limit alone
+// would still let a client make the server build huge or deeply nested values.
+const (
+	maxJSONDepth  = 16   // Nested objects and arrays
+	maxJSONValues = 4096 // Tokens in one message: keys, values and brackets
+	maxJSONKey    = 64   // Bytes in an object key
+)
+
+// strictJSON is STRICT_JSON: reject messages with fields this server doesn't
+// know. Off by default, so newer clients keep working against older servers.
+var strictJSON bool
+
+// decodeMessage parses a client frame into a BaseMessage, refusing payloads
+// whose shape is out of bounds before allocating anything for them.
+func decodeMessage(p []byte) (BaseMessage, error) {
+	var msg BaseMessage
+	if err := checkJSONShape(p); err != nil {
+		return msg, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(p))
+	if strictJSON {
+		dec.DisallowUnknownFields()
+	}
+	err := dec.Decode(&msg)
+	return msg, err
+}
+
+// checkJSONShape walks p's tokens against the maxJSON limits. It also insists on
+// exactly one top-level value, as json.Unmarshal did.
+func checkJSONShape(p []byte) error {
+	type level struct {
+		object  bool
+		wantKey bool // In an object, whether the next token is a key
+	}
+	var stack []level
+	dec := json.NewDecoder(bytes.NewReader(p))
+	dec.UseNumber() // Numbers are only counted, so don't parse them
+	values := 0
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			if values == 0 {
+				return errors.New("empty message")
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if values > 0 && len(stack) == 0 {
+			return errors.New("data after the message")
+		}
+		if values++; values > maxJSONValues {
+			return fmt.Errorf("more than %d values", maxJSONValues)
+		}
+		top := len(stack) - 1
+		if d, ok := tok.(json.Delim); ok {
+			if d == '}' || d == ']' {
+				stack = stack[:top]
+				continue
+			}
+			if len(stack) == maxJSONDepth {
+				return fmt.Errorf("nested deeper than %d levels", maxJSONDepth)
+			}
+			if top >= 0 && stack[top].object {
+				stack[top].wantKey = true // This container is the value
+			}
+			stack = append(stack, level{object: d == '{', wantKey: true})
+			continue
+		}
+		if top >= 0 && stack[top].object {
+			if k, ok := tok.(string); ok && stack[top].wantKey && len(k) > maxJSONKey {
+				return fmt.Errorf("object key longer than %d bytes", maxJSONKey)
+			}
+			stack[top].wantKey = !stack[top].wantKey
+		}
+	}
+}
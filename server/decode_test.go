@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDecodeMessage(t *testing.T) {
+	longKey := strings.Repeat("k", maxJSONKey+1)
+	for _, tc := range []struct {
+		name   string
+		frame  string
+		strict bool
+		ok     bool
+	}{
+		{"clipboard update", `{"type":"clipboard_update","data":{"content":"hello","channel":"work","ttlSeconds":60}}`, true, true},
+		{"max depth", `{"type":"x","data":` + strings.Repeat("[", maxJSONDepth-1) + strings.Repeat("]", maxJSONDepth-1) + `}`, false, true},
+		{"too deep", `{"type":"x","data":` + strings.Repeat("[", maxJSONDepth) + strings.Repeat("]", maxJSONDepth) + `}`, false, false},
+		{"too many values", `{"type":"x","data":[` + strings.Repeat("0,", maxJSONValues) + `0]}`, false, false},
+		{"long key", `{"type":"x","` + longKey + `":1}`, false, false},
+		{"long key after object", `{"data":{"a":1},"` + longKey + `":1}`, false, false},
+		{"long key after array", `{"data":[1,2],"` + longKey + `":1}`, false, false},
+		{"long value", `{"data":{},"type":"` + longKey + `"}`, false, true},
+		{"trailing data", `{"type":"x"}{"type":"y"}`, false, false},
+		{"trailing scalar", `{"type":"x"} 1`, false, false},
+		{"empty", ``, false, false},
+		{"whitespace", "  \n", false, false},
+		{"unknown field", `{"type":"clipboard_update","data":{},"extra":1}`, false, true},
+		{"unknown field, strict", `{"type":"clipboard_update","data":{},"extra":1}`, true, false},
+	} {
+		strictJSON = tc.strict
+		_, err := decodeMessage([]byte(tc.frame))
+		if (err == nil) != tc.ok {
+			t.Errorf("%s: got %v, want ok=%v", tc.name, err, tc.ok)
+		}
+	}
+	strictJSON = false
+}
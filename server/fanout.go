@@ -0,0 +1,79 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// slowFanOut is how long one fan-out may take before it's logged as slow
+// (SLOW_BROADCAST_MS). 0 disables the warning; the histogram is kept regardless.
+var slowFanOut = 500 * time.Millisecond
+
+// fanOutBounds are the histogram's bucket upper bounds. Writes have a 10s
+// deadline, so a fan-out held up by a stuck client lands in the last ones.
+var fanOutBounds = []time.Duration{
+	time.Millisecond, 5 * time.Millisecond, 10 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 500 * time.Millisecond, time.Second, 5 * time.Second, 10 * time.Second,
+}
+
+// fanOutTimes records how long the hub spends writing each message to all its
+// recipients. Written by the hub, read by /admin/metrics.
+var fanOutTimes fanOutHistogram
+
+type fanOutHistogram struct {
+	mu      sync.Mutex
+	buckets [10]int64 // One per fanOutBounds entry, then everything slower
+	count   int64
+	sum     time.Duration
+	max     time.Duration
+}
+
+// FanOutStats is the histogram as reported by /admin/metrics. Buckets are
+// cumulative and in order, the way Prometheus lays them out.
+type FanOutStats struct {
+	Count   int64          `json:"count"`
+	MeanMS  float64        `json:"meanMs"`
+	MaxMS   float64        `json:"maxMs"`
+	Buckets []FanOutBucket `json:"buckets"`
+}
+
+type FanOutBucket struct {
+	LE    string `json:"le"` // Upper bound, e.g. "10ms" or "+Inf"
+	Count int64  `json:"count"`
+}
+
+func (h *fanOutHistogram) observe(d time.Duration) {
+	i := 0
+	for i < len(fanOutBounds) && d > fanOutBounds[i] {
+		i++
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.buckets[i]++
+	h.count++
+	h.sum += d
+	h.max = max(h.max, d)
+}
+
+func (h *fanOutHistogram) stats() FanOutStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := FanOutStats{Count: h.count, MaxMS: ms(h.max), Buckets: make([]FanOutBucket, 0, len(h.buckets))}
+	if h.count > 0 {
+		s.MeanMS = ms(h.sum) / float64(h.count)
+	}
+	var cum int64
+	for i, n := range h.buckets {
+		cum += n
+		le := "+Inf"
+		if i < len(fanOutBounds) {
+			le = fanOutBounds[i].String()
+		}
+		s.Buckets = append(s.Buckets, FanOutBucket{LE: le, Count: cum})
+	}
+	return s
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -6,6 +6,5 @@ require (
 	github.com/google/uuid v1.6.0
 	github.com/gorilla/websocket v1.5.1
 	github.com/joho/godotenv v1.5.1
+	golang.org/x/net v0.17.0
 )
-
-require golang.org/x/net v0.17.0 // indirect
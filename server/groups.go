@@ -0,0 +1,38 @@
+package main
+
+import "strings"
+
+const (
+	maxGroupName = 32
+	maxGroups    = 8 // Per device
+)
+
+// parseGroups reads the comma-separated groups a client joins on connect, e.g.
+// "phones,work". Names are trimmed; empty, overlong and repeated ones are dropped.
+func parseGroups(s string) []string {
+	var groups []string
+	seen := make(map[string]bool)
+	for _, g := range strings.Split(s, ",") {
+		g = strings.TrimSpace(g)
+		if g == "" || len(g) > maxGroupName || seen[g] {
+			continue
+		}
+		seen[g] = true
+		groups = append(groups, g)
+		if len(groups) == maxGroups {
+			break
+		}
+	}
+	return groups
+}
+
+// inGroup reports whether the client joined group. Groups are fixed at connect, so
+// this needs no lock.
+func (c *ClientInfo) inGroup(group string) bool {
+	for _, g := range c.Groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
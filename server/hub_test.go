@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestMain(m *testing.M) {
+	broadcast = make(chan BaseMessage, broadcastBuffer)
+	os.Exit(m.Run())
+}
+
+// dialTestClient connects a real WebSocket pair and returns the server side,
+// wrapped in a ClientInfo, and the client side to read from.
+func dialTestClient(t *testing.T, id string) (*ClientInfo, *websocket.Conn) {
+	t.Helper()
+	serverSide := make(chan *websocket.Conn, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		serverSide <- ws
+	}))
+	t.Cleanup(srv.Close)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &ClientInfo{ID: id, Conn: <-serverSide, SyncEnabled: true}, conn
+}
+
+func readType(t *testing.T, conn *websocket.Conn) string {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, p, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	var msg BaseMessage
+	if err := json.Unmarshal(p, &msg); err != nil {
+		t.Fatalf("unmarshal %q: %v", p, err)
+	}
+	return msg.Type
+}
+
+func TestHubSurvivesPanickingWrite(t *testing.T) {
+	good, conn := dialTestClient(t, "good")
+	// A nil Conn makes every write to this client panic
+	bad := &ClientInfo{ID: "bad", SyncEnabled: true}
+
+	mutex.Lock()
+	clients = map[string]*ClientInfo{good.ID: good, bad.ID: bad}
+	mutex.Unlock()
+	panicsBefore := hubPanics.Load()
+
+	go superviseHub()
+
+	broadcast <- BaseMessage{Type: "stats", Data: StatsData{}}
+	if got := readType(t, conn); got != "stats" {
+		t.Fatalf("first broadcast: got %q, want stats", got)
+	}
+	broadcast <- BaseMessage{Type: "server_restarting", Data: RestartData{}}
+	// The hub may also send a device_list after dropping the bad client
+	for {
+		got := readType(t, conn)
+		if got == "server_restarting" {
+			break
+		}
+		if got != "device_list" {
+			t.Fatalf("second broadcast: got %q", got)
+		}
+	}
+	if hubPanics.Load() == panicsBefore {
+		t.Error("panicking write was not counted in hubPanics")
+	}
+}
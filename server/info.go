@@ -0,0 +1,69 @@
+package main
+
+import (
+	"runtime/debug"
+	"strings"
+)
+
+// version is reported in server_info. Release builds set it with
+// -ldflags "-X main.version=v1.2.3"; otherwise it's the module version, if any.
+var version = ""
+
+// messageTypes lists the client message types readLoop handles, for server_info.
+// Keep it in step with readLoop's switch.
+var messageTypes = []string{
+	"clipboard_update", "primary_update", "request_devices", "request_history",
+	"delete_history_entry", "clear_history", "search_history", "request_clip",
+	"set_channel", "file_offer", "file_ack", "file_chunk", "file_cancel", "auth_response", "server_info",
+}
+
+// ServerInfoData answers server_info, so clients can adapt to what this server
+// supports rather than finding out from errors.
+type ServerInfoData struct {
+	Version      string   `json:"version"`
+	MessageTypes []string `json:"messageTypes"`
+	MaxClipBytes int      `json:"maxClipBytes"` // 0 means no limit
+	HistorySize  int      `json:"historySize"`
+	History      bool     `json:"history"`     // False with DISABLE_HISTORY
+	Compression  bool     `json:"compression"` // permessage-deflate offered
+	Channels     bool     `json:"channels"`    // False with DISABLE_CHANNELS
+	Transfers    bool     `json:"transfers"`   // False with DISABLE_TRANSFERS
+}
+
+// messageTypeEnabled reports whether the server's configuration allows a message
+// type; DISABLE_CHANNELS and DISABLE_TRANSFERS turn some off.
+func messageTypeEnabled(msgType string) bool {
+	if channelsDisabled && msgType == "set_channel" {
+		return false
+	}
+	return !(transfersDisabled && strings.HasPrefix(msgType, "file_"))
+}
+
+func serverVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		return info.Main.Version
+	}
+	return "unknown"
+}
+
+func serverInfo() ServerInfoData {
+	types := make([]string, 0, len(messageTypes))
+	for _, t := range messageTypes {
+		if messageTypeEnabled(t) {
+			types = append(types, t)
+		}
+	}
+	return ServerInfoData{
+		Version:      serverVersion(),
+		MessageTypes: types,
+		MaxClipBytes: maxClipBytes,
+		HistorySize:  maxHistorySize,
+		History:      !historyDisabled,
+		Compression:  upgrader.EnableCompression,
+		Channels:     !channelsDisabled,
+		Transfers:    !transfersDisabled,
+	}
+}
@@ -1,23 +1,46 @@
 package main
 
 import (
+	"bytes"
+	"compress/flate"
 	"encoding/json"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"path"
+	"runtime/debug"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/joho/godotenv"
 )
 
-const maxHistorySize = 20
+const historyPageSize = 20 // Entries per clipboard_history message unless the client asks otherwise
 
 type ClientInfo struct {
-	ID       string `json:"id"`
-	Conn     *websocket.Conn `json:"-"`
-	Hostname string `json:"hostname"`
+	ID          string `json:"id"`                 // Per connection, assigned by the server
+	DeviceID    string `json:"deviceId,omitempty"` // Stable across reconnects, chosen by the client
+	Conn        *websocket.Conn `json:"-"`
+	Hostname    string `json:"hostname"`
+	DisplayName string `json:"displayName,omitempty"` // Guarded by mutex; set via /admin/rename and kept per DeviceID
+	SyncEnabled bool   `json:"syncEnabled"` // Guarded by mutex; toggled via /admin/device-sync
+	Channel     string `json:"channel"`     // Guarded by mutex; changed via set_channel
+	RemoteIP    string `json:"remoteIp"`
+	Platform    string `json:"platform,omitempty"` // Client's GOOS, for display only
+	Primary     bool   `json:"primary,omitempty"`  // Wants primary_update relays (Linux primary selection)
+	Groups      []string `json:"groups,omitempty"` // Device groups joined on connect, for TargetGroup pushes and offers
+	ConnectedAt time.Time `json:"connectedAt"`
+	InboundBytes int64   `json:"inboundBytes,omitempty"` // Last minute's usage; only filled in by /admin/devices
+	inbound     byteWindow
+	authDeadline time.Time // Guarded by mutex; set while an auth_challenge is unanswered
+	writeMu     sync.Mutex // Serializes writes: gorilla/websocket allows one writer at a time
 }
 
 type BaseMessage struct {
@@ -27,45 +50,176 @@ type BaseMessage struct {
 }
 
 type ClipboardUpdateData struct {
-	Content string `json:"content"`
+	Content  string `json:"content"`
+	Encoding string `json:"encoding,omitempty"` // Opaque to the server; "base64" for non-UTF8 clips
+	Channel  string `json:"channel,omitempty"`  // Clipboard channel; "" means defaultChannel
+	Resend   bool   `json:"resend,omitempty"`   // Broadcast even if it's already the current clip
+	Initial  bool   `json:"initial,omitempty"`  // Set on the channel's current clip sent on connect or channel switch
+	Seq      int64  `json:"seq,omitempty"`      // Assigned by the server when the clip is accepted
+	TargetGroup string `json:"targetGroup,omitempty"` // Only for devices in this group; relayed without history
+	TTLSeconds  int    `json:"ttlSeconds,omitempty"`  // Removed from history everywhere this long after it's accepted
 }
 
+// ClipboardHistoryData is one page of history, newest first.
 type ClipboardHistoryData struct {
-	History []string `json:"history"`
+	Channel string             `json:"channel,omitempty"`
+	History []HistoryEntryData `json:"history"`
+	Offset  int                `json:"offset"` // Index of History[0] in the full history
+	Before  int64              `json:"before,omitempty"` // Set in reply to a request_history with Before: History holds entries older than this seq
+	Total   int                `json:"total"`  // Size of the full history
+	Removed string             `json:"removed,omitempty"` // Set when the history is re-sent after a delete_history_entry
+	RemovedEncoding string     `json:"removedEncoding,omitempty"` // Encoding of Removed
+	Cleared bool               `json:"cleared,omitempty"` // Set after clear_history; clients drop their lists too
+	Since   int64              `json:"since,omitempty"`   // Set on a resumed connect: History holds only entries newer than this seq
+	Expired bool               `json:"expired,omitempty"` // Removed was a clip whose TTLSeconds ran out, not a delete
+}
+
+// ChannelData is the payload of set_channel.
+type ChannelData struct {
+	Channel string `json:"channel"`
+}
+
+// HistoryRequestData is the optional payload of request_history. Without it the first page is sent.
+// Before, the seq of the oldest entry a client holds, asks for the page below it; unlike
+// Offset it still points at the right place after entries above it are added or deleted.
+type HistoryRequestData struct {
+	Offset int   `json:"offset"`
+	Before int64 `json:"before,omitempty"`
+	Limit  int   `json:"limit"`
+}
+
+// SearchHistoryData is the payload of search_history.
+type SearchHistoryData struct {
+	Query string `json:"query"`
+}
+
+// SearchResultsData answers search_history with up to maxSearchResults entries, newest first.
+type SearchResultsData struct {
+	Query     string             `json:"query"`
+	Results   []HistoryEntryData `json:"results"`
+	Truncated bool               `json:"truncated,omitempty"` // More entries matched than were returned
+}
+
+// HistoryEntryData is one history entry. delete_history_entry identifies entries by
+// Content rather than index or Seq, so two clients deleting at once can't remove the wrong one.
+type HistoryEntryData struct {
+	Content  string `json:"content"`
+	Encoding string `json:"encoding,omitempty"` // The clip's Encoding, kept so clients can decode binary entries
+	Seq      int64  `json:"seq,omitempty"`
 }
 
 type DeviceListData struct {
 	Devices []ClientInfo `json:"devices"`
 }
 
+// WelcomeData tells a client its own ID; sent once, right after register.
+type WelcomeData struct {
+	ID         string `json:"id"`
+	Credential string `json:"credential,omitempty"` // Issued to a device that connected with a session token; see deviceCredentials
+	Epoch      string `json:"epoch"`                // Clients pass it back with resume; see epoch
+}
+
+// ClipAckData tells the sender of a clipboard_update the Seq its clip was given, so it
+// can number the history entry it added locally. Digest identifies the clip (see clipDigest).
+type ClipAckData struct {
+	Digest string `json:"digest"`
+	Seq    int64  `json:"seq"`
+}
+
+// ErrorData tells a client one of its messages was rejected.
+type ErrorData struct {
+	Code    string `json:"code"` // One of the err* codes below
+	Message string `json:"message"`
+}
+
+// Codes for ErrorData, so clients can react to a rejection without parsing Message.
+const (
+	errInvalidMessage = "invalid_message" // Malformed JSON or a payload that failed validation
+	errClipTooLarge   = "clip_too_large"  // Over MAX_CLIP_BYTES
+	errSyncDisabled   = "sync_disabled"   // The operator turned sync off for this device
+	errUnknownType    = "unknown_type"
+	errUnsupported    = "unsupported" // e.g. binary frames
+	errQuotaExceeded  = "quota_exceeded" // Over INBOUND_QUOTA_BYTES this minute; messages are dropped until it frees up
+	errAuthRequired   = "auth_required"  // The API key changed; only auth_response is accepted until it's answered
+	errAuthFailed     = "auth_failed"    // auth_response had the wrong key; the connection is closed
+	errChannelLimit   = "channel_limit"  // A clip would create a channel past MAX_CHANNELS
+)
+
+type StatsData struct {
+	Clips   int64 `json:"clips"`
+	Bytes   int64 `json:"bytes"`
+	Devices int   `json:"devices"`
+}
+
 type FileOfferData struct {
-	Filename string `json:"filename"`
-	Filesize int64  `json:"filesize"`
-	TargetID string `json:"targetId,omitempty"`
+	TransferID  string `json:"transferId"`
+	Filename    string `json:"filename"`
+	Filesize    int64  `json:"filesize"`
+	TargetID    string `json:"targetId,omitempty"`
+	TargetGroup string `json:"targetGroup,omitempty"` // Offered to this group's devices when TargetID is empty
+	AsClipboard bool   `json:"asClipboard,omitempty"`
 }
 
 type FileAckData struct {
-	Filename string `json:"filename"`
-	Allow    bool   `json:"allow"`
-	SourceID string `json:"sourceId"`
+	TransferID string `json:"transferId"`
+	Filename   string `json:"filename"`
+	Allow      bool   `json:"allow"`
+	Reason     string `json:"reason,omitempty"` // Why an offer was rejected without asking, if it was
+	SourceID   string `json:"sourceId"`
+}
+
+type FileChunkData struct {
+	TransferID string `json:"transferId"`
+	TargetID   string `json:"targetId"`
+	Offset     int64  `json:"offset"`
+	Data       []byte `json:"data"`
+	Final      bool   `json:"final,omitempty"`
+}
+
+// FileCancelData aborts a transfer that was accepted but can't finish, so the
+// other side stops sending or discards what it received.
+type FileCancelData struct {
+	TransferID string `json:"transferId"`
+	TargetID   string `json:"targetId"`
+	Reason     string `json:"reason,omitempty"`
 }
 
 var (
 	upgrader = websocket.Upgrader{
-		ReadBufferSize:  1024,
-		WriteBufferSize: 1024,
+		ReadBufferSize:    1024,
+		WriteBufferSize:   1024,
+		EnableCompression: true, // permessage-deflate, only used if the client offers it; WS_COMPRESSION=false turns it off
 		CheckOrigin:     func(r *http.Request) bool { return true },
 	}
 	clients          = make(map[string]*ClientInfo)
-	broadcast        = make(chan BaseMessage)
+	broadcast        chan BaseMessage   // Buffered to BROADCAST_BUFFER in main; send through queueBroadcast
+	deviceListDirty  = make(chan struct{}, 1) // Pending device_list refresh; many requests coalesce into one
 	register         = make(chan *ClientInfo)
 	unregister       = make(chan *ClientInfo)
 	mutex            = &sync.RWMutex{}
-	currentClip      = ""
-	clipboardLock    = &sync.RWMutex{}
-	apiKey           string
-	clipboardHistory []string
-	historyMutex     sync.Mutex
+	syncDisabled     = make(map[string]bool) // Hostnames with sync turned off, guarded by mutex
+	connectingHostnames = make(map[string]string) // Hostname -> device ID reserved by reserveHostname, guarded by mutex
+	clipboardLock    = &sync.RWMutex{} // Guards channels
+	apiKey           string             // Guarded by apiKeyLock; a SIGHUP can change it, see rekey.go
+	apiKeyLock       = &sync.RWMutex{}
+	statsInterval    = 60 * time.Second // 0 disables the periodic stats broadcast
+	maxClipBytes     = 256 * 1024       // Largest accepted clipboard_update content; 0 disables the check
+	historyDisabled  bool               // DISABLE_HISTORY: relay clips without keeping the current clip or history
+	channelsDisabled bool               // DISABLE_CHANNELS: everyone shares defaultChannel; set_channel is refused
+	transfersDisabled bool              // DISABLE_TRANSFERS: file_offer, file_ack, file_chunk and file_cancel are refused
+	syncEmptyClips   bool               // SYNC_EMPTY_CLIPS: relay cleared clipboards so other devices clear theirs; dropped otherwise
+	trustProxy       bool               // TRUST_PROXY: take the client IP from X-Forwarded-For
+	basePath         string             // BASE_PATH: prefix for every route, e.g. /clipd behind a reverse proxy; "" serves from the root
+	uniqueHostnames  bool               // REQUIRE_UNIQUE_HOSTNAME: refuse a second device with a connected hostname
+	maxHistorySize   = 20               // Entries kept by the server (HISTORY_SIZE); clients page through them
+	compressionLevel = 0                // flate level for compressed connections (WS_COMPRESSION_LEVEL); 0 keeps gorilla's default
+	totalClips       atomic.Int64
+	totalBytes       atomic.Int64
+	broadcastBuffer  = 256 // Capacity of the broadcast queue (BROADCAST_BUFFER)
+	broadcastFull    atomic.Int64 // Sends that found the broadcast queue full and had to wait
+	hubPanics        atomic.Int64 // Panics recovered in the hub; each one dropped an event
+	hubRestartDelay  = time.Second // Pause before superviseHub restarts a stopped hub
+	epoch            = uuid.NewString() // Identifies this run of the server; seqs from another run mean nothing
 )
 
 func loadEnv() {
@@ -79,90 +233,232 @@ func loadEnv() {
 	}
 }
 
-func runHub() {
+// superviseHub runs the hub and starts it again should it ever stop, so a bug in
+// it can't leave the server accepting connections but delivering nothing.
+func superviseHub() {
 	for {
-		select {
-		case client := <-register:
-			mutex.Lock()
-			clients[client.ID] = client
-			log.Printf("Client registered: %s (%s)", client.ID, client.Hostname)
-			mutex.Unlock()
-			broadcastDeviceListUpdate()
-
-		case client := <-unregister:
-			mutex.Lock()
-			if existingClient, ok := clients[client.ID]; ok {
-			
-				if existingClient.Conn == client.Conn {
-					delete(clients, client.ID)
-					existingClient.Conn.Close()
-					log.Printf("Client unregistered: %s (%s)", client.ID, client.Hostname)
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					hubPanics.Add(1)
+					log.Printf("Hub panicked: %v\n%s", r, debug.Stack())
 				}
-			}
-			mutex.Unlock()
-			broadcastDeviceListUpdate()
-
-		case message := <-broadcast:
-			mutex.RLock()
-			activeClients := make([]*ClientInfo, 0, len(clients))
-			for _, client := range clients {
-				activeClients = append(activeClients, client)
-			}
-			mutex.RUnlock() // Release lock before potentially slow network writes
+			}()
+			runHub()
+		}()
+		log.Printf("Hub stopped; restarting it in %s", hubRestartDelay)
+		time.Sleep(hubRestartDelay)
+	}
+}
 
-			msgBytes, err := json.Marshal(message)
-			if err != nil {
-				log.Printf("Error marshalling broadcast message: %v", err)
-				continue
+func runHub() {
+	// A nil channel never fires, so a disabled ticker just drops out of the select
+	var statsTick <-chan time.Time
+	if statsInterval > 0 {
+		ticker := time.NewTicker(statsInterval)
+		defer ticker.Stop()
+		statsTick = ticker.C
+	}
+
+	for {
+		hubStep(statsTick)
+	}
+}
+
+// hubStep handles one hub event. A panic is logged and counted, and only loses
+// that event; the hub carries on with the next.
+func hubStep(statsTick <-chan time.Time) {
+	defer func() {
+		if r := recover(); r != nil {
+			hubPanics.Add(1)
+			log.Printf("Recovered from hub panic: %v\n%s", r, debug.Stack())
+		}
+	}()
+
+	select {
+	case client := <-register:
+		registerClient(client)
+		broadcastDeviceListUpdate()
+
+	case client := <-unregister:
+		unregisterClient(client)
+		broadcastDeviceListUpdate()
+
+	case message := <-broadcast:
+		fanOut(message)
+
+	case <-deviceListDirty:
+		// Snapshot now rather than when it was asked for, so the latest list wins
+		fanOut(BaseMessage{Type: "device_list", Data: DeviceListData{Devices: snapshotDevices()}})
+
+	case <-statsTick:
+		fanOut(BaseMessage{Type: "stats", Data: currentStats()})
+	}
+}
+
+// registerClient adds a client, replacing any stale connection of the same device.
+// Only called from the hub; the deferred unlock keeps a panic from wedging mutex.
+func registerClient(client *ClientInfo) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if client.DeviceID != "" {
+		// Same device reconnecting before its old read loop noticed the drop
+		for id, c := range clients {
+			if c.DeviceID == client.DeviceID {
+				delete(clients, id)
+				c.Conn.Close()
+				log.Printf("Replaced stale connection %s for device %s (%s)", id, client.DeviceID, c.Hostname)
 			}
+		}
+	}
+	clients[client.ID] = client
+	delete(connectingHostnames, client.Hostname) // Now held by clients itself
+	log.Printf("Client registered: %s (%s)", client.ID, client.Hostname)
+}
 
-			for _, client := range activeClients {
-				// Skip sender for certain types
-				if message.Type == "clipboard_update" && client.ID == message.SenderID {
-					continue
-				}
+// unregisterClient removes a client unless it has already been replaced. Only
+// called from the hub.
+func unregisterClient(client *ClientInfo) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if existingClient, ok := clients[client.ID]; ok && existingClient.Conn == client.Conn {
+		delete(clients, client.ID)
+		existingClient.Conn.Close()
+		log.Printf("Client unregistered: %s (%s) from %s after %s", client.ID, client.Hostname,
+			client.RemoteIP, time.Since(client.ConnectedAt).Round(time.Second))
+	}
+}
 
-				// Handle targeted messages
-				targetted := false
-				switch data := message.Data.(type) {
-				case FileAckData:
-					if message.Type == "file_ack" && client.ID != data.SourceID {
-						targetted = true
-					}
-				case FileOfferData:
-					if message.Type == "file_offer" {
-						if data.TargetID != "" && client.ID != data.TargetID {
-							targetted = true
-						}
-						if client.ID == message.SenderID {
-							targetted = true
-						}
-					}
+// fanOut writes a message to every client it is routed to. Only called from the hub.
+func fanOut(message BaseMessage) {
+	mutex.RLock()
+	activeClients := make([]*ClientInfo, 0, len(clients))
+	syncOff := make(map[string]bool)
+	channelOf := make(map[string]string, len(clients))
+	for _, client := range clients {
+		if !client.authDeadline.IsZero() && message.Type != "device_list" && message.Type != "stats" {
+			continue // Nothing that carries clips or files until it has re-authenticated
+		}
+		activeClients = append(activeClients, client)
+		if !client.SyncEnabled {
+			syncOff[client.ID] = true
+		}
+		channelOf[client.ID] = client.Channel
+	}
+	mutex.RUnlock() // Release lock before potentially slow network writes
+
+	msgBytes, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("Error marshalling broadcast message: %v", err)
+		return
+	}
+
+	// Timed so a slow client holding up everyone shows in /admin/metrics and the log
+	start := time.Now()
+	var recipients int
+	var slowest *ClientInfo
+	var slowestTime time.Duration
+	for _, client := range activeClients {
+		// Skip sender for certain types, and devices with sync turned off
+		if message.Type == "clipboard_update" && (client.ID == message.SenderID || syncOff[client.ID]) {
+			continue
+		}
+		if message.Type == "primary_update" && (client.ID == message.SenderID || syncOff[client.ID] || !client.Primary) {
+			continue
+		}
+
+		// Handle targeted messages
+		targetted := false
+		switch data := message.Data.(type) {
+		case FileAckData:
+			if message.Type == "file_ack" && client.ID != data.SourceID {
+				targetted = true
+			}
+		case FileOfferData:
+			if message.Type == "file_offer" {
+				if data.TargetID != "" && client.ID != data.TargetID {
+					targetted = true
 				}
-				if targetted {
-					continue
+				if data.TargetID == "" && data.TargetGroup != "" && !client.inGroup(data.TargetGroup) {
+					targetted = true
 				}
-
-				err := writeToClient(client, websocket.TextMessage, msgBytes)
-				if err != nil {
-					log.Printf("Write error to client %s: %v", client.ID, err)
-				
-			
-					go func(c *ClientInfo) {
-						select {
-						case unregister <- c:
-						default:
-							log.Printf("Unregister channel full or blocked for client %s", c.ID)
-						}
-					}(client)
+				if client.ID == message.SenderID {
+					targetted = true
 				}
 			}
+		case FileChunkData:
+			if message.Type == "file_chunk" && client.ID != data.TargetID {
+				targetted = true
+			}
+		case FileCancelData:
+			if message.Type == "file_cancel" && client.ID != data.TargetID {
+				targetted = true
+			}
+		case ClipboardUpdateData: // Clips and history only go to clients on the same channel
+			if channelOf[client.ID] != data.Channel {
+				targetted = true
+			}
+			if data.TargetGroup != "" && !client.inGroup(data.TargetGroup) {
+				targetted = true
+			}
+		case ClipboardHistoryData:
+			if channelOf[client.ID] != data.Channel {
+				targetted = true
+			}
+		}
+		if targetted {
+			continue
 		}
+
+		writeStart := time.Now()
+		err := writeRecovered(client, msgBytes)
+		recipients++
+		if d := time.Since(writeStart); d > slowestTime {
+			slowest, slowestTime = client, d
+		}
+		if err != nil {
+			log.Printf("Write error to client %s: %v", client.ID, err)
+		
+	
+			go func(c *ClientInfo) {
+				select {
+				case unregister <- c:
+				default:
+					log.Printf("Unregister channel full or blocked for client %s", c.ID)
+				}
+			}(client)
+		}
+	}
+	if recipients == 0 {
+		return
+	}
+	took := time.Since(start)
+	fanOutTimes.observe(took)
+	if slowFanOut > 0 && took > slowFanOut {
+		log.Printf("Slow broadcast: %s to %d clients took %s; slowest was %s (%s) at %s",
+			message.Type, recipients, took.Round(time.Millisecond), slowest.ID, slowest.Hostname, slowestTime.Round(time.Millisecond))
 	}
 }
 
-// Helper to prevent blocking writes from locking up the hub or read loops
+// writeRecovered is writeToClient for fanOut. A panic while writing to one client
+// becomes an error for that client, so the clients after it still get the message.
+func writeRecovered(client *ClientInfo, data []byte) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			hubPanics.Add(1)
+			log.Printf("Recovered from panic writing to %s: %v\n%s", client.ID, r, debug.Stack())
+			err = fmt.Errorf("panic while writing: %v", r)
+		}
+	}()
+	return writeToClient(client, websocket.TextMessage, data)
+}
+
+// Helper to prevent blocking writes from locking up the hub or read loops. Every
+// write to a client goes through here: the hub, its read loop and signal handlers
+// all write, and concurrent writes would make gorilla/websocket panic.
 func writeToClient(client *ClientInfo, messageType int, data []byte) error {
+	client.writeMu.Lock()
+	defer client.writeMu.Unlock()
 	client.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second)) // Add a deadline
 	err := client.Conn.WriteMessage(messageType, data)
 	client.Conn.SetWriteDeadline(time.Time{}) // Clear deadline
@@ -170,72 +466,257 @@ func writeToClient(client *ClientInfo, messageType int, data []byte) error {
 }
 
 
-func broadcastDeviceListUpdate() {
+// sendError reports a rejected message back to the client that sent it.
+func sendError(client *ClientInfo, code, message string) {
+	msgBytes, _ := json.Marshal(BaseMessage{Type: "error", Data: ErrorData{Code: code, Message: message}})
+	writeToClient(client, websocket.TextMessage, msgBytes)
+}
+
+// snapshotDevices copies the public fields of every client, not the Conn.
+func snapshotDevices() []ClientInfo {
 	mutex.RLock()
+	defer mutex.RUnlock()
 	deviceList := make([]ClientInfo, 0, len(clients))
 	for _, c := range clients {
-		// Only include ID and Hostname in broadcast, not the Conn
-		deviceList = append(deviceList, ClientInfo{ID: c.ID, Hostname: c.Hostname})
+		deviceList = append(deviceList, ClientInfo{ID: c.ID, DeviceID: c.DeviceID, Hostname: c.Hostname, DisplayName: c.DisplayName, SyncEnabled: c.SyncEnabled, Channel: c.Channel, RemoteIP: c.RemoteIP, Platform: c.Platform, Groups: c.Groups, ConnectedAt: c.ConnectedAt})
 	}
+	return deviceList
+}
+
+// currentStats gathers the counters sent with the periodic stats message.
+func currentStats() StatsData {
+	mutex.RLock()
+	devices := len(clients)
 	mutex.RUnlock()
+	return StatsData{Clips: totalClips.Load(), Bytes: totalBytes.Load(), Devices: devices}
+}
 
-	message := BaseMessage{
-		Type: "device_list",
-		Data: DeviceListData{Devices: deviceList},
-	}
-	// Send non-blockingly to broadcast channel to avoid deadlock if hub is busy
+// queueBroadcast hands a message to the hub. When the queue is full the sender
+// waits rather than dropping the message, and the stall is counted and logged.
+// Never call it from the hub itself.
+func queueBroadcast(message BaseMessage) {
 	select {
 	case broadcast <- message:
+		return
+	default:
+	}
+	n := broadcastFull.Add(1)
+	log.Printf("Broadcast queue full (%d queued), waiting to send %s; %d stalls so far", len(broadcast), message.Type, n)
+	broadcast <- message
+}
+
+// broadcastDeviceListUpdate asks the hub to send everyone the device list. It never
+// blocks, so the hub can call it too; if a refresh is already pending, that one
+// covers this change as well.
+func broadcastDeviceListUpdate() {
+	select {
+	case deviceListDirty <- struct{}{}:
+	default:
+	}
+}
+
+// reserveHostname claims hostname for a connecting device until registerClient
+// adds it to clients. It fails if another device is connected or connecting under
+// hostname; the same device reconnecting doesn't count, register replaces its old
+// connection. Checking and claiming under one lock keeps two devices connecting
+// at once from both getting the name.
+func reserveHostname(hostname, deviceID string) bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, c := range clients {
+		if c.Hostname == hostname && (deviceID == "" || c.DeviceID != deviceID) {
+			return false
+		}
+	}
+	if d, ok := connectingHostnames[hostname]; ok && (deviceID == "" || d != deviceID) {
+		return false
+	}
+	connectingHostnames[hostname] = deviceID
+	return true
+}
+
+// releaseHostname drops a reservation for a connection that never registered.
+func releaseHostname(hostname string) {
+	mutex.Lock()
+	delete(connectingHostnames, hostname)
+	mutex.Unlock()
+}
+
+// clientIP returns the connecting client's address. Behind a reverse proxy (TRUST_PROXY)
+// that's the right-most X-Forwarded-For entry, the one our proxy appended; entries
+// left of it came from the client and could be forged. Without TRUST_PROXY the
+// header is ignored altogether.
+func clientIP(r *http.Request) string {
+	if trustProxy {
+		if fwd := r.Header.Values("X-Forwarded-For"); len(fwd) > 0 {
+			last := fwd[len(fwd)-1]
+			if i := strings.LastIndex(last, ","); i >= 0 {
+				last = last[i+1:]
+			}
+			if last = strings.TrimSpace(last); last != "" {
+				return last
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ipScope gives a rough idea of where an address is from, without a geo database.
+func ipScope(addr string) string {
+	ip := net.ParseIP(addr)
+	switch {
+	case ip == nil:
+		return "unparsed"
+	case ip.IsLoopback():
+		return "loopback"
+	case ip.IsPrivate(), ip.IsLinkLocalUnicast():
+		return "private"
 	default:
-		log.Println("Broadcast channel full when sending device list update.")
+		return "public"
+	}
+}
+
+// rejectedCredentials names the credentials a refused connection presented, for
+// the log and the client: a stale session token needs a different fix than a wrong key.
+func rejectedCredentials(query url.Values) string {
+	var failed []string
+	if query.Get("apiKey") != "" {
+		failed = append(failed, "invalid API key")
+	}
+	if query.Get("token") != "" {
+		failed = append(failed, "invalid or already used session token")
+	}
+	if query.Get("credential") != "" {
+		failed = append(failed, "unknown device credential")
 	}
+	if len(failed) == 0 {
+		return "no API key, session token or device credential"
+	}
+	return strings.Join(failed, ", ")
 }
 
 func handleConnections(w http.ResponseWriter, r *http.Request) {
-	queryApiKey := r.URL.Query().Get("apiKey")
-	if queryApiKey != apiKey {
-		log.Printf("Auth failed: Invalid API Key from %s", r.RemoteAddr)
-		http.Error(w, "Forbidden: Invalid API Key", http.StatusForbidden)
-		return
+	remoteIP := clientIP(r)
+	var identity string
+	var issueCredential bool
+	if mtlsEnabled {
+		// The TLS handshake already verified the certificate against the client CA
+		identity = clientCertIdentity(r)
+		if identity == "" {
+			log.Printf("Auth failed: no client certificate identity from %s", remoteIP)
+			http.Error(w, "Forbidden: Client certificate required", http.StatusForbidden)
+			return
+		}
+	} else {
+		// The master key, a single-use token from /auth/token, or the credential
+		// a device got in its welcome after connecting with such a token
+		query := r.URL.Query()
+		switch {
+		case query.Get("apiKey") == currentAPIKey():
+		case consumeSessionToken(query.Get("token")):
+			issueCredential = query.Get("deviceId") != ""
+		case checkDeviceCredential(query.Get("credential"), query.Get("deviceId")):
+		default:
+			failed := rejectedCredentials(query)
+			log.Printf("Auth failed: %s from %s", failed, remoteIP)
+			http.Error(w, "Forbidden: "+failed, http.StatusForbidden)
+			return
+		}
 	}
 
 	hostname := r.URL.Query().Get("hostname")
+	if hostname == "" {
+		hostname = identity
+	}
 	if hostname == "" {
 		hostname = "Unknown"
 	}
+	if identity != "" {
+		log.Printf("Client certificate identity %q connecting as %s", identity, hostname)
+	}
+	deviceID := r.URL.Query().Get("deviceId")
+	// Last seq a reconnecting client saw; anything unparsable just means a full resync,
+	// as does a seq from before a restart, when numbering started over
+	resume, _ := strconv.ParseInt(r.URL.Query().Get("resume"), 10, 64)
+	if r.URL.Query().Get("epoch") != epoch {
+		resume = 0
+	}
+	platform := r.URL.Query().Get("platform")
+	if len(platform) > 32 {
+		platform = platform[:32] // Only ever displayed; keep junk short
+	}
+	channel := r.URL.Query().Get("channel")
+	if channelsDisabled {
+		channel = "" // Everyone is on defaultChannel
+	}
+	if len(channel) > maxChannelName {
+		log.Printf("Rejecting connection from %s: channel name longer than %d bytes", remoteIP, maxChannelName)
+		http.Error(w, fmt.Sprintf("Bad Request: channel name longer than %d bytes", maxChannelName), http.StatusBadRequest)
+		return
+	}
+	if uniqueHostnames && !reserveHostname(hostname, deviceID) {
+		log.Printf("Rejecting connection from %s: hostname %q is already connected", remoteIP, hostname)
+		http.Error(w, fmt.Sprintf("Conflict: hostname %q is already connected", hostname), http.StatusConflict)
+		return
+	}
 
 	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("Upgrade error: %v", err)
+		if uniqueHostnames {
+			releaseHostname(hostname)
+		}
 		return
 	}
+	// Both are no-ops on connections where the client didn't negotiate compression
+	ws.EnableWriteCompression(upgrader.EnableCompression)
+	if compressionLevel != 0 {
+		ws.SetCompressionLevel(compressionLevel)
+	}
+
+	mutex.RLock()
+	syncOn := !syncDisabled[hostname]
+	var displayName string
+	if deviceID != "" {
+		displayName = deviceNames[deviceID]
+	}
+	mutex.RUnlock()
 
 	client := &ClientInfo{
-		ID:       uuid.NewString(),
-		Conn:     ws,
-		Hostname: hostname,
+		ID:          uuid.NewString(),
+		DeviceID:    deviceID,
+		Conn:        ws,
+		Hostname:    hostname,
+		DisplayName: displayName,
+		SyncEnabled: syncOn,
+		Channel:     channelName(channel),
+		RemoteIP:    remoteIP,
+		Platform:    platform,
+		Primary:     r.URL.Query().Get("primary") == "1",
+		Groups:      parseGroups(r.URL.Query().Get("groups")),
+		ConnectedAt: time.Now(),
 	}
+	log.Printf("Connection from %s (%s): %s as %s", remoteIP, ipScope(remoteIP), client.ID, hostname)
 	register <- client // Register with the hub
 
-	// Send initial state directly (hub handles subsequent broadcasts)
-	clipboardLock.RLock()
-	current := currentClip
-	clipboardLock.RUnlock()
-	if current != "" {
-		msg := BaseMessage{Type: "clipboard_update", Data: ClipboardUpdateData{Content: current}}
-		msgBytes, _ := json.Marshal(msg)
-		writeToClient(client, websocket.TextMessage, msgBytes) // Use helper
+	welcomeData := WelcomeData{ID: client.ID, Epoch: epoch}
+	if issueCredential {
+		credential, err := issueDeviceCredential(deviceID)
+		if err != nil {
+			log.Printf("Error issuing device credential to %s: %v", hostname, err)
+		}
+		welcomeData.Credential = credential
 	}
+	welcome, _ := json.Marshal(BaseMessage{Type: "welcome", Data: welcomeData})
+	writeToClient(client, websocket.TextMessage, welcome)
+	sendMOTD(client)
 
-	historyMutex.Lock()
-	historyCopy := make([]string, len(clipboardHistory))
-	copy(historyCopy, clipboardHistory)
-	historyMutex.Unlock()
-	if len(historyCopy) > 0 {
-		msg := BaseMessage{Type: "clipboard_history", Data: ClipboardHistoryData{History: historyCopy}}
-		msgBytes, _ := json.Marshal(msg)
-		writeToClient(client, websocket.TextMessage, msgBytes) // Use helper
-	}
+	// Send initial state directly (hub handles subsequent broadcasts)
+	sendChannelState(client, client.Channel, resume)
 
 	// Start the read loop for this client
 	readLoop(client)
@@ -271,73 +752,229 @@ func readLoop(client *ClientInfo) {
 	
 		client.Conn.SetReadDeadline(time.Now().Add(60 * time.Second))
 
+		if over, first := client.inbound.overQuota(len(p)); over {
+			if first {
+				log.Printf("%s (%s) is over the inbound quota of %d bytes/min; dropping its messages", client.ID, client.Hostname, inboundQuota)
+				sendError(client, errQuotaExceeded, fmt.Sprintf("over the server's limit of %d bytes per minute; messages are dropped until usage falls", inboundQuota))
+			}
+			continue
+		}
+
 		if messageType == websocket.TextMessage {
-			var msg BaseMessage
-			if err := json.Unmarshal(p, &msg); err != nil {
+			msg, err := decodeMessage(p)
+			if err != nil {
 				log.Printf("Unmarshal error from %s: %v", client.ID, err)
+				sendError(client, errInvalidMessage, fmt.Sprintf("malformed message: %v", err))
 				continue
 			}
 
 			msg.SenderID = client.ID 
+			if err := validateMessage(msg); err != nil {
+				log.Printf("Rejecting invalid %s from %s: %v", msg.Type, client.Hostname, err)
+				sendError(client, errInvalidMessage, fmt.Sprintf("invalid %s: %v", msg.Type, err))
+				continue
+			}
+
+			if msg.Type == "auth_response" {
+				var data AuthResponseData
+				if err := RemarshalData(msg.Data, &data); err == nil {
+					answerChallenge(client, data)
+				} else {
+					sendError(client, errInvalidMessage, fmt.Sprintf("invalid %s: %v", msg.Type, err))
+				}
+				continue
+			}
+			if authPending(client) {
+				sendError(client, errAuthRequired, "the server's API key changed; answer the auth_challenge first")
+				continue
+			}
+			if !messageTypeEnabled(msg.Type) {
+				sendError(client, errUnsupported, fmt.Sprintf("%s is turned off on this server", msg.Type))
+				continue
+			}
 
 			switch msg.Type {
-			case "clipboard_update":
+			case "clipboard_update", "primary_update":
 				var data ClipboardUpdateData
 				if err := RemarshalData(msg.Data, &data); err == nil {
-					clipboardLock.Lock()
-					if currentClip != data.Content {
-						currentClip = data.Content
-						historyMutex.Lock()
-						clipboardHistory = append([]string{currentClip}, clipboardHistory...)
-						if len(clipboardHistory) > maxHistorySize {
-							clipboardHistory = clipboardHistory[:maxHistorySize]
+					mutex.RLock()
+					syncOn := client.SyncEnabled
+					mutex.RUnlock()
+					if !syncOn {
+						log.Printf("Ignoring %s from %s: sync disabled for device", msg.Type, client.Hostname)
+						sendError(client, errSyncDisabled, "clipboard sync is disabled for this device by the server")
+						continue
+					}
+					if maxClipBytes > 0 && len(data.Content) > maxClipBytes {
+						log.Printf("Rejecting %s from %s: %d bytes exceeds limit of %d", msg.Type, client.Hostname, len(data.Content), maxClipBytes)
+						sendError(client, errClipTooLarge, fmt.Sprintf("clip of %d bytes exceeds the server limit of %d bytes", len(data.Content), maxClipBytes))
+						continue
+					}
+					if data.Content == "" && !syncEmptyClips {
+						log.Printf("Dropping empty %s from %s (SYNC_EMPTY_CLIPS is off)", msg.Type, client.Hostname)
+						continue
+					}
+					data.Channel = clientChannel(client) // Clients send on the channel they've selected
+					if msg.Type == "primary_update" || data.TargetGroup != "" {
+						// Selections change constantly, and history is shared by the whole channel,
+						// so these are only relayed: no history, seq or ack
+						if data.TargetGroup != "" {
+							log.Printf("%s pushed a clip to group %q", client.Hostname, data.TargetGroup)
 						}
-						historyMutex.Unlock()
-
-						broadcastMsg := BaseMessage{Type: "clipboard_update", Data: data, SenderID: client.ID}
-						broadcast <- broadcastMsg 
+						data.Initial, data.Resend, data.Seq = false, false, 0
+						audit.Record(msg.Type, client, int64(len(data.Content)))
+						queueBroadcast(BaseMessage{Type: msg.Type, Data: data, SenderID: client.ID})
+						continue
+					}
+					seq, err := acceptClip(data, client.ID)
+					if err == errTooManyChannels {
+						log.Printf("Rejecting clip from %s: channel %q would exceed MAX_CHANNELS (%d)", client.Hostname, data.Channel, maxChannels)
+						sendError(client, errChannelLimit, fmt.Sprintf("the server already has %d channels; use an existing one", maxChannels))
+						continue
+					}
+					audit.Record(msg.Type, client, int64(len(data.Content))) // Only clips that got through
+					if seq > 0 {
+						ack, _ := json.Marshal(BaseMessage{Type: "clipboard_ack", Data: ClipAckData{Digest: clipDigest(data.Content), Seq: seq}})
+						writeToClient(client, websocket.TextMessage, ack)
 					}
-					clipboardLock.Unlock()
 				} else {
 					log.Printf("Error unmarshalling clipboard_update data from %s: %v", client.ID, err)
+					sendError(client, errInvalidMessage, fmt.Sprintf("invalid %s: %v", msg.Type, err))
 				}
 
 			case "request_devices":
-				mutex.RLock()
-				deviceList := make([]ClientInfo, 0, len(clients))
-				for _, c := range clients {
-					deviceList = append(deviceList, ClientInfo{ID: c.ID, Hostname: c.Hostname})
-				}
-				mutex.RUnlock()
-				response := BaseMessage{Type: "device_list", Data: DeviceListData{Devices: deviceList}}
+				response := BaseMessage{Type: "device_list", Data: DeviceListData{Devices: snapshotDevices()}}
 				respBytes, _ := json.Marshal(response)
 				writeToClient(client, websocket.TextMessage, respBytes) // Use helper
 
+			case "request_history":
+				req := HistoryRequestData{Limit: historyPageSize}
+				if msg.Data != nil {
+					if err := RemarshalData(msg.Data, &req); err != nil {
+						log.Printf("Error unmarshalling request_history data from %s: %v", client.ID, err)
+						sendError(client, errInvalidMessage, fmt.Sprintf("invalid %s: %v", msg.Type, err))
+						continue
+					}
+				}
+				page := historyPage(clientChannel(client), req.Offset, req.Limit)
+				if req.Before > 0 {
+					page = historyBefore(clientChannel(client), req.Before, req.Limit)
+				}
+				response := BaseMessage{Type: "clipboard_history", Data: page}
+				respBytes, _ := json.Marshal(response)
+				writeToClient(client, websocket.TextMessage, respBytes)
+
+			case "delete_history_entry":
+				var data HistoryEntryData
+				if err := RemarshalData(msg.Data, &data); err == nil {
+					audit.Record("delete_history_entry", client, int64(len(data.Content)))
+					channel := clientChannel(client)
+					if !deleteHistoryEntry(channel, data.Content, data.Encoding) {
+						continue // Already deleted, e.g. by another client at the same time
+					}
+					log.Printf("History entry deleted by %s", client.Hostname)
+					queueBroadcast(BaseMessage{Type: "clipboard_history", Data: historyRemoval(channel, data.Content, data.Encoding)})
+				} else {
+					log.Printf("Error unmarshalling delete_history_entry data from %s: %v", client.ID, err)
+					sendError(client, errInvalidMessage, fmt.Sprintf("invalid %s: %v", msg.Type, err))
+				}
+
+			case "clear_history":
+				audit.Record("clear_history", client, 0)
+				channel := clientChannel(client)
+				clearChannel(channel)
+				log.Printf("History of channel %q cleared by %s", channel, client.Hostname)
+				queueBroadcast(BaseMessage{Type: "clipboard_history", Data: ClipboardHistoryData{Channel: channel, History: []HistoryEntryData{}, Cleared: true}})
+
+			case "search_history":
+				var data SearchHistoryData
+				if err := RemarshalData(msg.Data, &data); err == nil {
+					response := BaseMessage{Type: "search_results", Data: searchHistory(clientChannel(client), data.Query)}
+					responseBytes, _ := json.Marshal(response)
+					writeToClient(client, websocket.TextMessage, responseBytes)
+				} else {
+					log.Printf("Error unmarshalling search_history data from %s: %v", client.ID, err)
+					sendError(client, errInvalidMessage, fmt.Sprintf("invalid %s: %v", msg.Type, err))
+				}
+
+			case "server_info":
+				responseBytes, _ := json.Marshal(BaseMessage{Type: "server_info", Data: serverInfo()})
+				writeToClient(client, websocket.TextMessage, responseBytes)
+
+			case "request_clip": // Lets a client check what the server has, apart from history
+				response := BaseMessage{Type: "current_clip", Data: currentClip(clientChannel(client))}
+				responseBytes, _ := json.Marshal(response)
+				writeToClient(client, websocket.TextMessage, responseBytes)
+
+			case "set_channel":
+				var data ChannelData
+				if err := RemarshalData(msg.Data, &data); err == nil {
+					channel := channelName(data.Channel)
+					mutex.Lock()
+					client.Channel = channel
+					mutex.Unlock()
+					log.Printf("%s switched to channel %q", client.Hostname, channel)
+					sendChannelState(client, channel, 0)
+				} else {
+					log.Printf("Error unmarshalling set_channel data from %s: %v", client.ID, err)
+					sendError(client, errInvalidMessage, fmt.Sprintf("invalid %s: %v", msg.Type, err))
+				}
+
 			case "file_offer":
 				var data FileOfferData
 				if err := RemarshalData(msg.Data, &data); err == nil {
 					log.Printf("Received file offer '%s' from %s", data.Filename, client.Hostname)
-					broadcast <- msg // Let hub handle routing
+					audit.Record("file_offer", client, data.Filesize)
+					msg.Data = data  // Typed data so the hub can route it
+					queueBroadcast(msg) // Let hub handle routing
 				} else {
 					log.Printf("Error unmarshalling file_offer data from %s: %v", client.ID, err)
+					sendError(client, errInvalidMessage, fmt.Sprintf("invalid %s: %v", msg.Type, err))
 				}
 
 			case "file_ack":
 				var data FileAckData
 				if err := RemarshalData(msg.Data, &data); err == nil {
 					log.Printf("Received file ack '%v' for '%s' from %s", data.Allow, data.Filename, client.Hostname)
-					broadcast <- msg // Let hub handle routing
+					audit.Record("file_ack", client, 0)
+					msg.Data = data
+					queueBroadcast(msg) // Let hub handle routing
 				} else {
 					log.Printf("Error unmarshalling file_ack data from %s: %v", client.ID, err)
+					sendError(client, errInvalidMessage, fmt.Sprintf("invalid %s: %v", msg.Type, err))
+				}
+
+			case "file_chunk":
+				var data FileChunkData
+				if err := RemarshalData(msg.Data, &data); err == nil && data.TargetID != "" {
+					msg.Data = data
+					queueBroadcast(msg) // Hub delivers only to TargetID
+				} else if err == nil {
+					sendError(client, errInvalidMessage, "file_chunk needs a targetId")
+				} else {
+					log.Printf("Error unmarshalling file_chunk data from %s: %v", client.ID, err)
+					sendError(client, errInvalidMessage, fmt.Sprintf("invalid %s: %v", msg.Type, err))
+				}
+
+			case "file_cancel":
+				var data FileCancelData
+				if err := RemarshalData(msg.Data, &data); err == nil {
+					log.Printf("%s cancelled transfer %s: %q", client.Hostname, data.TransferID, data.Reason)
+					msg.Data = data
+					queueBroadcast(msg) // Hub delivers only to TargetID
+				} else {
+					log.Printf("Error unmarshalling file_cancel data from %s: %v", client.ID, err)
+					sendError(client, errInvalidMessage, fmt.Sprintf("invalid %s: %v", msg.Type, err))
 				}
 
 			default:
 				log.Printf("Received unknown message type '%s' from %s", msg.Type, client.Hostname)
+				sendError(client, errUnknownType, fmt.Sprintf("unknown message type %q", msg.Type))
 			}
 
 		} else if messageType == websocket.BinaryMessage {
-			log.Printf("Received binary message from %s (%d bytes) - Potential file chunk (IGNORED)", client.ID, len(p))
-			// TODO: Implement file chunk handling logic
+			log.Printf("Rejected binary frame from %s (%d bytes): not supported", client.ID, len(p))
+			sendError(client, errUnsupported, "binary frames are not supported; send file_chunk messages")
 		}
 	}
 }
@@ -348,7 +985,11 @@ func RemarshalData(data interface{}, target interface{}) error {
 	if err != nil {
 		return err
 	}
-	return json.Unmarshal(jsonData, target)
+	dec := json.NewDecoder(bytes.NewReader(jsonData))
+	if strictJSON {
+		dec.DisallowUnknownFields() // Covers the payload; decodeMessage checked the envelope
+	}
+	return dec.Decode(target)
 }
 
 func healthCheck(w http.ResponseWriter, r *http.Request) {
@@ -363,17 +1004,243 @@ func main() {
 		port = "8080"
 	}
 	addr := ":" + port
+	if v := os.Getenv("BASE_PATH"); v != "" {
+		p := "/" + strings.Trim(v, "/")
+		if p != path.Clean(p) || strings.ContainsAny(p, "?#") {
+			log.Fatalf("Error: invalid BASE_PATH %q", v)
+		}
+		if p != "/" {
+			basePath = p
+		}
+	}
 
-	clipboardHistory = make([]string, 0, maxHistorySize)
+	if v := os.Getenv("STATS_INTERVAL"); v != "" {
+		secs, err := strconv.Atoi(v)
+		if err != nil {
+			log.Fatalf("Error: invalid STATS_INTERVAL %q", v)
+		}
+		statsInterval = time.Duration(secs) * time.Second
+	}
+	if v := os.Getenv("SESSION_TOKEN_TTL"); v != "" {
+		ttl, err := time.ParseDuration(v)
+		if err != nil || ttl <= 0 {
+			log.Fatalf("Error: invalid SESSION_TOKEN_TTL %q", v)
+		}
+		sessionTokenTTL = ttl
+	}
+	if v := os.Getenv("RESTART_DOWNTIME_HINT"); v != "" {
+		hint, err := time.ParseDuration(v)
+		if err != nil || hint < 0 {
+			log.Fatalf("Error: invalid RESTART_DOWNTIME_HINT %q", v)
+		}
+		restartHint = hint
+	}
+	if v := os.Getenv("SLOW_BROADCAST_MS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			log.Fatalf("Error: invalid SLOW_BROADCAST_MS %q", v)
+		}
+		slowFanOut = time.Duration(n) * time.Millisecond
+	}
+	if v := os.Getenv("BROADCAST_BUFFER"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			log.Fatalf("Error: invalid BROADCAST_BUFFER %q", v)
+		}
+		broadcastBuffer = n
+	}
+	broadcast = make(chan BaseMessage, broadcastBuffer)
+	motd = os.Getenv("MOTD")
+	if v := os.Getenv("STRICT_JSON"); v != "" {
+		on, err := strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("Error: invalid STRICT_JSON %q", v)
+		}
+		strictJSON = on
+	}
+	if v := os.Getenv("AUTH_CHALLENGE_WINDOW"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Fatalf("Error: invalid AUTH_CHALLENGE_WINDOW %q", v)
+		}
+		authChallengeWindow = d
+	}
+	if v := os.Getenv("INBOUND_QUOTA_BYTES"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			log.Fatalf("Error: invalid INBOUND_QUOTA_BYTES %q", v)
+		}
+		inboundQuota = n
+	}
+	if v := os.Getenv("MAX_CLIP_BYTES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			log.Fatalf("Error: invalid MAX_CLIP_BYTES %q", v)
+		}
+		maxClipBytes = n
+	}
+	if v := os.Getenv("MAX_CHANNELS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			log.Fatalf("Error: invalid MAX_CHANNELS %q", v)
+		}
+		maxChannels = n
+	}
+	if v := os.Getenv("HISTORY_SIZE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			log.Fatalf("Error: invalid HISTORY_SIZE %q", v)
+		}
+		maxHistorySize = n
+	}
+	if v := os.Getenv("DISABLE_HISTORY"); v != "" {
+		off, err := strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("Error: invalid DISABLE_HISTORY %q", v)
+		}
+		historyDisabled = off
+	}
+	if historyDisabled {
+		log.Println("History disabled: clips are relayed live and not retained")
+	}
+	if v := os.Getenv("DISABLE_CHANNELS"); v != "" {
+		off, err := strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("Error: invalid DISABLE_CHANNELS %q", v)
+		}
+		channelsDisabled = off
+	}
+	if v := os.Getenv("DISABLE_TRANSFERS"); v != "" {
+		off, err := strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("Error: invalid DISABLE_TRANSFERS %q", v)
+		}
+		transfersDisabled = off
+	}
+	if v := os.Getenv("SYNC_EMPTY_CLIPS"); v != "" {
+		on, err := strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("Error: invalid SYNC_EMPTY_CLIPS %q", v)
+		}
+		syncEmptyClips = on
+	}
+	if v := os.Getenv("REQUIRE_UNIQUE_HOSTNAME"); v != "" {
+		on, err := strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("Error: invalid REQUIRE_UNIQUE_HOSTNAME %q", v)
+		}
+		uniqueHostnames = on
+	}
+	if v := os.Getenv("TRUST_PROXY"); v != "" {
+		on, err := strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("Error: invalid TRUST_PROXY %q", v)
+		}
+		trustProxy = on
+	}
+	if v := os.Getenv("WS_COMPRESSION"); v != "" {
+		on, err := strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("Error: invalid WS_COMPRESSION %q", v)
+		}
+		upgrader.EnableCompression = on
+	}
+	if v := os.Getenv("WS_COMPRESSION_LEVEL"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < flate.HuffmanOnly || n > flate.BestCompression || n == 0 {
+			log.Fatalf("Error: invalid WS_COMPRESSION_LEVEL %q (want -2..9, not 0)", v)
+		}
+		compressionLevel = n
+	}
 
-	go runHub() 
 
-	http.HandleFunc("/ws", handleConnections)
-	http.HandleFunc("/health", healthCheck)
+	if path := os.Getenv("DEVICE_NAMES_FILE"); path != "" {
+		if err := loadDeviceNames(path); err != nil {
+			log.Fatalf("Error: could not load device names from %s: %v", path, err)
+		}
+		deviceNamesFile = path
+	}
 
-	log.Println("HTTP server starting on", addr)
-	err := http.ListenAndServe(addr, nil)
+	if path := os.Getenv("DEVICE_CREDENTIALS_FILE"); path != "" {
+		if err := loadDeviceCredentials(path); err != nil {
+			log.Fatalf("Error: could not load device credentials from %s: %v", path, err)
+		}
+		deviceCredentialsFile = path
+	}
+
+	if path := os.Getenv("AUDIT_LOG_FILE"); path != "" {
+		a, err := openAuditLog(path)
+		if err != nil {
+			log.Fatalf("Error: could not open audit log %s: %v", path, err)
+		}
+		audit = a
+		log.Println("Audit logging to", path)
+	}
+
+	go superviseHub()
+	go sweepSessionTokens()
+
+	if addr := os.Getenv("PPROF_ADDR"); addr != "" {
+		startPprof(addr)
+	}
+
+	// Own mux rather than DefaultServeMux, which net/http/pprof registers itself on
+	mux := http.NewServeMux()
+	mux.HandleFunc(basePath+"/ws", handleConnections)
+	mux.HandleFunc(basePath+"/health", healthCheck)
+	mux.HandleFunc(basePath+"/auth/token", handleIssueToken)
+	mux.HandleFunc(basePath+"/admin/disconnect-others", handleDisconnectOthers)
+	mux.HandleFunc(basePath+"/admin/device-sync", handleSetDeviceSync)
+	mux.HandleFunc(basePath+"/admin/rename", handleRenameDevice)
+	mux.HandleFunc(basePath+"/admin/devices", handleListDevices)
+	mux.HandleFunc(basePath+"/admin/metrics", handleMetrics)
+	mux.HandleFunc(basePath+"/admin/kick", handleKick)
+	mux.HandleFunc(basePath+"/admin/test-clip", handleTestClip)
+	if basePath != "" {
+		log.Printf("Serving under %s (WebSocket endpoint %s/ws)", basePath, basePath)
+	}
+
+	tlsConfig, err := loadTLSConfig()
 	if err != nil {
+		log.Fatalf("Error: TLS setup failed: %v", err)
+	}
+	if v := os.Getenv("MDNS_ADVERTISE"); v != "" {
+		on, err := strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("Error: invalid MDNS_ADVERTISE %q", v)
+		}
+		portNum, err := strconv.Atoi(port)
+		if on && err != nil {
+			log.Fatalf("Error: MDNS_ADVERTISE needs a numeric PORT, got %q", port)
+		}
+		name := os.Getenv("MDNS_NAME")
+		if name == "" {
+			name, _ = os.Hostname()
+		}
+		if on {
+			// Discovery is a convenience; the server works without it
+			if err := advertiseMDNS(name, portNum, tlsConfig != nil); err != nil {
+				log.Printf("Warning: mDNS advertising disabled: %v", err)
+			}
+		}
+	}
+	srv := &http.Server{Addr: addr, Handler: mux, TLSConfig: tlsConfig}
+	go reloadOnSignal()
+	shutdownDone := make(chan struct{})
+	go func() {
+		shutdownOnSignal(srv)
+		close(shutdownDone)
+	}()
+	if tlsConfig == nil {
+		log.Println("HTTP server starting on", addr)
+		err = srv.ListenAndServe()
+	} else {
+		log.Printf("HTTPS server starting on %s (client certificates required: %v)", addr, mtlsEnabled)
+		err = srv.ListenAndServeTLS("", "") // Certificates come from TLSConfig
+	}
+	if err != http.ErrServerClosed {
 		log.Fatal("ListenAndServe: ", err)
 	}
+	<-shutdownDone // ListenAndServe returns as soon as Shutdown starts
+	log.Println("Server stopped")
 }
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestClientIPTakesProxyAppendedEntry(t *testing.T) {
+	defer func(old bool) { trustProxy = old }(trustProxy)
+	r := httptest.NewRequest("GET", "/ws", nil)
+	r.RemoteAddr = "10.0.0.2:5000"
+	r.Header.Add("X-Forwarded-For", "6.6.6.6, 1.2.3.4")
+
+	trustProxy = false
+	if got := clientIP(r); got != "10.0.0.2" {
+		t.Errorf("without TRUST_PROXY got %q, want the peer address", got)
+	}
+	trustProxy = true
+	if got := clientIP(r); got != "1.2.3.4" {
+		t.Errorf("got %q, want the right-most entry 1.2.3.4", got)
+	}
+	r.Header.Add("X-Forwarded-For", "5.6.7.8")
+	if got := clientIP(r); got != "5.6.7.8" {
+		t.Errorf("with a second header got %q, want 5.6.7.8", got)
+	}
+}
+
+func TestRejectedCredentialsNamesWhatFailed(t *testing.T) {
+	for query, want := range map[string]string{
+		"apiKey=wrong":            "invalid API key",
+		"token=stale&deviceId=d1": "invalid or already used session token",
+		"credential=c&token=t":    "invalid or already used session token, unknown device credential",
+		"hostname=h":              "no API key, session token or device credential",
+	} {
+		q, _ := url.ParseQuery(query)
+		if got := rejectedCredentials(q); got != want {
+			t.Errorf("%s: got %q, want %q", query, got, want)
+		}
+	}
+}
+
+func TestReserveHostnameOnce(t *testing.T) {
+	defer releaseHostname("laptop")
+	var wg sync.WaitGroup
+	var won atomic.Int32
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if reserveHostname("laptop", fmt.Sprintf("device-%d", i)) {
+				won.Add(1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if n := won.Load(); n != 1 {
+		t.Fatalf("%d devices got the hostname, want 1", n)
+	}
+	mutex.RLock()
+	holder := connectingHostnames["laptop"]
+	mutex.RUnlock()
+	if !reserveHostname("laptop", holder) {
+		t.Error("the same device reconnecting was refused its own hostname")
+	}
+}
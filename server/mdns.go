@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// mdnsService is the DNS-SD service type clients browse for.
+const mdnsService = "_clipd._tcp.local."
+
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// mdnsAdvert describes this server in mDNS answers.
+type mdnsAdvert struct {
+	Instance dnsmessage.Name // <name>._clipd._tcp.local.
+	Host     dnsmessage.Name // <name>.local.
+	Port     uint16
+	TXT      []string
+}
+
+// advertiseMDNS answers mDNS queries for _clipd._tcp on the LAN so clients can find
+// the server without SERVER_WS_URL. Only IPv4 addresses are advertised.
+func advertiseMDNS(name string, port int, useTLS bool) error {
+	label := strings.NewReplacer(".", "-", " ", "-").Replace(name) // Dots would split the label
+	instance, err := dnsmessage.NewName(label + "." + mdnsService)
+	if err != nil {
+		return fmt.Errorf("invalid MDNS_NAME: %w", err)
+	}
+	host, err := dnsmessage.NewName(label + ".local.")
+	if err != nil {
+		return fmt.Errorf("invalid MDNS_NAME: %w", err)
+	}
+	tls := "0"
+	if useTLS {
+		tls = "1"
+	}
+	ad := mdnsAdvert{Instance: instance, Host: host, Port: uint16(port), TXT: []string{"path=" + basePath + "/ws", "tls=" + tls}}
+
+	conn, err := net.ListenMulticastUDP("udp4", nil, mdnsGroup)
+	if err != nil {
+		return fmt.Errorf("joining mDNS group: %w", err)
+	}
+	log.Printf("Advertising %s on mDNS", instance)
+	go serveMDNS(conn, ad)
+	return nil
+}
+
+func serveMDNS(conn *net.UDPConn, ad mdnsAdvert) {
+	service := dnsmessage.MustNewName(mdnsService)
+	buf := make([]byte, 9000)
+	for {
+		n, src, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			log.Printf("mDNS read error, no longer advertising: %v", err)
+			return
+		}
+		var p dnsmessage.Parser
+		hdr, err := p.Start(buf[:n])
+		if err != nil || hdr.Response {
+			continue
+		}
+		questions, err := p.AllQuestions()
+		if err != nil {
+			continue
+		}
+		for _, q := range questions {
+			if q.Name != service && q.Name != ad.Instance {
+				continue
+			}
+			// Queries from a port other than 5353 come from simple resolvers that
+			// only listen for a unicast reply (RFC 6762 section 6.7)
+			legacy := src.Port != mdnsGroup.Port
+			resp, err := ad.response(hdr.ID, q, legacy)
+			if err != nil {
+				log.Printf("mDNS: building response: %v", err)
+				break
+			}
+			dst := mdnsGroup
+			if legacy {
+				dst = src
+			}
+			if _, err := conn.WriteToUDP(resp, dst); err != nil {
+				log.Printf("mDNS: write to %s failed: %v", dst, err)
+			}
+			break
+		}
+	}
+}
+
+// response builds the PTR answer plus the SRV, TXT and A records needed to connect.
+// Addresses are looked up each time, since the point is to follow the server's IP.
+func (ad mdnsAdvert) response(id uint16, q dnsmessage.Question, legacy bool) ([]byte, error) {
+	if !legacy {
+		id = 0 // Multicast responses carry no ID
+	}
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, Response: true, Authoritative: true})
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if legacy {
+		if err := b.Question(q); err != nil {
+			return nil, err
+		}
+	}
+	rr := func(name dnsmessage.Name) dnsmessage.ResourceHeader {
+		return dnsmessage.ResourceHeader{Name: name, Class: dnsmessage.ClassINET, TTL: 120}
+	}
+	if err := b.StartAnswers(); err != nil {
+		return nil, err
+	}
+	if err := b.PTRResource(rr(dnsmessage.MustNewName(mdnsService)), dnsmessage.PTRResource{PTR: ad.Instance}); err != nil {
+		return nil, err
+	}
+	if err := b.StartAdditionals(); err != nil {
+		return nil, err
+	}
+	if err := b.SRVResource(rr(ad.Instance), dnsmessage.SRVResource{Port: ad.Port, Target: ad.Host}); err != nil {
+		return nil, err
+	}
+	if err := b.TXTResource(rr(ad.Instance), dnsmessage.TXTResource{TXT: ad.TXT}); err != nil {
+		return nil, err
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addrs {
+		ipNet, ok := a.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() || ipNet.IP.To4() == nil {
+			continue
+		}
+		var ip [4]byte
+		copy(ip[:], ipNet.IP.To4())
+		if err := b.AResource(rr(ad.Host), dnsmessage.AResource{A: ip}); err != nil {
+			return nil, err
+		}
+	}
+	return b.Finish()
+}
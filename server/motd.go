@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// motd is the message of the day (MOTD), sent to each client right after welcome.
+// Empty sends nothing. A SIGHUP can change it, see reloadOnSignal.
+var (
+	motd     string
+	motdLock sync.RWMutex
+)
+
+// MOTDData is the payload of motd. An empty Text, only sent when a reload clears
+// the MOTD, tells clients to drop the one they're showing.
+type MOTDData struct {
+	Text string `json:"text"`
+}
+
+func currentMOTD() string {
+	motdLock.RLock()
+	defer motdLock.RUnlock()
+	return motd
+}
+
+// sendMOTD gives a newly connected client the MOTD, if there is one.
+func sendMOTD(client *ClientInfo) {
+	text := currentMOTD()
+	if text == "" {
+		return
+	}
+	msg, _ := json.Marshal(BaseMessage{Type: "motd", Data: MOTDData{Text: text}})
+	writeToClient(client, websocket.TextMessage, msg)
+}
+
+// setMOTD replaces the MOTD and, if it changed, sends the new one to everyone
+// connected. It reports whether it changed.
+func setMOTD(text string) bool {
+	motdLock.Lock()
+	changed := text != motd
+	motd = text
+	motdLock.Unlock()
+	if changed {
+		queueBroadcast(BaseMessage{Type: "motd", Data: MOTDData{Text: text}})
+	}
+	return changed
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+const maxDisplayName = 64
+
+// deviceNames maps DeviceID to the display name set with /admin/rename, so a
+// name sticks when the device reconnects. Guarded by mutex. Saved to
+// deviceNamesFile (DEVICE_NAMES_FILE) when set, otherwise kept until restart.
+var (
+	deviceNames     = make(map[string]string)
+	deviceNamesFile string
+)
+
+// loadDeviceNames reads the saved names; a missing file just means none yet.
+func loadDeviceNames(path string) error {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	names := make(map[string]string)
+	if err := json.Unmarshal(b, &names); err != nil {
+		return err
+	}
+	mutex.Lock()
+	deviceNames = names
+	mutex.Unlock()
+	return nil
+}
+
+// saveDeviceNames writes the names to deviceNamesFile. Callers must not hold mutex.
+func saveDeviceNames() error {
+	if deviceNamesFile == "" {
+		return nil
+	}
+	mutex.RLock()
+	b, err := json.MarshalIndent(deviceNames, "", "  ")
+	mutex.RUnlock()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(deviceNamesFile, b)
+}
+
+// writeFileAtomic replaces path with b through a temp file in the same dir, so
+// a crash mid-write can't truncate it.
+func writeFileAtomic(path string, b []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/http/pprof"
+)
+
+// startPprof serves net/http/pprof on its own listener so profiling is never
+// reachable through the public port. Only started when PPROF_ADDR is set.
+func startPprof(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	go func() {
+		log.Println("pprof server starting on", addr)
+		if err := http.ListenAndServe(addr, requireAPIKey(mux)); err != nil {
+			log.Printf("pprof server stopped: %v", err)
+		}
+	}()
+}
+
+// requireAPIKey guards a handler with the API key, given as the basic auth
+// password (handy for browsers and `go tool pprof`) or however checkAdminAuth accepts it.
+func requireAPIKey(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pass, ok := r.BasicAuth(); ok && pass == currentAPIKey() {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if r.Header.Get("X-API-Key") == "" && r.URL.Query().Get("apiKey") == "" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="clipd pprof"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if checkAdminAuth(w, r) {
+			h.ServeHTTP(w, r)
+		}
+	})
+}
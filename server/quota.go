@@ -0,0 +1,61 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// inboundQuota caps the bytes a client may send per minute (INBOUND_QUOTA_BYTES),
+// counting every frame, file chunks included. 0 disables it.
+var inboundQuota int64
+
+// byteWindow totals a client's inbound bytes over the last minute, in one-second
+// buckets so old traffic drops out gradually rather than all at once.
+type byteWindow struct {
+	mu      sync.Mutex
+	buckets [60]int64
+	stamps  [60]int64 // Unix second each bucket was last written in
+	over    bool      // Over quota on the last add, so the error is sent once per episode
+}
+
+// add counts n bytes at now and returns the minute's total.
+func (w *byteWindow) add(n int, now time.Time) int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	sec := now.Unix()
+	i := sec % int64(len(w.buckets))
+	if w.stamps[i] != sec {
+		w.buckets[i], w.stamps[i] = 0, sec
+	}
+	w.buckets[i] += int64(n)
+	return w.totalLocked(sec)
+}
+
+// total returns the bytes counted over the minute up to now.
+func (w *byteWindow) total(now time.Time) int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.totalLocked(now.Unix())
+}
+
+func (w *byteWindow) totalLocked(sec int64) int64 {
+	var sum int64
+	for i, stamp := range w.stamps {
+		if sec-stamp < int64(len(w.buckets)) {
+			sum += w.buckets[i]
+		}
+	}
+	return sum
+}
+
+// overQuota counts an inbound frame and reports whether it should be dropped. The
+// first drop of an episode is reported back via first, so the client is told once.
+func (w *byteWindow) overQuota(n int) (over, first bool) {
+	used := w.add(n, time.Now())
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	over = inboundQuota > 0 && used > inboundQuota
+	first = over && !w.over
+	w.over = over
+	return over, first
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/joho/godotenv"
+)
+
+// authChallengeWindow is how long connected clients get to present a rotated API
+// key before they're disconnected (AUTH_CHALLENGE_WINDOW).
+var authChallengeWindow = 30 * time.Second
+
+// AuthChallengeData is the payload of auth_challenge, sent to every client when
+// the API key changes. Until a client answers with auth_response, its messages
+// are refused and nothing is relayed to it.
+type AuthChallengeData struct {
+	DeadlineSeconds int `json:"deadlineSeconds"`
+}
+
+// AuthResponseData is the payload of auth_response.
+type AuthResponseData struct {
+	APIKey string `json:"apiKey"`
+}
+
+// currentAPIKey returns the key /ws and the admin endpoints check against.
+func currentAPIKey() string {
+	apiKeyLock.RLock()
+	defer apiKeyLock.RUnlock()
+	return apiKey
+}
+
+// reloadOnSignal re-reads CLIPBOARD_API_KEY and MOTD on every SIGHUP. If the key
+// changed, connections authenticated with the old one have to present the new one;
+// a changed MOTD goes out to everyone.
+func reloadOnSignal() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	for range sig {
+		// Read the file directly: godotenv.Load never overrides a variable that's already set
+		env, err := godotenv.Read("../.env")
+		if err != nil {
+			env = nil
+		}
+		text := os.Getenv("MOTD")
+		if v, ok := env["MOTD"]; ok {
+			text = v // Even if empty, which clears it
+		}
+		if setMOTD(text) {
+			log.Printf("Received SIGHUP; MOTD updated")
+		}
+		rotateAPIKey(env["CLIPBOARD_API_KEY"])
+	}
+}
+
+// rotateAPIKey switches to key, read from .env on SIGHUP, falling back to the
+// environment when .env doesn't set it.
+func rotateAPIKey(key string) {
+	if key == "" {
+		key = os.Getenv("CLIPBOARD_API_KEY")
+	}
+	if key == "" || key == currentAPIKey() {
+		log.Printf("Received SIGHUP; API key unchanged")
+		return
+	}
+	apiKeyLock.Lock()
+	apiKey = key
+	apiKeyLock.Unlock()
+	log.Printf("Received SIGHUP; API key rotated")
+	// Credentials were handed out under the old key; paired devices pair again
+	if err := revokeDeviceCredentials(); err != nil {
+		log.Printf("Error saving device credentials: %v", err)
+	}
+	if mtlsEnabled {
+		return // Connections were authenticated by certificate, not the key
+	}
+	challengeClients()
+}
+
+// challengeClients sends every client an auth_challenge and disconnects those that
+// haven't answered with the new key by the deadline. That includes clients that
+// connected with a session token or device credential: they have no key to answer
+// with, so they are closed and have to authenticate again, or a rotation would
+// never reach them.
+func challengeClients() {
+	deadline := time.Now().Add(authChallengeWindow)
+	msg, _ := json.Marshal(BaseMessage{Type: "auth_challenge", Data: AuthChallengeData{DeadlineSeconds: int(authChallengeWindow.Seconds())}})
+	mutex.Lock()
+	challenged := make([]*ClientInfo, 0, len(clients))
+	for _, c := range clients {
+		c.authDeadline = deadline
+		challenged = append(challenged, c)
+	}
+	mutex.Unlock()
+	for _, c := range challenged {
+		writeToClient(c, websocket.TextMessage, msg)
+	}
+	log.Printf("Challenged %d clients to present the new API key within %s", len(challenged), authChallengeWindow)
+
+	time.AfterFunc(authChallengeWindow, func() {
+		closeMsg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "API key changed")
+		mutex.RLock()
+		defer mutex.RUnlock()
+		for _, c := range clients {
+			// A later challenge moved the deadline; that one's timer handles it
+			if !c.authDeadline.IsZero() && !c.authDeadline.After(deadline) {
+				log.Printf("Disconnecting %s (%s): no new API key by the deadline", c.ID, c.Hostname)
+				c.Conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(time.Second))
+				c.Conn.Close() // The read loop then unregisters it
+			}
+		}
+	})
+}
+
+// authPending reports whether client still has to answer an auth_challenge.
+func authPending(client *ClientInfo) bool {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return !client.authDeadline.IsZero()
+}
+
+// answerChallenge checks an auth_response. A wrong key ends the connection at once.
+func answerChallenge(client *ClientInfo, data AuthResponseData) {
+	if data.APIKey != currentAPIKey() {
+		log.Printf("Wrong API key in auth_response from %s (%s); disconnecting", client.ID, client.Hostname)
+		sendError(client, errAuthFailed, "wrong API key")
+		client.Conn.Close()
+		return
+	}
+	mutex.Lock()
+	client.authDeadline = time.Time{}
+	mutex.Unlock()
+	log.Printf("%s (%s) re-authenticated with the new API key", client.ID, client.Hostname)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// restartHint is the downtime announced to clients on shutdown (RESTART_DOWNTIME_HINT).
+// 0 sends no hint and clients only add jitter to their usual backoff.
+var restartHint time.Duration
+
+// RestartData is the payload of server_restarting.
+type RestartData struct {
+	DowntimeSeconds int `json:"downtimeSeconds,omitempty"`
+}
+
+// shutdownOnSignal waits for SIGINT/SIGTERM, warns clients so they spread out their
+// reconnects instead of all retrying at once, closes their connections, stops srv
+// and flushes the audit log.
+func shutdownOnSignal(srv *http.Server) {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	log.Printf("Received %s, shutting down", <-sig)
+
+	msg, _ := json.Marshal(BaseMessage{Type: "server_restarting", Data: RestartData{DowntimeSeconds: int(restartHint.Seconds())}})
+	closeMsg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server restarting")
+	mutex.RLock()
+	for _, c := range clients {
+		writeToClient(c, websocket.TextMessage, msg)
+		c.Conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(time.Second))
+		c.Conn.Close()
+	}
+	n := len(clients)
+	mutex.RUnlock()
+	log.Printf("Told %d clients the server is restarting", n)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Shutdown: %v", err)
+	}
+	if err := audit.Close(); err != nil {
+		log.Printf("Error closing audit log: %v", err)
+	}
+}
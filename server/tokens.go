@@ -0,0 +1,171 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// sessionTokenTTL is how long an issued token can be used to connect (SESSION_TOKEN_TTL).
+var sessionTokenTTL = 5 * time.Minute
+
+// sessionTokens holds unused tokens and their expiry. Each token opens one
+// WebSocket connection, so the master key never has to appear in /ws URLs.
+var (
+	sessionTokens   = make(map[string]time.Time)
+	sessionTokensMu sync.Mutex
+)
+
+// handleIssueToken returns a fresh single-use token for /ws: POST with the API key.
+func handleIssueToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !checkAdminAuth(w, r) {
+		return
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		http.Error(w, "Could not generate token", http.StatusInternalServerError)
+		return
+	}
+	token := hex.EncodeToString(b)
+	expires := time.Now().Add(sessionTokenTTL)
+	sessionTokensMu.Lock()
+	sessionTokens[token] = expires
+	sessionTokensMu.Unlock()
+
+	log.Printf("Issued session token to %s, valid until %s", clientIP(r), expires.Format(time.RFC3339))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"token": token, "expiresAt": expires})
+}
+
+// consumeSessionToken reports whether token was issued and hasn't expired, and
+// invalidates it either way.
+func consumeSessionToken(token string) bool {
+	if token == "" {
+		return false
+	}
+	sessionTokensMu.Lock()
+	defer sessionTokensMu.Unlock()
+	expires, ok := sessionTokens[token]
+	delete(sessionTokens, token)
+	return ok && time.Now().Before(expires)
+}
+
+// sweepSessionTokens drops tokens that expired without being used.
+func sweepSessionTokens() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for range ticker.C {
+		now := time.Now()
+		sessionTokensMu.Lock()
+		for token, expires := range sessionTokens {
+			if now.After(expires) {
+				delete(sessionTokens, token)
+			}
+		}
+		sessionTokensMu.Unlock()
+	}
+}
+
+// deviceCredentials maps the SHA-256 of each device credential to the DeviceID it
+// was issued to. A device that connects with a session token, e.g. one paired from
+// a QR code, gets a credential in its welcome and reconnects with that, since the
+// token only works once. Each device holds at most one; a new one replaces it, and
+// rotating the API key revokes them all.
+// Saved to deviceCredentialsFile (DEVICE_CREDENTIALS_FILE) when set, otherwise
+// paired devices have to pair again after a restart.
+var (
+	deviceCredentials     = make(map[string]string)
+	deviceCredentialsMu   sync.Mutex
+	deviceCredentialsFile string
+)
+
+func credentialHash(credential string) string {
+	sum := sha256.Sum256([]byte(credential))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueDeviceCredential creates a credential for deviceID, revoking any it had.
+func issueDeviceCredential(deviceID string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	credential := hex.EncodeToString(b)
+	deviceCredentialsMu.Lock()
+	for hash, id := range deviceCredentials {
+		if id == deviceID {
+			delete(deviceCredentials, hash)
+		}
+	}
+	deviceCredentials[credentialHash(credential)] = deviceID
+	deviceCredentialsMu.Unlock()
+	return credential, saveDeviceCredentials()
+}
+
+// checkDeviceCredential reports whether credential was issued to deviceID.
+func checkDeviceCredential(credential, deviceID string) bool {
+	if credential == "" || deviceID == "" {
+		return false
+	}
+	deviceCredentialsMu.Lock()
+	defer deviceCredentialsMu.Unlock()
+	id, ok := deviceCredentials[credentialHash(credential)]
+	return ok && subtle.ConstantTimeCompare([]byte(id), []byte(deviceID)) == 1
+}
+
+// revokeDeviceCredentials drops every issued credential.
+func revokeDeviceCredentials() error {
+	deviceCredentialsMu.Lock()
+	n := len(deviceCredentials)
+	deviceCredentials = make(map[string]string)
+	deviceCredentialsMu.Unlock()
+	if n > 0 {
+		log.Printf("Revoked %d device credentials", n)
+	}
+	return saveDeviceCredentials()
+}
+
+// loadDeviceCredentials reads the saved credentials; a missing file means none yet.
+func loadDeviceCredentials(path string) error {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	creds := make(map[string]string)
+	if err := json.Unmarshal(b, &creds); err != nil {
+		return err
+	}
+	deviceCredentialsMu.Lock()
+	deviceCredentials = creds
+	deviceCredentialsMu.Unlock()
+	return nil
+}
+
+// saveDeviceCredentials writes the credential hashes to deviceCredentialsFile.
+func saveDeviceCredentials() error {
+	if deviceCredentialsFile == "" {
+		return nil
+	}
+	deviceCredentialsMu.Lock()
+	b, err := json.MarshalIndent(deviceCredentials, "", "  ")
+	deviceCredentialsMu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(deviceCredentialsFile, b)
+}
@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+func TestDeviceCredential(t *testing.T) {
+	first, err := issueDeviceCredential("device-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !checkDeviceCredential(first, "device-1") {
+		t.Fatal("fresh credential rejected")
+	}
+	if !checkDeviceCredential(first, "device-1") {
+		t.Fatal("credential only worked once")
+	}
+	if checkDeviceCredential(first, "device-2") {
+		t.Error("credential accepted for another device")
+	}
+	second, err := issueDeviceCredential("device-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkDeviceCredential(first, "device-1") {
+		t.Error("replaced credential still accepted")
+	}
+	if !checkDeviceCredential(second, "device-1") {
+		t.Error("replacement credential rejected")
+	}
+}
+
+func TestDeviceCredentialRevokedOnKeyRotation(t *testing.T) {
+	old := currentAPIKey()
+	defer func() {
+		apiKeyLock.Lock()
+		apiKey = old
+		apiKeyLock.Unlock()
+	}()
+	credential, err := issueDeviceCredential("device-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotateAPIKey(old + "-rotated")
+	if checkDeviceCredential(credential, "device-1") {
+		t.Error("credential still accepted after the API key rotated")
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	maxChannelName = 64
+	maxSearchQuery = 256
+	maxReason      = 256
+	maxClipTTL     = 7 * 24 * 60 * 60 // Seconds; longer-lived clips can just be deleted
+)
+
+// validateMessage checks that a client message's Data has the fields its type
+// requires, so readLoop can reject bad input in one place with a clear reason.
+// Unknown types pass through; readLoop logs and drops them.
+func validateMessage(msg BaseMessage) error {
+	switch msg.Type {
+	case "clipboard_update", "primary_update":
+		var data ClipboardUpdateData
+		if err := RemarshalData(msg.Data, &data); err != nil {
+			return err
+		}
+		if data.Encoding != "" && data.Encoding != "base64" {
+			return fmt.Errorf("unsupported encoding %q", data.Encoding)
+		}
+		if len(data.TargetGroup) > maxGroupName {
+			return fmt.Errorf("group name longer than %d bytes", maxGroupName)
+		}
+		if data.TTLSeconds < 0 || data.TTLSeconds > maxClipTTL {
+			return fmt.Errorf("ttlSeconds must be 0 to %d", maxClipTTL)
+		}
+
+	case "search_history":
+		var data SearchHistoryData
+		if err := RemarshalData(msg.Data, &data); err != nil {
+			return err
+		}
+		if data.Query == "" || len(data.Query) > maxSearchQuery {
+			return fmt.Errorf("query must be 1 to %d bytes", maxSearchQuery)
+		}
+
+	case "set_channel":
+		var data ChannelData
+		if err := RemarshalData(msg.Data, &data); err != nil {
+			return err
+		}
+		if len(data.Channel) > maxChannelName {
+			return fmt.Errorf("channel name longer than %d bytes", maxChannelName)
+		}
+
+	case "request_history":
+		if msg.Data == nil {
+			return nil // First page
+		}
+		var data HistoryRequestData
+		if err := RemarshalData(msg.Data, &data); err != nil {
+			return err
+		}
+		if data.Offset < 0 || data.Before < 0 || data.Limit < 0 {
+			return errors.New("offset, before and limit must not be negative")
+		}
+
+	case "delete_history_entry":
+		var data HistoryEntryData
+		if err := RemarshalData(msg.Data, &data); err != nil {
+			return err
+		}
+		if data.Content == "" {
+			return errors.New("content is required")
+		}
+
+	case "file_offer":
+		var data FileOfferData
+		if err := RemarshalData(msg.Data, &data); err != nil {
+			return err
+		}
+		switch {
+		case data.TransferID == "":
+			return errors.New("transferId is required")
+		case data.Filename == "":
+			return errors.New("filename is required")
+		case data.Filesize < -1: // -1 means unknown size, e.g. a zip streamed on the fly
+			return fmt.Errorf("invalid filesize %d", data.Filesize)
+		case len(data.TargetGroup) > maxGroupName:
+			return fmt.Errorf("group name longer than %d bytes", maxGroupName)
+		}
+
+	case "file_ack":
+		var data FileAckData
+		if err := RemarshalData(msg.Data, &data); err != nil {
+			return err
+		}
+		if data.TransferID == "" || data.SourceID == "" {
+			return errors.New("transferId and sourceId are required")
+		}
+
+	case "file_chunk":
+		var data FileChunkData
+		if err := RemarshalData(msg.Data, &data); err != nil {
+			return err
+		}
+		switch {
+		case data.TransferID == "" || data.TargetID == "":
+			return errors.New("transferId and targetId are required")
+		case data.Offset < 0:
+			return fmt.Errorf("invalid offset %d", data.Offset)
+		}
+
+	case "file_cancel":
+		var data FileCancelData
+		if err := RemarshalData(msg.Data, &data); err != nil {
+			return err
+		}
+		switch {
+		case data.TransferID == "" || data.TargetID == "":
+			return errors.New("transferId and targetId are required")
+		case len(data.Reason) > maxReason:
+			return fmt.Errorf("reason longer than %d bytes", maxReason)
+		}
+	}
+	return nil
+}
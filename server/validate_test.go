@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateFileCancel(t *testing.T) {
+	for _, tc := range []struct {
+		data FileCancelData
+		ok   bool
+	}{
+		{FileCancelData{TransferID: "t1", TargetID: "peer", Reason: "sender failed"}, true},
+		{FileCancelData{TransferID: "t1", TargetID: "peer"}, true},
+		{FileCancelData{TargetID: "peer"}, false},
+		{FileCancelData{TransferID: "t1"}, false},
+		{FileCancelData{TransferID: "t1", TargetID: "peer", Reason: strings.Repeat("x", maxReason+1)}, false},
+	} {
+		err := validateMessage(BaseMessage{Type: "file_cancel", Data: tc.data})
+		if (err == nil) != tc.ok {
+			t.Errorf("%+v: got %v, want ok=%v", tc.data, err, tc.ok)
+		}
+	}
+}
+
+func TestValidateMessage(t *testing.T) {
+	long := func(n int) string { return strings.Repeat("x", n) }
+	for _, tc := range []struct {
+		typ  string
+		data interface{}
+		ok   bool
+	}{
+		{"clipboard_update", ClipboardUpdateData{Content: "hi"}, true},
+		{"clipboard_update", ClipboardUpdateData{Content: "aGk=", Encoding: "base64"}, true},
+		{"clipboard_update", ClipboardUpdateData{Content: "hi", Encoding: "utf16"}, false},
+		{"clipboard_update", ClipboardUpdateData{Content: "hi", TTLSeconds: maxClipTTL}, true},
+		{"clipboard_update", ClipboardUpdateData{Content: "hi", TTLSeconds: maxClipTTL + 1}, false},
+		{"clipboard_update", ClipboardUpdateData{Content: "hi", TTLSeconds: -1}, false},
+		{"clipboard_update", ClipboardUpdateData{Content: "hi", TargetGroup: long(maxGroupName)}, true},
+		{"clipboard_update", ClipboardUpdateData{Content: "hi", TargetGroup: long(maxGroupName + 1)}, false},
+		{"primary_update", ClipboardUpdateData{Content: "hi"}, true},
+		{"primary_update", ClipboardUpdateData{Content: "hi", Encoding: "utf16"}, false},
+		{"primary_update", ClipboardUpdateData{Content: "hi", TTLSeconds: -1}, false},
+		{"search_history", SearchHistoryData{Query: "x"}, true},
+		{"search_history", SearchHistoryData{Query: long(maxSearchQuery)}, true},
+		{"search_history", SearchHistoryData{}, false},
+		{"search_history", SearchHistoryData{Query: long(maxSearchQuery + 1)}, false},
+		{"set_channel", ChannelData{Channel: long(maxChannelName)}, true},
+		{"set_channel", ChannelData{Channel: long(maxChannelName + 1)}, false},
+		{"request_history", nil, true},
+		{"request_history", HistoryRequestData{Offset: 20, Limit: 20}, true},
+		{"request_history", HistoryRequestData{Offset: -1}, false},
+		{"request_history", HistoryRequestData{Before: -1}, false},
+		{"request_history", HistoryRequestData{Limit: -1}, false},
+		{"delete_history_entry", HistoryEntryData{Content: "x"}, true},
+		{"delete_history_entry", HistoryEntryData{}, false},
+		{"file_offer", FileOfferData{TransferID: "t1", Filename: "a.txt", Filesize: 3}, true},
+		{"file_offer", FileOfferData{TransferID: "t1", Filename: "a.zip", Filesize: -1}, true},
+		{"file_offer", FileOfferData{TransferID: "t1", Filesize: 3}, false},
+		{"file_offer", FileOfferData{TransferID: "t1", Filename: "a.txt", Filesize: -2}, false},
+	} {
+		err := validateMessage(BaseMessage{Type: tc.typ, Data: tc.data})
+		if (err == nil) != tc.ok {
+			t.Errorf("%s %+v: got %v, want ok=%v", tc.typ, tc.data, err, tc.ok)
+		}
+	}
+}